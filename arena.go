@@ -2,36 +2,125 @@ package leveldb
 
 import (
   "flag"
+  "sync/atomic"
+  "unsafe"
 )
 
 var blockSize = flag.Int("arena_block_size", 4096, "")
 
+// An arenaRef addresses memory allocated by an Arena without holding a Go
+// pointer, so that structures stored in the arena are not scanned by the
+// garbage collector. The high 32 bits hold the index of the block plus one,
+// the low 32 bits the offset in the block. The zero arenaRef refers to
+// nothing.
+type arenaRef uint64
+
+const nilArenaRef arenaRef = 0
+
+func newArenaRef(block, offset int) arenaRef {
+  return arenaRef(uint64(block + 1) << 32 | uint64(offset))
+}
+
+func (ref arenaRef) block() int {
+  return int(ref >> 32) - 1
+}
+
+func (ref arenaRef) offset() int {
+  return int(uint32(ref))
+}
+
+// Arena hands out memory from large blocks. Allocations are made by a single
+// writer; the memory can be resolved from an arenaRef by concurrent readers.
 type Arena struct {
   allocPtr []byte
   allocBytesRemaining int
-  memoryUsage int
-  blocks [][]byte
+  allocBlock int  // Index of the block allocPtr refers to
+  memoryUsage atomic.Int64
+
+  // The allocated blocks. Readers may resolve refs while a block is being
+  // added, so the slice is published with an atomic store rather than
+  // modified in place.
+  blocks atomic.Pointer[[][]byte]
 }
 
 // Private methods
-func (a *Arena) allocateFallback(nBytes int) []byte {
+func (a *Arena) allocateFallback(nBytes int) ([]byte, arenaRef) {
   if nBytes > *blockSize / 4 {
-    // Object is more than a quarter of the block size.
-    s := a.allocateNewBlock(nBytes)
-    return s
+    // Object is more than a quarter of the block size. Allocate it
+    // separately to avoid wasting too much space in leftover bytes.
+    return a.allocateNewBlock(nBytes)
   }
 
-  a.allocPtr = a.allocateNewBlock(*blockSize)
+  // We waste the remaining space in the current block.
+  block, ref := a.allocateNewBlock(*blockSize)
+  a.allocPtr = block
+  a.allocBlock = ref.block()
   a.allocBytesRemaining = *blockSize - nBytes
 
-  return a.allocPtr[0:nBytes]
+  return block[0:nBytes:nBytes], ref
 }
 
-func (a *Arena) allocateNewBlock(nBytes int) []byte {
+// Blocks come from the Go allocator, which aligns objects of 8 bytes or
+// more to at least 8 bytes.
+func (a *Arena) allocateNewBlock(nBytes int) ([]byte, arenaRef) {
   b := make([]byte, nBytes)
-  a.blocks = append(a.blocks, b)
-  a.memoryUsage += nBytes
-  return b
+  blocks := append(*a.blocks.Load(), b)
+  a.blocks.Store(&blocks)
+  a.memoryUsage.Add(int64(nBytes))
+  return b, newArenaRef(len(blocks) - 1, 0)
+}
+
+// Allocate nBytes whose offset in their block is a multiple of align, a
+// power of two no larger than 8.
+func (a *Arena) allocate(nBytes int, align int) ([]byte, arenaRef) {
+  if nBytes < 0 {
+    panic("Allocate non-positive bytes.")
+  }
+
+  start := len(a.allocPtr) - a.allocBytesRemaining
+  slop := 0
+  if mod := start & (align - 1); mod != 0 {
+    slop = align - mod
+  }
+  needed := nBytes + slop
+  if needed <= a.allocBytesRemaining {
+    start += slop
+    p := a.allocPtr[start:start + nBytes:start + nBytes]
+    a.allocBytesRemaining -= needed
+    return p, newArenaRef(a.allocBlock, start)
+  }
+
+  // allocateFallback always returns aligned memory
+  return a.allocateFallback(nBytes)
+}
+
+// Allocate nBytes aligned for atomic access to 8-byte words, and return
+// their ref.
+func (a *Arena) AllocateAligned(nBytes int) arenaRef {
+  if nBytes <= 0 {
+    panic("Allocate non-positive bytes.")
+  }
+  _, ref := a.allocate(nBytes, 8)
+  return ref
+}
+
+// Return the block ref points into and the offset of ref in it. The same
+// restrictions as for data apply.
+func (a *Arena) block(ref arenaRef) ([]byte, int) {
+  return (*a.blocks.Load())[ref.block()], ref.offset()
+}
+
+// Return the nBytes of memory at ref. Safe to call concurrently with
+// allocations, as long as ref was obtained after it was allocated (e.g. by
+// an atomic load of a word the allocator stored it in).
+func (a *Arena) data(ref arenaRef, nBytes int) []byte {
+  block, off := a.block(ref)
+  return block[off:off + nBytes:off + nBytes]
+}
+
+// Return the 8-byte word at ref, which must be 8-byte aligned.
+func (a *Arena) word(ref arenaRef) *uint64 {
+  return (*uint64)(unsafe.Pointer(&a.data(ref, 8)[0]))
 }
 
 // Public methods
@@ -43,25 +132,19 @@ func (a *Arena) Allocate(nBytes int) []byte {
     return make([]byte, 0)
   }
 
-  if nBytes <= a.allocBytesRemaining {
-    start := len(a.allocPtr) - a.allocBytesRemaining
-    p := a.allocPtr[start:(start + nBytes)]
-    a.allocBytesRemaining -= nBytes
-    return p
-  }
-
-  return a.allocateFallback(nBytes)
+  p, _ := a.allocate(nBytes, 1)
+  return p
 }
 
 func (a *Arena) MemoryUsage() int {
-  return a.memoryUsage
+  return int(a.memoryUsage.Load())
 }
 
 func NewArena() *Arena {
   a := &Arena{}
   a.allocPtr = nil
   a.allocBytesRemaining = 0
-  a.memoryUsage = 0
-  a.blocks = make([][]byte, 0)
+  blocks := make([][]byte, 0)
+  a.blocks.Store(&blocks)
   return a
 }
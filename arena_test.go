@@ -33,3 +33,30 @@ func TestMemoryUsage(t *testing.T) {
     t.Error("Memory usage is incorrect", 4096, a.MemoryUsage())
   }
 }
+
+func TestAllocateAligned(t *testing.T) {
+  a := NewArena()
+
+  rnd := rand.New(rand.NewSource(301))
+  refs := []arenaRef{}
+  for i := 0; i < 1000; i++ {
+    // Interleave unaligned allocations.
+    a.Allocate(1 + rnd.Intn(20))
+    nBytes := 8 + rnd.Intn(2000)
+    ref := a.AllocateAligned(nBytes)
+    if ref == nilArenaRef || ref.offset() % 8 != 0 {
+      t.Fatal("Allocation is not aligned: ", ref.offset())
+    }
+    buf := a.data(ref, nBytes)
+    for j := range(buf) {
+      buf[j] = byte(i)
+    }
+    refs = append(refs, ref)
+  }
+  // No allocation overwrote another one.
+  for i, ref := range(refs) {
+    if a.data(ref, 8)[0] != byte(i) {
+      t.Error("Allocation ", i, " was overwritten.")
+    }
+  }
+}
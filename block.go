@@ -11,8 +11,19 @@ type Block struct {
   data []byte
 }
 
+// The contents of a block that cannot hold its restart array are treated as
+// corrupted, and the iterators of the block report an error.
 func NewBlock(data []byte) *Block {
   b := &Block{data:data}
+  if len(data) < 4 {
+    b.data = nil  // Error marker
+  } else {
+    maxRestartsAllowed := (len(data) - 4) / 4
+    if b.NumRestarts() > maxRestartsAllowed {
+      // The size is too small for NumRestarts()
+      b.data = nil
+    }
+  }
   return b
 }
 
@@ -22,6 +33,12 @@ func (block *Block) NumRestarts() int {
 }
 
 func (block *Block) NewIterator(comparator Comparator) Iterator {
+  if block.data == nil {
+    return newEmptyIterator(CorruptionError("bad block contents"))
+  }
+  if block.NumRestarts() == 0 {
+    return newEmptyIterator(nil)
+  }
   iter := &BlockIterator{}
   iter.data = block.data
   iter.numRestarts = block.NumRestarts()
@@ -30,6 +47,9 @@ func (block *Block) NewIterator(comparator Comparator) Iterator {
   iter.currentOffset = iter.restartOffset
   iter.nextOffset = iter.restartOffset
   iter.comparator = comparator
+  if _, ok := comparator.(*InternalKeyComparator); ok {
+    iter.minKeyLength = 8
+  }
   iter.key = make([]byte, 0)
   iter.value = nil
   return iter
@@ -44,8 +64,12 @@ type BlockIterator struct {
   currentOffset int
   nextOffset int
   comparator Comparator
+  // Keys shorter than this are corrupted. Internal keys end with an 8-byte
+  // tag, which the internal key comparator assumes is there.
+  minKeyLength int
   key []byte
   value []byte
+  status error
 }
 
 func (iter *BlockIterator) Valid() bool {
@@ -70,10 +94,14 @@ func (iter *BlockIterator) Seek(key []byte) {
     mid := (left + right + 1) / 2
     regionOffset := iter.getRestartPoint(mid)
 
-    var shared, nonShared, valueLength int64
-    p, _ := iter.decodeEntry(iter.data[regionOffset:], &shared, &nonShared, &valueLength)
-    if p == nil || shared != 0 {
-      // Corruption error
+    var shared, nonShared, valueLength uint64
+    if regionOffset >= iter.restartOffset {
+      iter.corruptionError()
+      return
+    }
+    p, _ := iter.decodeEntry(iter.data[regionOffset:iter.restartOffset], &shared, &nonShared, &valueLength)
+    if p == nil || shared != 0 || int(nonShared) < iter.minKeyLength {
+      iter.corruptionError()
       return
     }
     midKey := p[:nonShared]
@@ -134,6 +162,25 @@ func (iter *BlockIterator) Value() []byte {
   return iter.value
 }
 
+// Return the corruption found while decoding the block, if any.
+func (iter *BlockIterator) Error() error {
+  return iter.status
+}
+
+// The iterator pins nothing; a cached block is unpinned by the iterator
+// Table.BlockReader wraps this one in.
+func (iter *BlockIterator) Close() error {
+  return iter.status
+}
+
+func (iter *BlockIterator) corruptionError() {
+  iter.currentOffset = iter.restartOffset
+  iter.restartIndex = iter.numRestarts
+  iter.status = CorruptionError("bad entry in block")
+  iter.key = iter.key[:0]
+  iter.value = nil
+}
+
 func (iter *BlockIterator) getRestartPoint(index int) int {
   if index >= iter.numRestarts {
     panic(fmt.Sprint("Beyond restart point:", iter.numRestarts))
@@ -156,10 +203,10 @@ func (iter *BlockIterator) parseNextKey() bool {
     return false
   }
 
-  var shared, nonShared, valueLength int64
-  p, nRead := iter.decodeEntry(iter.data[iter.currentOffset:], &shared, &nonShared, &valueLength)
-  if p == nil || len(iter.key) < int(shared) {
-    // Corruption error
+  var shared, nonShared, valueLength uint64
+  p, nRead := iter.decodeEntry(iter.data[iter.currentOffset:iter.restartOffset], &shared, &nonShared, &valueLength)
+  if p == nil || uint64(len(iter.key)) < shared {
+    iter.corruptionError()
     return false
   } else {
     iter.key = iter.key[:shared]
@@ -167,6 +214,10 @@ func (iter *BlockIterator) parseNextKey() bool {
       iter.key = append(iter.key, p[i])
     }
     iter.value = p[nonShared:nonShared + valueLength]
+    if len(iter.key) < iter.minKeyLength {
+      iter.corruptionError()
+      return false
+    }
 
     for iter.restartIndex + 1 < iter.numRestarts {
       if iter.getRestartPoint(iter.restartIndex + 1) < iter.currentOffset {
@@ -182,20 +233,25 @@ func (iter *BlockIterator) parseNextKey() bool {
   }
 }
 
-func (iter *BlockIterator) decodeEntry(b []byte, shared *int64, nonShared *int64, valueLength *int64) ([]byte, int) {
+// Decode the header of the entry at the start of b: the number of key bytes
+// shared with the previous entry, the number of key bytes that follow and
+// the length of the value, each stored as an unsigned varint. Return the
+// bytes that follow the header and the header length, or nil if the entry
+// is corrupted.
+func (iter *BlockIterator) decodeEntry(b []byte, shared *uint64, nonShared *uint64, valueLength *uint64) ([]byte, int) {
   reader := bytes.NewReader(b)
   var err error = nil
-  if *shared, err = binary.ReadVarint(reader); err != nil {
-    panic(err)
+  if *shared, err = binary.ReadUvarint(reader); err != nil {
+    return nil, 0
   }
-  if *nonShared, err = binary.ReadVarint(reader); err != nil {
-    panic(err)
+  if *nonShared, err = binary.ReadUvarint(reader); err != nil {
+    return nil, 0
   }
-  if *valueLength, err = binary.ReadVarint(reader); err != nil {
-    panic(err)
+  if *valueLength, err = binary.ReadUvarint(reader); err != nil {
+    return nil, 0
   }
 
-  if int(*nonShared + *valueLength) > reader.Len() {
+  if *nonShared + *valueLength > uint64(reader.Len()) {
     return nil, 0
   }
 
@@ -223,7 +279,7 @@ func NewBlockBuilder(options *Options) *BlockBuilder {
   builder.counter = 0
   builder.finished = false
   builder.lastKey = make([]byte, 0, 8)
-  builder.iBuf = make([]byte, 8)
+  builder.iBuf = make([]byte, binary.MaxVarintLen64)
   return builder
 }
 
@@ -256,11 +312,12 @@ func (builder *BlockBuilder) Add(key, value []byte) {
   }
   nonShared := len(key) - shared
 
-  nwrite := binary.PutVarint(builder.iBuf, int64(shared))
+  // Add "<shared><nonShared><valueSize>" to buffer
+  nwrite := binary.PutUvarint(builder.iBuf, uint64(shared))
   builder.buf.Write(builder.iBuf[:nwrite])
-  nwrite = binary.PutVarint(builder.iBuf, int64(nonShared))
+  nwrite = binary.PutUvarint(builder.iBuf, uint64(nonShared))
   builder.buf.Write(builder.iBuf[:nwrite])
-  nwrite = binary.PutVarint(builder.iBuf, int64(len(value)))
+  nwrite = binary.PutUvarint(builder.iBuf, uint64(len(value)))
   builder.buf.Write(builder.iBuf[:nwrite])
 
   builder.buf.Write(key[shared:])
@@ -2,6 +2,7 @@ package leveldb
 
 import (
   "bytes"
+  "errors"
   "fmt"
   "math/rand"
   "testing"
@@ -128,3 +129,57 @@ func TestComplexBlockIterator(t *testing.T) {
   }
 }
 
+
+func TestBlockCorruption(t *testing.T) {
+  for _, data := range([][]byte{
+    nil,
+    {1, 2},
+    {0xff, 0xff, 0xff, 0x0f},  // Too many restarts
+  }) {
+    iter := NewBlock(data).NewIterator(DefaultComparator)
+    iter.SeekToFirst()
+    if iter.Valid() || !errors.Is(iter.Error(), ErrCorruption) {
+      t.Error("Bad block contents should be reported: ", data)
+    }
+  }
+
+  options := defaultOptions()
+  builder := NewBlockBuilder(options)
+  builder.Add([]byte("a"), []byte("1"))
+  builder.Add([]byte("b"), []byte("2"))
+  data := append([]byte(nil), builder.Finish()...)
+  // Make the value of the first entry overflow the block.
+  data[2] = 0x7f
+  iter := NewBlock(data).NewIterator(DefaultComparator)
+  iter.SeekToFirst()
+  if iter.Valid() || !errors.Is(iter.Error(), ErrCorruption) {
+    t.Error("Bad entry should be reported: ", iter.Error())
+  }
+  iter.Seek([]byte("b"))
+  if iter.Valid() {
+    t.Error("Corrupted block should not yield entries.")
+  }
+}
+
+func TestBlockShortInternalKey(t *testing.T) {
+  options := defaultOptions()
+  options.BlockRestartInterval = 1
+  builder := NewBlockBuilder(options)
+  builder.Add(AppendInternalKey(nil, []byte("a"), 1, TypeValue), []byte("1"))
+  builder.Add([]byte("b"), []byte("2"))  // Too short for an internal key
+  builder.Add(AppendInternalKey(nil, []byte("c"), 1, TypeValue), []byte("3"))
+  block := NewBlock(builder.Finish())
+
+  icmp := NewInternalKeyComparator(DefaultComparator)
+  iter := block.NewIterator(&icmp)
+  iter.SeekToFirst()
+  iter.Next()
+  if iter.Valid() || !errors.Is(iter.Error(), ErrCorruption) {
+    t.Error("Short internal key should be reported: ", iter.Error())
+  }
+  iter = block.NewIterator(&icmp)
+  iter.Seek(AppendInternalKey(nil, []byte("c"), 1, TypeValue))
+  if iter.Valid() || !errors.Is(iter.Error(), ErrCorruption) {
+    t.Error("Short internal key should be reported by Seek: ", iter.Error())
+  }
+}
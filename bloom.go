@@ -18,7 +18,14 @@ type BloomFilter struct {
 func NewBloomFilter(bitsPerKey int) FilterPolicy {
   filter := BloomFilter{}
   filter.bitsPerKey = bitsPerKey
-  filter.k = int(float64(bitsPerKey) * 0.69)
+  // We intentionally round down to reduce probing cost a little bit
+  filter.k = int(float64(bitsPerKey) * 0.69)  // 0.69 =~ ln(2)
+  if filter.k < 1 {
+    filter.k = 1
+  }
+  if filter.k > 30 {
+    filter.k = 30
+  }
   return filter
 }
 
@@ -31,9 +38,10 @@ func (f BloomFilter) CreateFilter(keys [][]byte) []byte {
   if bits < 64 {
     bits = 64
   }
-  bits = (bits + 7 / 8) * 8
+  bytes := (bits + 7) / 8
+  bits = bytes * 8
 
-  out := make([]byte, (bits / 8) + 1)
+  out := make([]byte, bytes + 1)
   out[len(out) - 1] = uint8(f.k)
 
   for i := 0; i < len(keys); i++ {
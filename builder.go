@@ -0,0 +1,52 @@
+package leveldb
+
+import (
+)
+
+// Build a table file from the contents of iter. The generated file is named
+// according to meta.Number. On success, the rest of meta is filled with
+// metadata about the generated table. If no data is present in iter,
+// meta.FileSize is set to zero and no table file is produced.
+func buildTable(dbname string, env Env, options *Options, iter Iterator, meta *FileMetaData) error {
+  meta.FileSize = 0
+  iter.SeekToFirst()
+  if !iter.Valid() {
+    return nil
+  }
+
+  filename := TableFileName(dbname, meta.Number)
+  file, err := env.NewWritableFile(filename)
+  if err != nil {
+    return err
+  }
+  file = newRateLimitedWritableFile(file, options.RateLimiter, IOPriorityBackground)
+
+  builder := NewTableBuilder(options, file)
+  meta.Smallest = append([]byte(nil), iter.Key()...)
+  var key []byte
+  for ; iter.Valid(); iter.Next() {
+    key = iter.Key()
+    builder.Add(key, iter.Value())
+  }
+  meta.Largest = append([]byte(nil), key...)
+
+  // Check for input iterator errors, then finish and check for builder
+  // errors.
+  if err = iter.Error(); err != nil {
+    builder.Abandon()
+  } else {
+    err = builder.Finish()
+  }
+  if err == nil {
+    meta.FileSize = uint64(builder.FileSize())
+    err = file.Sync()
+  }
+  if closeErr := file.Close(); err == nil {
+    err = closeErr
+  }
+
+  if err != nil || meta.FileSize == 0 {
+    env.DeleteFile(filename)
+  }
+  return err
+}
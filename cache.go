@@ -115,12 +115,17 @@ type lruCache struct {
   lru lruCacheHandle
   inUse lruCacheHandle
   table handleTable
+
+  // CacheHandle is an untraced uintptr, so every handle that is still
+  // referenced is kept here until its deleter runs.
+  handles map[*lruCacheHandle]bool
 }
 
 func newLruCache(capacity int) *lruCache {
   cache := &lruCache{}
   cache.SetCapacity(capacity)
   cache.table = newHandleTable()
+  cache.handles = make(map[*lruCacheHandle]bool)
   cache.lru.next = &cache.lru
   cache.lru.prev = &cache.lru
   cache.inUse.next = &cache.inUse
@@ -148,6 +153,7 @@ func (cache *lruCache) Insert(key []byte, hash uint32, value uintptr, charge int
   e.next = nil
   e.prev = nil
   e.deleter = deleter
+  cache.handles[e] = true
 
   if cache.capacity > 0 {
     e.refs++  // For the cache's reference
@@ -229,6 +235,7 @@ func (cache *lruCache) Unref(handle *lruCacheHandle) {
     if handle.inCache {
       panic("")
     }
+    delete(cache.handles, handle)
     handle.deleter(handle.key, handle.value)
   } else if handle.inCache && handle.refs == 1 {
     cache.LRURemove(handle)
@@ -262,6 +269,47 @@ func (cache *lruCache) FinishErase(handle *lruCacheHandle) bool {
   return handle != nil
 }
 
+// Cache values are uintptr, which the garbage collector does not trace.
+// cacheValueMap hands out ids to store Go values in a Cache and keeps the
+// values reachable until their cache entry is deleted.
+type cacheValueMap struct {
+  mu sync.Mutex
+  values map[uintptr]interface{}
+  nextId uintptr
+}
+
+func newCacheValueMap() *cacheValueMap {
+  m := &cacheValueMap{}
+  m.values = make(map[uintptr]interface{})
+  m.nextId = 1
+  return m
+}
+
+// Return the id under which v is kept.
+func (m *cacheValueMap) Add(v interface{}) uintptr {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  id := m.nextId
+  m.nextId++
+  m.values[id] = v
+  return id
+}
+
+func (m *cacheValueMap) Get(id uintptr) interface{} {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  return m.values[id]
+}
+
+// Stop keeping the value with the given id and return it.
+func (m *cacheValueMap) Remove(id uintptr) interface{} {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  v := m.values[id]
+  delete(m.values, id)
+  return v
+}
+
 type sharedLruCache struct {
   mu sync.Mutex
 
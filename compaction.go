@@ -0,0 +1,243 @@
+package leveldb
+
+import (
+)
+
+func targetFileSize(options *Options) uint64 {
+  return uint64(options.MaxFileSize)
+}
+
+// Maximum bytes of overlaps in grandparent (i.e., level+2) before we
+// stop building a single file in a level->level+1 compaction.
+func maxGrandParentOverlapBytes(options *Options) uint64 {
+  return 10 * targetFileSize(options)
+}
+
+// Maximum number of bytes in all compacted files. We avoid expanding
+// the lower level file set of a compaction if it would make the
+// total compaction cover more than this many bytes.
+func expandedCompactionByteSizeLimit(options *Options) uint64 {
+  return 25 * targetFileSize(options)
+}
+
+// The size budget of a level. Level-0 is bounded by the number of files
+// instead.
+func maxBytesForLevel(level int) float64 {
+  // Note: the result for level zero is not really used since we set
+  // the level-0 compaction threshold based on number of files.
+
+  // Result for both level-0 and level-1
+  result := 10. * 1048576.0
+  for level > 1 {
+    result *= 10
+    level--
+  }
+  return result
+}
+
+func maxFileSizeForLevel(options *Options, level int) uint64 {
+  // We could vary per level to reduce number of files?
+  return targetFileSize(options)
+}
+
+// Finds the largest key in a vector of files. Returns false if files is
+// empty.
+func findLargestKey(icmp *InternalKeyComparator, files []*FileMetaData) ([]byte, bool) {
+  if len(files) == 0 {
+    return nil, false
+  }
+  largestKey := files[0].Largest
+  for _, f := range(files[1:]) {
+    if icmp.Compare(f.Largest, largestKey) > 0 {
+      largestKey = f.Largest
+    }
+  }
+  return largestKey, true
+}
+
+// Finds minimum file b2=(l2, u2) in levelFiles for which l2 > u1 and
+// userKey(l2) = userKey(u1).
+func findSmallestBoundaryFile(icmp *InternalKeyComparator, levelFiles []*FileMetaData,
+    largestKey []byte) *FileMetaData {
+  ucmp := icmp.UserComparator()
+  var smallestBoundaryFile *FileMetaData
+  for _, f := range(levelFiles) {
+    if icmp.Compare(f.Smallest, largestKey) > 0 &&
+        ucmp.Compare(ExtractUserKey(f.Smallest), ExtractUserKey(largestKey)) == 0 {
+      if smallestBoundaryFile == nil ||
+          icmp.Compare(f.Smallest, smallestBoundaryFile.Smallest) < 0 {
+        smallestBoundaryFile = f
+      }
+    }
+  }
+  return smallestBoundaryFile
+}
+
+// Extracts the largest file b1 from compactionFiles and then searches for a
+// b2 in levelFiles for which userKey(u1) = userKey(l2). If it finds such a
+// file b2 (known as a boundary file) it adds it to compactionFiles and then
+// searches again using this new upper bound.
+//
+// If there are two blocks, b1=(l1, u1) and b2=(l2, u2) and
+// userKey(u1) = userKey(l2), and if we compact b1 but not b2 then a
+// subsequent get operation will yield an incorrect result because it will
+// return the record from b2 in level i rather than from b1 because it
+// searches level by level for records matching the supplied user key.
+func addBoundaryInputs(icmp *InternalKeyComparator, levelFiles []*FileMetaData,
+    compactionFiles []*FileMetaData) []*FileMetaData {
+  // Quick return if compactionFiles is empty.
+  largestKey, ok := findLargestKey(icmp, compactionFiles)
+  if !ok {
+    return compactionFiles
+  }
+
+  for {
+    smallestBoundaryFile := findSmallestBoundaryFile(icmp, levelFiles, largestKey)
+    if smallestBoundaryFile == nil {
+      break
+    }
+    // If a boundary file was found advance largestKey, otherwise we're done.
+    compactionFiles = append(compactionFiles, smallestBoundaryFile)
+    largestKey = smallestBoundaryFile.Largest
+  }
+  return compactionFiles
+}
+
+// A Compaction encapsulates information about a compaction.
+type Compaction struct {
+  level int
+  maxOutputFileSize uint64
+  maxGrandParentOverlapBytes uint64
+  inputVersion *Version
+  edit *VersionEdit
+
+  // Each compaction reads inputs from "level" and "level+1"
+  inputs [2][]*FileMetaData  // The two sets of inputs
+
+  // State used to check for number of overlapping grandparent files
+  // (parent == level + 1, grandparent == level + 2)
+  grandparents []*FileMetaData
+  grandparentIndex int  // Index in grandparents
+  seenKey bool  // Some output key has been seen
+  overlappedBytes uint64  // Bytes of overlap between current output
+                          // and grandparent files
+
+  // State for implementing IsBaseLevelForKey
+
+  // levelPtrs holds indices into inputVersion.files: our state is that we
+  // are positioned at one of the file ranges for each higher level than the
+  // ones involved in this compaction (i.e. for all L >= level + 2).
+  levelPtrs [NumLevels]int
+}
+
+func newCompaction(options *Options, level int) *Compaction {
+  c := &Compaction{}
+  c.level = level
+  c.maxOutputFileSize = maxFileSizeForLevel(options, level)
+  c.maxGrandParentOverlapBytes = maxGrandParentOverlapBytes(options)
+  c.inputVersion = nil
+  c.edit = NewVersionEdit()
+  return c
+}
+
+// Return the level that is being compacted. Inputs from "level" and
+// "level+1" will be merged to produce a set of "level+1" files.
+func (c *Compaction) Level() int {
+  return c.level
+}
+
+// Return the object that holds the edits to the descriptor done by this
+// compaction.
+func (c *Compaction) Edit() *VersionEdit {
+  return c.edit
+}
+
+// "which" must be either 0 or 1
+func (c *Compaction) NumInputFiles(which int) int {
+  return len(c.inputs[which])
+}
+
+// Return the i-th input file at "level()+which" ("which" must be 0 or 1).
+func (c *Compaction) Input(which, i int) *FileMetaData {
+  return c.inputs[which][i]
+}
+
+// Maximum size of files to build during this compaction.
+func (c *Compaction) MaxOutputFileSize() uint64 {
+  return c.maxOutputFileSize
+}
+
+// Is this a trivial compaction that can be implemented by just moving a
+// single input file to the next level (no merging or splitting)
+func (c *Compaction) IsTrivialMove() bool {
+  // Avoid a move if there is lots of overlapping grandparent data.
+  // Otherwise, the move could create a parent file that will require
+  // a very expensive merge later on.
+  return c.NumInputFiles(0) == 1 && c.NumInputFiles(1) == 0 &&
+      totalFileSize(c.grandparents) <= c.maxGrandParentOverlapBytes
+}
+
+// Add all inputs to this compaction as delete operations to edit.
+func (c *Compaction) AddInputDeletions(edit *VersionEdit) {
+  for which := 0; which < 2; which++ {
+    for _, f := range(c.inputs[which]) {
+      edit.DeleteFile(c.level + which, f.Number)
+    }
+  }
+}
+
+// Returns true if the information we have available guarantees that the
+// compaction is producing data in "level+1" for which no data exists in
+// levels greater than "level+1".
+func (c *Compaction) IsBaseLevelForKey(userKey []byte) bool {
+  // Maybe use binary search to find right entry instead of linear search?
+  ucmp := c.inputVersion.vset.icmp.UserComparator()
+  for level := c.level + 2; level < NumLevels; level++ {
+    files := c.inputVersion.files[level]
+    for c.levelPtrs[level] < len(files) {
+      f := files[c.levelPtrs[level]]
+      if ucmp.Compare(userKey, ExtractUserKey(f.Largest)) <= 0 {
+        // We've advanced far enough
+        if ucmp.Compare(userKey, ExtractUserKey(f.Smallest)) >= 0 {
+          // Key falls in this file's range, so definitely not base level
+          return false
+        }
+        break
+      }
+      c.levelPtrs[level]++
+    }
+  }
+  return true
+}
+
+// Returns true iff we should stop building the current output before
+// processing internalKey.
+func (c *Compaction) ShouldStopBefore(internalKey []byte) bool {
+  icmp := &c.inputVersion.vset.icmp
+  // Scan to find earliest grandparent file that contains key.
+  for c.grandparentIndex < len(c.grandparents) &&
+      icmp.Compare(internalKey, c.grandparents[c.grandparentIndex].Largest) > 0 {
+    if c.seenKey {
+      c.overlappedBytes += c.grandparents[c.grandparentIndex].FileSize
+    }
+    c.grandparentIndex++
+  }
+  c.seenKey = true
+
+  if c.overlappedBytes > c.maxGrandParentOverlapBytes {
+    // Too much overlap for current output; start new output
+    c.overlappedBytes = 0
+    return true
+  }
+  return false
+}
+
+// Release the input version for the compaction, once the compaction is
+// successful.
+// REQUIRES: DB mutex held.
+func (c *Compaction) ReleaseInputs() {
+  if c.inputVersion != nil {
+    c.inputVersion.Unref()
+    c.inputVersion = nil
+  }
+}
@@ -24,33 +24,43 @@ func (BytewiseComparator) Compare(a, b []byte) int {
   return bytes.Compare(a, b)
 }
 
+// If start < limit, return a short key in [start, limit). The result may
+// share memory with start.
 func (BytewiseComparator) FindShortestSeparator(start, limit []byte) []byte {
+  // Find length of common prefix
   l := len(start)
   if l > len(limit) {
     l = len(limit)
   }
 
-  diff_index := 0
-  for diff_index < l && start[diff_index] == limit[diff_index] {
-    diff_index++
+  diffIndex := 0
+  for diffIndex < l && start[diffIndex] == limit[diffIndex] {
+    diffIndex++
   }
 
-  if diff_index >= l {
-  } else {
-    if start[diff_index] < byte(0xff) && start[diff_index] + 1 < limit[diff_index] {
-      start[diff_index]++
-    }
+  if diffIndex >= l {
+    // Do not shorten if one string is a prefix of the other
+    return start
   }
-
-  return start[:diff_index+1]
+  diffByte := start[diffIndex]
+  if diffByte < byte(0xff) && diffByte + 1 < limit[diffIndex] {
+    separator := append([]byte(nil), start[:diffIndex + 1]...)
+    separator[diffIndex]++
+    return separator
+  }
+  return start
 }
 
+// Return a short key >= key. The result may share memory with key.
 func (BytewiseComparator) FindShortestSuccessor(key []byte) []byte {
+  // Find first character that can be incremented
   for i := 0; i < len(key); i++ {
     if key[i] != byte(0xff) {
-      key[i]++
-      return key[:i+1]
+      successor := append([]byte(nil), key[:i + 1]...)
+      successor[i]++
+      return successor
     }
   }
+  // key is a run of 0xffs. Leave it alone.
   return key
 }
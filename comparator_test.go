@@ -17,3 +17,34 @@ func TestDefaultComparator(t *testing.T) {
     t.Error("DefaultComparator return unexpected result.")
   }
 }
+
+func TestBytewiseFindShortestSeparator(t *testing.T) {
+  for _, c := range([]struct{
+    start, limit, expected string
+  }{
+    {"abc1xyz", "abc5", "abc2"},
+    {"abc1xyz", "abc2", "abc1xyz"},  // Cannot increment to a key < limit
+    {"abc", "abcd", "abc"},  // start is a prefix of limit
+    {"ab\xff1", "ac", "ab\xff1"},
+  }) {
+    start := []byte(c.start)
+    if s := DefaultComparator.FindShortestSeparator(start, []byte(c.limit)); string(s) != c.expected {
+      t.Error("Unexpected separator of ", c.start, " and ", c.limit, ": ", string(s))
+    }
+    if string(start) != c.start {
+      t.Error("FindShortestSeparator should not modify start: ", string(start))
+    }
+  }
+}
+
+func TestBytewiseFindShortestSuccessor(t *testing.T) {
+  if s := DefaultComparator.FindShortestSuccessor([]byte("abc")); string(s) != "b" {
+    t.Error("Unexpected successor: ", string(s))
+  }
+  if s := DefaultComparator.FindShortestSuccessor([]byte("\xff\xffa")); string(s) != "\xff\xffb" {
+    t.Error("Unexpected successor: ", string(s))
+  }
+  if s := DefaultComparator.FindShortestSuccessor([]byte("\xff\xff")); string(s) != "\xff\xff" {
+    t.Error("Unexpected successor: ", string(s))
+  }
+}
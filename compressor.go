@@ -0,0 +1,251 @@
+package leveldb
+
+import (
+  "bytes"
+  "compress/flate"
+  "compress/zlib"
+  "fmt"
+  "io"
+  "sort"
+  "strings"
+  "sync"
+
+  "github.com/chenlanbo/leveldb/snappy"
+)
+
+// A Compressor compresses the blocks of a table. Every compressor is
+// registered against the CompressionType stored in the trailer of the
+// blocks it produces, so that ReadBlock can find the codec to decompress a
+// block with. Implementations must be safe for concurrent use.
+type Compressor interface {
+  // Return the compressed form of src. dst is used as the output buffer if
+  // it is large enough.
+  Compress(dst, src []byte) ([]byte, error)
+
+  // Return the decompressed form of src. dst is used as the output buffer
+  // if it is large enough.
+  Decompress(dst, src []byte) ([]byte, error)
+
+  // The name of the codec, e.g. "leveldb.Snappy".
+  Name() string
+}
+
+var (
+  compressorsMu sync.RWMutex
+  compressors = map[CompressionType]Compressor{}
+)
+
+func init() {
+  RegisterCompressor(SnappyCompression, snappyCompressor{})
+  RegisterCompressor(ZlibCompression, NewZlibCompressor(flate.DefaultCompression, nil))
+  RegisterCompressor(DeflateCompression, NewFlateCompressor(flate.DefaultCompression, nil))
+}
+
+// Register c as the codec of the blocks whose trailer holds t, replacing
+// any compressor previously registered against t. Tables written with a
+// compressor can only be read back by a process that registered the same
+// codec (and, for a codec with a preset dictionary, the same dictionary)
+// against the same type. NoCompression cannot be registered, nor can the
+// types the C++ LevelDB format reserves for codecs of its own (0x2, zstd),
+// since its tables would then be decoded with the wrong codec.
+func RegisterCompressor(t CompressionType, c Compressor) {
+  if t == NoCompression {
+    panic("Cannot register a compressor for NoCompression.")
+  }
+  if t == upstreamZstdCompression {
+    panic(fmt.Sprint("Compression type ", byte(t), " is reserved by the LevelDB format."))
+  }
+  if c == nil {
+    panic("Cannot register a nil compressor.")
+  }
+  compressorsMu.Lock()
+  defer compressorsMu.Unlock()
+  compressors[t] = c
+}
+
+// Return the compressor registered against t, or nil if there is none.
+func GetCompressor(t CompressionType) Compressor {
+  compressorsMu.RLock()
+  defer compressorsMu.RUnlock()
+  return compressors[t]
+}
+
+type snappyCompressor struct {}
+
+func (snappyCompressor) Compress(dst, src []byte) ([]byte, error) {
+  return snappy.Encode(dst[:cap(dst)], src), nil
+}
+
+func (snappyCompressor) Decompress(dst, src []byte) ([]byte, error) {
+  return snappy.Decode(dst[:cap(dst)], src)
+}
+
+func (snappyCompressor) Name() string {
+  return "leveldb.Snappy"
+}
+
+// A compressor on top of compress/flate. The writers hold several hundred
+// kilobytes of state, so they are pooled rather than created per block.
+type flateCompressor struct {
+  name string
+  dict []byte
+  writers sync.Pool
+  newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+type flateWriter interface {
+  io.WriteCloser
+  Reset(w io.Writer)
+}
+
+// Return a compressor that stores blocks as raw DEFLATE streams (RFC 1951)
+// compressed at the given compress/flate level. If dict is non-empty it is
+// used as a preset dictionary, see TrainFlateDictionary.
+func NewFlateCompressor(level int, dict []byte) Compressor {
+  if _, err := flate.NewWriterDict(io.Discard, level, nil); err != nil {
+    panic(fmt.Sprint("Invalid flate compression level ", level))
+  }
+  c := &flateCompressor{name:"leveldb.Deflate", dict:dict}
+  c.writers.New = func() interface{} {
+    w, _ := flate.NewWriterDict(io.Discard, level, c.dict)
+    return w
+  }
+  c.newReader = func(r io.Reader) (io.ReadCloser, error) {
+    return flate.NewReaderDict(r, c.dict), nil
+  }
+  return c
+}
+
+// Return a compressor that stores blocks as zlib streams (RFC 1950), which
+// add a header and an Adler-32 checksum to the DEFLATE data.
+func NewZlibCompressor(level int, dict []byte) Compressor {
+  if _, err := zlib.NewWriterLevelDict(io.Discard, level, nil); err != nil {
+    panic(fmt.Sprint("Invalid zlib compression level ", level))
+  }
+  c := &flateCompressor{name:"leveldb.Zlib", dict:dict}
+  c.writers.New = func() interface{} {
+    w, _ := zlib.NewWriterLevelDict(io.Discard, level, c.dict)
+    return w
+  }
+  c.newReader = func(r io.Reader) (io.ReadCloser, error) {
+    return zlib.NewReaderDict(r, c.dict)
+  }
+  return c
+}
+
+func (c *flateCompressor) Compress(dst, src []byte) ([]byte, error) {
+  buf := bytes.NewBuffer(dst[:0])
+  w := c.writers.Get().(flateWriter)
+  defer c.writers.Put(w)
+  w.Reset(buf)
+  if _, err := w.Write(src); err != nil {
+    return nil, err
+  }
+  if err := w.Close(); err != nil {
+    return nil, err
+  }
+  return buf.Bytes(), nil
+}
+
+func (c *flateCompressor) Decompress(dst, src []byte) ([]byte, error) {
+  r, err := c.newReader(bytes.NewReader(src))
+  if err != nil {
+    return nil, err
+  }
+  defer r.Close()
+  buf := bytes.NewBuffer(dst[:0])
+  if _, err := buf.ReadFrom(r); err != nil {
+    return nil, err
+  }
+  return buf.Bytes(), nil
+}
+
+func (c *flateCompressor) Name() string {
+  return c.name
+}
+
+// Length of the substrings that TrainFlateDictionary counts. DEFLATE only
+// emits matches of at least 3 bytes; longer pieces make better use of the
+// limited dictionary space.
+const flateDictionarySegment = 8
+
+// Build a preset dictionary of at most size bytes (DEFLATE can only refer
+// back 32KB, so larger sizes are clamped) from samples of the blocks that
+// will be compressed with it. The dictionary is made of the substrings that
+// occur in the most samples; the most common ones are placed at the end,
+// where matches against them are cheapest to encode.
+func TrainFlateDictionary(samples [][]byte, size int) []byte {
+  if size > 32 * 1024 {
+    size = 32 * 1024
+  }
+  if size <= 0 {
+    return nil
+  }
+
+  type segment struct {
+    data string
+    count int
+  }
+  counts := make(map[string]int)
+  for _, sample := range(samples) {
+    seen := make(map[string]bool)
+    for i := 0; i + flateDictionarySegment <= len(sample); i++ {
+      s := string(sample[i:i + flateDictionarySegment])
+      if !seen[s] {
+        seen[s] = true
+        counts[s]++
+      }
+    }
+  }
+  segments := make([]segment, 0, len(counts))
+  for s, count := range(counts) {
+    // A substring found in a single sample is unlikely to recur.
+    if count > 1 || len(samples) == 1 {
+      segments = append(segments, segment{s, count})
+    }
+  }
+  sort.Slice(segments, func(i, j int) bool {
+    if segments[i].count != segments[j].count {
+      return segments[i].count > segments[j].count
+    }
+    return segments[i].data < segments[j].data
+  })
+
+  picked := make([]string, 0, size / flateDictionarySegment)
+  var joined strings.Builder
+  for _, s := range(segments) {
+    if joined.Len() + len(s.data) > size {
+      break
+    }
+    // Skip substrings the dictionary already holds.
+    if strings.Contains(joined.String(), s.data) {
+      continue
+    }
+    picked = append(picked, s.data)
+    joined.WriteString(s.data)
+  }
+
+  dict := make([]byte, 0, joined.Len())
+  for i := len(picked) - 1; i >= 0; i-- {
+    dict = append(dict, picked[i]...)
+  }
+  return dict
+}
+
+// Return the uncompressed contents of a block stored with the given type.
+func uncompressBlock(t CompressionType, data []byte) ([]byte, error) {
+  if t == NoCompression {
+    return data, nil
+  }
+  c := GetCompressor(t)
+  if c == nil {
+    return nil, NotSupportedError(fmt.Sprint("no compressor registered for block type ", byte(t)))
+  }
+  out, err := c.Decompress(nil, data)
+  if err != nil {
+    s := newStatus(StatusCorruption, "corrupted compressed block contents")
+    s.err = err
+    return nil, s
+  }
+  return out, nil
+}
@@ -0,0 +1,188 @@
+package leveldb
+
+import (
+  "bytes"
+  "compress/flate"
+  "errors"
+  "fmt"
+  "math/rand"
+  "strings"
+  "testing"
+)
+
+func TestCompressorRoundTrip(t *testing.T) {
+  rnd := rand.New(rand.NewSource(301))
+  random := make([]byte, 4096)
+  rnd.Read(random)
+  inputs := [][]byte{
+    {},
+    []byte("a"),
+    []byte(strings.Repeat("compressible ", 500)),
+    random,
+  }
+
+  for _, ct := range([]CompressionType{SnappyCompression, ZlibCompression, DeflateCompression}) {
+    c := GetCompressor(ct)
+    if c == nil {
+      t.Fatal("Missing builtin compressor: ", ct)
+    }
+    for _, input := range(inputs) {
+      compressed, err := c.Compress(nil, input)
+      if err != nil {
+        t.Fatal(c.Name(), ": compress failed: ", err)
+      }
+      out, err := c.Decompress(nil, compressed)
+      if err != nil || !bytes.Equal(out, input) {
+        t.Fatal(c.Name(), ": roundtrip mismatch for ", len(input), " bytes: ", err)
+      }
+    }
+  }
+}
+
+func TestCompressorReusesBuffer(t *testing.T) {
+  c := GetCompressor(DeflateCompression)
+  input := []byte(strings.Repeat("0123456789", 100))
+  var buf []byte
+  for i := 0; i < 3; i++ {
+    var err error
+    if buf, err = c.Compress(buf, input); err != nil {
+      t.Fatal("Compress failed: ", err)
+    }
+    out, err := c.Decompress(nil, buf)
+    if err != nil || !bytes.Equal(out, input) {
+      t.Fatal("Roundtrip mismatch: ", err)
+    }
+  }
+}
+
+func TestCompressorCorruption(t *testing.T) {
+  for _, ct := range([]CompressionType{SnappyCompression, ZlibCompression, DeflateCompression}) {
+    if _, err := uncompressBlock(ct, []byte{0xff, 0xff, 0xff, 0xff}); err == nil {
+      t.Error("Corrupted block should fail to decompress: ", ct)
+    }
+  }
+  if _, err := uncompressBlock(CompressionType(0xee), []byte("abc")); err == nil {
+    t.Error("Unregistered block type should be an error.")
+  }
+  // Zstd blocks of C++ tables are not supported, rather than corrupted.
+  if _, err := uncompressBlock(upstreamZstdCompression, []byte("abc")); !errors.Is(err, ErrNotSupported) {
+    t.Error("Zstd block should not be supported: ", err)
+  }
+}
+
+func TestRegisterReservedCompressor(t *testing.T) {
+  defer func() {
+    if recover() == nil {
+      t.Error("Reserved compression type should not be registered.")
+    }
+  }()
+  RegisterCompressor(upstreamZstdCompression, GetCompressor(SnappyCompression))
+}
+
+func TestFlateDictionary(t *testing.T) {
+  block := func(i int) []byte {
+    return []byte(fmt.Sprintf(`{"user":"user%04d","status":"active","region":"eu-west"}`, i))
+  }
+  var samples [][]byte
+  for i := 0; i < 100; i++ {
+    samples = append(samples, block(i))
+  }
+  dict := TrainFlateDictionary(samples, 1024)
+  if len(dict) == 0 || len(dict) > 1024 {
+    t.Fatal("Unexpected dictionary size: ", len(dict))
+  }
+
+  plain := NewFlateCompressor(flate.BestCompression, nil)
+  withDict := NewFlateCompressor(flate.BestCompression, dict)
+  input := block(1000)
+  small, err := withDict.Compress(nil, input)
+  if err != nil {
+    t.Fatal("Compress failed: ", err)
+  }
+  large, _ := plain.Compress(nil, input)
+  if len(small) >= len(large) {
+    t.Error("Dictionary should improve compression: ", len(small), " vs ", len(large))
+  }
+  out, err := withDict.Decompress(nil, small)
+  if err != nil || !bytes.Equal(out, input) {
+    t.Error("Roundtrip mismatch: ", err)
+  }
+  if _, err := plain.Decompress(nil, small); err == nil {
+    t.Error("Decompressing without the dictionary should fail.")
+  }
+
+  zlibDict := NewZlibCompressor(flate.BestCompression, dict)
+  compressed, _ := zlibDict.Compress(nil, input)
+  if out, err := zlibDict.Decompress(nil, compressed); err != nil || !bytes.Equal(out, input) {
+    t.Error("Zlib roundtrip mismatch: ", err)
+  }
+}
+
+// A codec that counts its calls, to check that registered codecs are used
+// when writing and reading tables.
+type countingCompressor struct {
+  Compressor
+  compressCalls int
+  decompressCalls int
+}
+
+func (c *countingCompressor) Compress(dst, src []byte) ([]byte, error) {
+  c.compressCalls++
+  return c.Compressor.Compress(dst, src)
+}
+
+func (c *countingCompressor) Decompress(dst, src []byte) ([]byte, error) {
+  c.decompressCalls++
+  return c.Compressor.Decompress(dst, src)
+}
+
+func TestRegisterCompressor(t *testing.T) {
+  const countingCompression CompressionType = 0x7f
+  c := &countingCompressor{Compressor:GetCompressor(SnappyCompression)}
+  RegisterCompressor(countingCompression, c)
+  defer func() {
+    compressorsMu.Lock()
+    delete(compressors, countingCompression)
+    compressorsMu.Unlock()
+  }()
+  if GetCompressor(countingCompression) != c {
+    t.Fatal("Registered compressor not found.")
+  }
+
+  options := defaultOptions()
+  options.CompressionType = countingCompression
+  buildCompressibleTable(t, options)
+  if c.compressCalls == 0 || c.decompressCalls == 0 {
+    t.Error("Registered compressor was not used: ", c.compressCalls, " ", c.decompressCalls)
+  }
+}
+
+func TestTableFlateCompression(t *testing.T) {
+  options := defaultOptions()
+  options.CompressionType = NoCompression
+  uncompressed := buildCompressibleTable(t, options)
+  for _, ct := range([]CompressionType{ZlibCompression, DeflateCompression}) {
+    options.CompressionType = ct
+    if compressed := buildCompressibleTable(t, options); compressed > uncompressed / 2 {
+      t.Error("Compression should shrink the table: ", ct, " ", compressed, " vs ", uncompressed)
+    }
+  }
+}
+
+func TestCompressionForLevel(t *testing.T) {
+  options := &Options{CompressionType:SnappyCompression}
+  if options.compressionForLevel(3) != SnappyCompression {
+    t.Error("CompressionType should apply to all levels by default.")
+  }
+  options.CompressionPerLevel = []CompressionType{NoCompression, SnappyCompression, ZlibCompression}
+  expected := []CompressionType{NoCompression, SnappyCompression, ZlibCompression, ZlibCompression}
+  for level := 0; level < NumLevels; level++ {
+    e := expected[len(expected) - 1]
+    if level < len(expected) {
+      e = expected[level]
+    }
+    if options.compressionForLevel(level) != e {
+      t.Error("Unexpected compression for level ", level, ": ", options.compressionForLevel(level))
+    }
+  }
+}
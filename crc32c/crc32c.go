@@ -0,0 +1,39 @@
+// Package crc32c computes the CRC-32C (Castagnoli) checksums stored in
+// LevelDB tables and logs.
+package crc32c
+
+import (
+  "hash/crc32"
+)
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+const maskDelta = 0xa282ead8
+
+// Return the crc32c of data.
+func Value(data []byte) uint32 {
+  return crc32.Checksum(data, table)
+}
+
+// Return the crc32c of concat(A, data) where initCrc is the crc32c of some
+// string A. Extend() is often used to maintain the crc32c of a stream of
+// data.
+func Extend(initCrc uint32, data []byte) uint32 {
+  return crc32.Update(initCrc, table, data)
+}
+
+// Return a masked representation of crc.
+//
+// Motivation: it is problematic to compute the CRC of a string that
+// contains embedded CRCs. Therefore we recommend that CRCs stored
+// somewhere (e.g., in files) should be masked before being stored.
+func Mask(crc uint32) uint32 {
+  // Rotate right by 15 bits and add a constant.
+  return ((crc >> 15) | (crc << 17)) + maskDelta
+}
+
+// Return the crc whose masked representation is maskedCrc.
+func Unmask(maskedCrc uint32) uint32 {
+  rot := maskedCrc - maskDelta
+  return (rot >> 17) | (rot << 15)
+}
@@ -0,0 +1,61 @@
+package crc32c
+
+import (
+  "bytes"
+  "testing"
+)
+
+func TestStandardResults(t *testing.T) {
+  // From rfc3720 section B.4.
+  buf := make([]byte, 32)
+  if v := Value(buf); v != 0x8a9136aa {
+    t.Errorf("Unexpected crc of zeros: %#x", v)
+  }
+
+  buf = bytes.Repeat([]byte{0xff}, 32)
+  if v := Value(buf); v != 0x62a8ab43 {
+    t.Errorf("Unexpected crc of 0xff: %#x", v)
+  }
+
+  for i := 0; i < 32; i++ {
+    buf[i] = byte(i)
+  }
+  if v := Value(buf); v != 0x46dd794e {
+    t.Errorf("Unexpected crc of increasing bytes: %#x", v)
+  }
+
+  for i := 0; i < 32; i++ {
+    buf[i] = byte(31 - i)
+  }
+  if v := Value(buf); v != 0x113fdb5c {
+    t.Errorf("Unexpected crc of decreasing bytes: %#x", v)
+  }
+}
+
+func TestValues(t *testing.T) {
+  if Value([]byte("a")) == Value([]byte("foo")) {
+    t.Error("Different inputs should have different crcs.")
+  }
+}
+
+func TestExtend(t *testing.T) {
+  if Value([]byte("hello world")) != Extend(Value([]byte("hello ")), []byte("world")) {
+    t.Error("Extend should continue the crc of a prefix.")
+  }
+}
+
+func TestMask(t *testing.T) {
+  crc := Value([]byte("foo"))
+  if crc == Mask(crc) {
+    t.Error("Masked crc should differ.")
+  }
+  if crc == Mask(Mask(crc)) {
+    t.Error("Double masked crc should differ.")
+  }
+  if crc != Unmask(Mask(crc)) {
+    t.Error("Unmask should undo Mask.")
+  }
+  if crc != Unmask(Unmask(Mask(Mask(crc)))) {
+    t.Error("Unmask should undo Mask twice.")
+  }
+}
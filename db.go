@@ -0,0 +1,1114 @@
+package leveldb
+
+import (
+  "fmt"
+  "sort"
+  "sync"
+  "sync/atomic"
+
+  "github.com/chenlanbo/leveldb/log"
+)
+
+var errDBClosed = InvalidArgumentError("database closed")
+
+const defaultWriteBufferSize = 4 << 20
+const defaultMaxFileSize = 2 << 20
+const defaultMaxOpenFiles = 1000
+const defaultBlockCacheSize = 8 << 20
+
+// Number of open files reserved for uses other than the table cache.
+const numNonTableCacheFiles = 10
+
+// A persistent ordered map from keys to values, safe for concurrent use.
+type DB struct {
+  dbname string
+  env Env
+  dbLock FileLock  // Held while the database is open
+  options Options
+  internalComparator InternalKeyComparator
+  tableOptions Options
+
+  // tableCache provides its own synchronization
+  tableCache *TableCache
+
+  mu sync.Mutex
+  closed bool
+  shuttingDown atomic.Bool
+  backgroundWorkFinished *sync.Cond  // Signalled when background work finishes
+  mem *MemTable
+  imm *MemTable  // Memtable being compacted
+  hasImm atomic.Bool  // So background goroutine can detect non-nil imm
+  logFile WritableFile
+  logFileNumber uint64
+  log *log.LogWriter
+
+  snapshots *snapshotList
+
+  // Set of table files to protect from deletion because they are part of
+  // ongoing compactions.
+  pendingOutputs map[uint64]bool
+
+  // Has a background compaction been scheduled or is running?
+  backgroundCompactionScheduled bool
+
+  manualCompaction *manualCompaction
+
+  versions *VersionSet
+
+  // Have we encountered a background error in paranoid mode?
+  backgroundError error
+}
+
+// Information for a manual compaction
+type manualCompaction struct {
+  level int
+  done bool
+  begin []byte  // nil means beginning of key range
+  end []byte  // nil means end of key range
+  tmpStorage []byte  // Used to keep track of compaction progress
+}
+
+// Fill in defaults for the options the DB depends on.
+func sanitizeOptions(src *Options) Options {
+  var result Options
+  if src != nil {
+    result = *src
+  }
+  if result.Comparator == nil {
+    result.Comparator = DefaultComparator
+  }
+  if result.Env == nil {
+    result.Env = DefaultEnv()
+  }
+  if result.BlockRestartInterval <= 0 {
+    result.BlockRestartInterval = 16
+  }
+  if result.BlockSize <= 0 {
+    result.BlockSize = 4096
+  }
+  if result.WriteBufferSize <= 0 {
+    result.WriteBufferSize = defaultWriteBufferSize
+  }
+  if result.BlockCache == nil {
+    result.BlockCache = NewLRUCache(defaultBlockCacheSize)
+  }
+  if result.MaxFileSize <= 0 {
+    result.MaxFileSize = defaultMaxFileSize
+  }
+  if result.MaxOpenFiles <= 0 {
+    result.MaxOpenFiles = defaultMaxOpenFiles
+  } else if result.MaxOpenFiles < 64 + numNonTableCacheFiles {
+    result.MaxOpenFiles = 64 + numNonTableCacheFiles
+  } else if result.MaxOpenFiles > 50000 {
+    result.MaxOpenFiles = 50000
+  }
+  return result
+}
+
+// Open the database stored in the directory dbname.
+func Open(dbname string, options *Options) (*DB, error) {
+  db := &DB{}
+  db.dbname = dbname
+  db.options = sanitizeOptions(options)
+  db.env = db.options.Env
+  db.internalComparator = NewInternalKeyComparator(db.options.Comparator)
+  db.tableOptions = db.options
+  db.tableOptions.Comparator = &db.internalComparator
+  if db.options.FilterPolicy != nil {
+    db.tableOptions.FilterPolicy = internalFilterPolicy{db.options.FilterPolicy}
+  }
+  db.tableCache = NewTableCache(dbname, &db.tableOptions, db.options.MaxOpenFiles - numNonTableCacheFiles)
+  db.versions = NewVersionSet(dbname, &db.tableOptions, db.tableCache, db.internalComparator)
+  db.backgroundWorkFinished = sync.NewCond(&db.mu)
+  db.pendingOutputs = make(map[uint64]bool)
+  db.snapshots = newSnapshotList()
+
+  db.mu.Lock()
+  defer db.mu.Unlock()
+
+  // Recover handles CreateIfMissing, ErrorIfExists.
+  edit := NewVersionEdit()
+  err := db.recover(edit)
+  if err == nil {
+    // Switch to a new log file, which makes every replayed log obsolete.
+    err = db.newLog()
+  }
+  if err == nil {
+    edit.SetPrevLogNumber(0)  // No older logs needed after recovery.
+    edit.SetLogNumber(db.logFileNumber)
+    err = db.versions.LogAndApply(edit, &db.mu)
+  }
+  if err != nil {
+    if db.logFile != nil {
+      db.logFile.Close()
+    }
+    db.versions.Close()
+    if db.dbLock != nil {
+      db.env.UnlockFile(db.dbLock)
+    }
+    return nil, err
+  }
+
+  db.mem = NewMemTable(db.internalComparator)
+  db.deleteObsoleteFiles()
+  db.maybeScheduleCompaction()
+  return db, nil
+}
+
+// Set the database entry for key to value.
+func (db *DB) Put(options *WriteOptions, key, value []byte) error {
+  batch := NewWriteBatch()
+  batch.Put(key, value)
+  return db.Write(options, batch)
+}
+
+// Remove the database entry (if any) for key.
+func (db *DB) Delete(options *WriteOptions, key []byte) error {
+  batch := NewWriteBatch()
+  batch.Delete(key)
+  return db.Write(options, batch)
+}
+
+// Apply the updates in batch atomically. The batch is written to the log
+// before it is applied to the memtable.
+func (db *DB) Write(options *WriteOptions, batch *WriteBatch) error {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  if db.closed {
+    return errDBClosed
+  }
+  if err := db.makeRoomForWrite(false); err != nil {
+    return err
+  }
+
+  lastSequence := db.versions.LastSequence()
+  batch.init()
+  batch.setSequence(lastSequence + 1)
+  err := db.log.AddRecord(batch.rep)
+  if err == nil && options != nil && options.Sync {
+    err = db.log.Sync()
+  }
+  if err != nil {
+    // The state of the log file is unknown: records appended after a torn
+    // one could not be recovered. Force the DB into a mode where all future
+    // writes fail.
+    db.recordBackgroundError(err)
+    return err
+  }
+  if err := batch.InsertInto(db.mem); err != nil {
+    return err
+  }
+  db.versions.SetLastSequence(lastSequence + SequenceNumber(batch.count()))
+  return nil
+}
+
+// Return the value stored for key, or a NotFoundError.
+func (db *DB) Get(options *ReadOptions, key []byte) ([]byte, error) {
+  if options == nil {
+    options = &ReadOptions{FillCache:true}
+  }
+
+  db.mu.Lock()
+  if db.closed {
+    db.mu.Unlock()
+    return nil, errDBClosed
+  }
+  var snapshot SequenceNumber
+  if options.Snapshot != nil {
+    snapshot = options.Snapshot.SequenceNumber()
+  } else {
+    snapshot = db.versions.LastSequence()
+  }
+  mem := db.mem
+  imm := db.imm
+  current := db.versions.Current()
+  current.Ref()
+  db.mu.Unlock()
+
+  // Unlock while reading from files and memtables. The memtables support
+  // reads concurrent with the single writer that inserts into db.mem.
+  lkey := NewLookupKey(key, snapshot)
+  value, found, err := mem.Get(lkey)
+  if !found && imm != nil {
+    value, found, err = imm.Get(lkey)
+  }
+  if found {
+    if err == nil {
+      value = append([]byte(nil), value...)
+    }
+  } else {
+    value, err = current.Get(options, lkey)
+  }
+
+  db.mu.Lock()
+  current.Unref()
+  db.mu.Unlock()
+  if err != nil {
+    return nil, err
+  }
+  return value, nil
+}
+
+// Return an iterator over the contents of the database. The result is
+// initially invalid (caller must call one of the Seek methods on the
+// iterator before using it). The caller should call Close() on the iterator
+// when it is no longer needed, and must do so before the DB is closed.
+func (db *DB) NewIterator(options *ReadOptions) (*DBIterator, error) {
+  if options == nil {
+    options = &ReadOptions{FillCache:true}
+  }
+  internalIter, latestSnapshot, cleanup, err := db.newInternalIterator(options)
+  if err != nil {
+    return nil, err
+  }
+  sequence := latestSnapshot
+  if options.Snapshot != nil {
+    sequence = options.Snapshot.SequenceNumber()
+  }
+  return newDBIterator(db.internalComparator.UserComparator(), internalIter, sequence, cleanup), nil
+}
+
+// Return an iterator over the internal keys of the memtables and the current
+// version, the last sequence number at the time of the call, and a function
+// closing the iterator and releasing the state it pins, which returns the
+// error of the iterator.
+func (db *DB) newInternalIterator(options *ReadOptions) (Iterator, SequenceNumber, func() error, error) {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  if db.closed {
+    return nil, 0, nil, errDBClosed
+  }
+  latestSnapshot := db.versions.LastSequence()
+
+  // Collect together all needed child iterators
+  list := []Iterator{db.mem.NewIterator()}
+  if db.imm != nil {
+    list = append(list, db.imm.NewIterator())
+  }
+  current := db.versions.Current()
+  list = current.AddIterators(options, list)
+  internalIter := NewMergeIterator(&db.internalComparator, list)
+  current.Ref()
+
+  cleanup := func() error {
+    err := internalIter.Close()
+    db.mu.Lock()
+    current.Unref()
+    db.mu.Unlock()
+    return err
+  }
+  return internalIter, latestSnapshot, cleanup, nil
+}
+
+// Return a handle to the current DB state. Iterators created with this
+// handle will all observe a stable snapshot of the current DB state. The
+// caller must call ReleaseSnapshot(result) when the snapshot is no longer
+// needed.
+func (db *DB) GetSnapshot() *Snapshot {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  return db.snapshots.New(db.versions.LastSequence())
+}
+
+// Release a previously acquired snapshot. The caller must not use snapshot
+// after this call.
+func (db *DB) ReleaseSnapshot(snapshot *Snapshot) {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  db.snapshots.Delete(snapshot)
+}
+
+// Close the database. The DB must not be used afterwards.
+func (db *DB) Close() error {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  if db.closed {
+    return errDBClosed
+  }
+  db.closed = true
+
+  // Wait for background work to finish.
+  db.shuttingDown.Store(true)
+  for db.backgroundCompactionScheduled {
+    db.backgroundWorkFinished.Wait()
+  }
+
+  err := db.logFile.Close()
+  if vsetErr := db.versions.Close(); err == nil {
+    err = vsetErr
+  }
+  db.tableCache.Prune()
+  if unlockErr := db.env.UnlockFile(db.dbLock); err == nil {
+    err = unlockErr
+  }
+  return err
+}
+
+// Create the initial descriptor of an empty database.
+func (db *DB) newDB() error {
+  newDB := NewVersionEdit()
+  newDB.SetComparatorName(db.options.Comparator.Name())
+  newDB.SetLogNumber(0)
+  newDB.SetNextFile(2)
+  newDB.SetLastSequence(0)
+
+  manifest := DescriptorFileName(db.dbname, 1)
+  file, err := db.env.NewWritableFile(manifest)
+  if err != nil {
+    return err
+  }
+  writer := log.NewLogWriter(file, 0)
+  err = writer.AddRecord(newDB.EncodeTo())
+  if err == nil {
+    err = file.Sync()
+  }
+  if closeErr := file.Close(); err == nil {
+    err = closeErr
+  }
+  if err == nil {
+    // Make "CURRENT" file that points to the new manifest file.
+    err = setCurrentFile(db.env, db.dbname, 1)
+  }
+  if err != nil {
+    db.env.DeleteFile(manifest)
+  }
+  return err
+}
+
+// Recover the descriptor from persistent storage and replay every log file
+// that has not been flushed to a table yet. Tables built from the replayed
+// logs are recorded in edit.
+// REQUIRES: db.mu held.
+func (db *DB) recover(edit *VersionEdit) error {
+  // Ignore the error since the directory may already exist.
+  db.env.CreateDir(db.dbname)
+  lock, err := db.env.LockFile(LockFileName(db.dbname))
+  if err != nil {
+    return err
+  }
+  db.dbLock = lock
+
+  if !db.env.FileExists(CurrentFileName(db.dbname)) {
+    if !db.options.CreateIfMissing {
+      return InvalidArgumentError(fmt.Sprint(db.dbname, ": does not exist (CreateIfMissing is false)"))
+    }
+    if err := db.newDB(); err != nil {
+      return err
+    }
+  } else if db.options.ErrorIfExists {
+    return InvalidArgumentError(fmt.Sprint(db.dbname, ": exists (ErrorIfExists is true)"))
+  }
+
+  if err := db.versions.Recover(); err != nil {
+    return err
+  }
+
+  // Recover from all newer log files than the ones named in the descriptor
+  // (new log files may have been added by the previous incarnation without
+  // registering them in the descriptor).
+  //
+  // Note that PrevLogNumber() is no longer used, but we pay attention to it
+  // in case we are recovering a database produced by an older version.
+  minLog := db.versions.LogNumber()
+  prevLog := db.versions.PrevLogNumber()
+  filenames, err := db.env.GetChildren(db.dbname)
+  if err != nil {
+    return err
+  }
+  expected := make(map[uint64]bool)
+  db.versions.AddLiveFiles(expected)
+  logs := make([]uint64, 0)
+  for _, filename := range(filenames) {
+    number, fileType, ok := ParseFileName(filename)
+    if !ok {
+      continue
+    }
+    if fileType == TableFile {
+      delete(expected, number)
+    }
+    if fileType == LogFile && (number >= minLog || number == prevLog) {
+      logs = append(logs, number)
+    }
+  }
+  if len(expected) != 0 {
+    for number := range(expected) {
+      return withFile(CorruptionError(fmt.Sprint(len(expected), " missing files; e.g.")),
+          TableFileName(db.dbname, number))
+    }
+  }
+
+  // Recover in the order in which the logs were generated.
+  sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+  for _, number := range(logs) {
+    if err := db.recoverLogFile(number, edit); err != nil {
+      return err
+    }
+
+    // The previous incarnation may not have written any MANIFEST records
+    // after allocating this log number. So we manually update the file
+    // number allocation counter in VersionSet.
+    db.versions.MarkFileNumberUsed(number)
+  }
+  return nil
+}
+
+// Collects the corruptions found while replaying a log file.
+type logReporter struct {
+  filename string
+  mode WALRecoveryMode
+  status error
+}
+
+func (r *logReporter) Corruption(bytes int, reason error) {
+  if r.mode == SkipAnyCorruptedRecords {
+    return
+  }
+  if r.status == nil {
+    if _, ok := reason.(*Status); ok {
+      r.status = withFile(reason, r.filename)
+    } else {
+      r.status = withFile(CorruptionError(fmt.Sprint("dropping ", bytes, " bytes; ", reason)), r.filename)
+    }
+  }
+}
+
+// Replay the log file into a fresh memtable and flush it to a level-0 table.
+// REQUIRES: db.mu held.
+func (db *DB) recoverLogFile(number uint64, edit *VersionEdit) error {
+  filename := LogFileName(db.dbname, number)
+  file, err := db.env.NewSequentialFile(filename)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+
+  reporter := &logReporter{filename:filename, mode:db.options.WALRecoveryMode}
+  reader := log.NewLogReader(file, reporter, true, 0)
+  reader.SetReportEOFInconsistency(db.options.WALRecoveryMode == AbsoluteConsistency)
+
+  mem := NewMemTable(db.internalComparator)
+  batch := NewWriteBatch()
+  for reporter.status == nil {
+    record, ok := reader.ReadRecord()
+    if !ok {
+      break
+    }
+    if len(record) < writeBatchHeaderSize {
+      reporter.Corruption(len(record), CorruptionError("log record too small"))
+      continue
+    }
+    batch.setContents(record)
+    if err := batch.InsertInto(mem); err != nil {
+      reporter.Corruption(len(record), err)
+      continue
+    }
+    lastSequence := batch.sequence() + SequenceNumber(batch.Count()) - 1
+    if lastSequence > db.versions.LastSequence() {
+      db.versions.SetLastSequence(lastSequence)
+    }
+  }
+  if reporter.status != nil {
+    return reporter.status
+  }
+
+  return db.writeLevel0Table(mem, edit, nil)
+}
+
+// Write the contents of mem to a new table and record it in edit. The table
+// is placed in level-0 unless base is non-nil and allows pushing it to a
+// deeper level without creating overlaps.
+// REQUIRES: db.mu held.
+func (db *DB) writeLevel0Table(mem *MemTable, edit *VersionEdit, base *Version) error {
+  meta := &FileMetaData{Number:db.versions.NewFileNumber()}
+  db.pendingOutputs[meta.Number] = true
+  iter := mem.NewIterator()
+
+  db.mu.Unlock()
+  options := db.tableOptions
+  options.CompressionType = db.options.compressionForLevel(0)
+  err := buildTable(db.dbname, db.env, &options, iter, meta)
+  db.mu.Lock()
+
+  delete(db.pendingOutputs, meta.Number)
+
+  // Note that if FileSize is zero, the file has been deleted and should not
+  // be added to the manifest.
+  level := 0
+  if err == nil && meta.FileSize > 0 {
+    if base != nil {
+      level = base.PickLevelForMemTableOutput(ExtractUserKey(meta.Smallest), ExtractUserKey(meta.Largest))
+    }
+    edit.AddFile(level, meta.Number, meta.FileSize, meta.Smallest, meta.Largest)
+  }
+  return err
+}
+
+// Flush the immutable memtable to a level-0 table and drop the logs it
+// made obsolete.
+// REQUIRES: db.mu held, db.imm != nil.
+func (db *DB) compactMemTable() {
+  // Save the contents of the memtable as a new Table
+  edit := NewVersionEdit()
+  base := db.versions.Current()
+  base.Ref()
+  err := db.writeLevel0Table(db.imm, edit, base)
+  base.Unref()
+
+  if err == nil && db.shuttingDown.Load() {
+    err = IOError("deleting DB during memtable compaction")
+  }
+
+  // Replace immutable memtable with the generated Table
+  if err == nil {
+    edit.SetPrevLogNumber(0)
+    edit.SetLogNumber(db.logFileNumber)  // Earlier logs no longer needed
+    err = db.versions.LogAndApply(edit, &db.mu)
+  }
+
+  if err == nil {
+    // Commit to the new state
+    db.imm = nil
+    db.hasImm.Store(false)
+    db.deleteObsoleteFiles()
+  } else {
+    db.recordBackgroundError(err)
+  }
+}
+
+func (db *DB) recordBackgroundError(err error) {
+  if db.backgroundError == nil {
+    db.backgroundError = err
+    db.backgroundWorkFinished.Broadcast()
+  }
+}
+
+// Start background work if there is any to do and none is running.
+// REQUIRES: db.mu held.
+func (db *DB) maybeScheduleCompaction() {
+  if db.backgroundCompactionScheduled {
+    // Already scheduled
+  } else if db.shuttingDown.Load() {
+    // DB is being deleted; no more background compactions
+  } else if db.backgroundError != nil {
+    // Already got an error; no more changes
+  } else if db.imm == nil && db.manualCompaction == nil && !db.versions.NeedsCompaction() {
+    // No work to be done
+  } else {
+    db.backgroundCompactionScheduled = true
+    db.env.Schedule(db.backgroundCall)
+  }
+}
+
+func (db *DB) backgroundCall() {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  if !db.backgroundCompactionScheduled {
+    panic("Background call without a scheduled compaction.")
+  }
+  if db.shuttingDown.Load() {
+    // No more background work when shutting down.
+  } else if db.backgroundError != nil {
+    // No more background work after a background error.
+  } else {
+    db.backgroundCompaction()
+  }
+
+  db.backgroundCompactionScheduled = false
+
+  // Previous compaction may have produced too many files in a level,
+  // so reschedule another compaction if needed.
+  db.maybeScheduleCompaction()
+  db.backgroundWorkFinished.Broadcast()
+}
+
+// REQUIRES: db.mu held.
+func (db *DB) backgroundCompaction() {
+  if db.imm != nil {
+    db.compactMemTable()
+    return
+  }
+
+  var c *Compaction
+  isManual := db.manualCompaction != nil
+  var manualEnd []byte
+  if isManual {
+    m := db.manualCompaction
+    c = db.versions.CompactRange(m.level, m.begin, m.end)
+    m.done = c == nil
+    if c != nil {
+      manualEnd = c.Input(0, c.NumInputFiles(0) - 1).Largest
+    }
+  } else {
+    c = db.versions.PickCompaction()
+  }
+
+  var err error
+  if c == nil {
+    // Nothing to do
+  } else if !isManual && c.IsTrivialMove() {
+    // Move file to next level
+    f := c.Input(0, 0)
+    c.Edit().DeleteFile(c.Level(), f.Number)
+    c.Edit().AddFile(c.Level() + 1, f.Number, f.FileSize, f.Smallest, f.Largest)
+    err = db.versions.LogAndApply(c.Edit(), &db.mu)
+    if err != nil {
+      db.recordBackgroundError(err)
+    }
+    c.ReleaseInputs()
+  } else {
+    compact := newCompactionState(c)
+    err = db.doCompactionWork(compact)
+    if err != nil {
+      db.recordBackgroundError(err)
+    }
+    db.cleanupCompaction(compact)
+    c.ReleaseInputs()
+    db.deleteObsoleteFiles()
+  }
+
+  if isManual {
+    m := db.manualCompaction
+    if err != nil {
+      m.done = true
+    }
+    if !m.done {
+      // We only compacted part of the requested range. Update m to the
+      // range that is left to be compacted.
+      m.tmpStorage = manualEnd
+      m.begin = m.tmpStorage
+    }
+    db.manualCompaction = nil
+  }
+}
+
+// State of a compaction in progress.
+type compactionState struct {
+  compaction *Compaction
+
+  // Sequence numbers < smallestSnapshot are not significant since we
+  // will never have to service a snapshot below smallestSnapshot.
+  // Therefore if we have seen a sequence number S <= smallestSnapshot,
+  // we can drop all entries for the same key with sequence numbers < S.
+  smallestSnapshot SequenceNumber
+
+  // Files produced by compaction
+  outputs []*FileMetaData
+
+  // State kept for output being generated
+  outfile WritableFile
+  builder *TableBuilder
+
+  totalBytes uint64
+}
+
+func newCompactionState(c *Compaction) *compactionState {
+  compact := &compactionState{}
+  compact.compaction = c
+  return compact
+}
+
+func (compact *compactionState) currentOutput() *FileMetaData {
+  return compact.outputs[len(compact.outputs) - 1]
+}
+
+// Release the resources of a finished or failed compaction.
+// REQUIRES: db.mu held.
+func (db *DB) cleanupCompaction(compact *compactionState) {
+  if compact.builder != nil {
+    // May happen if we get a shutdown call in the middle of compaction
+    compact.builder.Abandon()
+    compact.builder = nil
+    compact.outfile.Close()
+    compact.outfile = nil
+  }
+  for _, out := range(compact.outputs) {
+    delete(db.pendingOutputs, out.Number)
+  }
+}
+
+func (db *DB) openCompactionOutputFile(compact *compactionState) error {
+  if compact.builder != nil {
+    panic("Compaction output file is already open.")
+  }
+  db.mu.Lock()
+  fileNumber := db.versions.NewFileNumber()
+  db.pendingOutputs[fileNumber] = true
+  compact.outputs = append(compact.outputs, &FileMetaData{Number:fileNumber})
+  db.mu.Unlock()
+
+  // Make the output file
+  file, err := db.env.NewWritableFile(TableFileName(db.dbname, fileNumber))
+  if err != nil {
+    return err
+  }
+  compact.outfile = newRateLimitedWritableFile(file, db.options.RateLimiter, IOPriorityBackground)
+  options := db.tableOptions
+  options.CompressionType = db.options.compressionForLevel(compact.compaction.Level() + 1)
+  compact.builder = NewTableBuilder(&options, compact.outfile)
+  return nil
+}
+
+func (db *DB) finishCompactionOutputFile(compact *compactionState) error {
+  if compact.outfile == nil || compact.builder == nil {
+    panic("No compaction output file to finish.")
+  }
+
+  // Check for iterator errors
+  err := compact.builder.Finish()
+  currentBytes := uint64(compact.builder.FileSize())
+  compact.currentOutput().FileSize = currentBytes
+  compact.totalBytes += currentBytes
+  compact.builder = nil
+
+  // Finish and check for file errors
+  if err == nil {
+    err = compact.outfile.Sync()
+  }
+  if closeErr := compact.outfile.Close(); err == nil {
+    err = closeErr
+  }
+  compact.outfile = nil
+  return err
+}
+
+// Record the compaction outputs and drop its inputs in a new version.
+// REQUIRES: db.mu held.
+func (db *DB) installCompactionResults(compact *compactionState) error {
+  c := compact.compaction
+
+  // Add compaction outputs
+  c.AddInputDeletions(c.Edit())
+  level := c.Level()
+  for _, out := range(compact.outputs) {
+    c.Edit().AddFile(level + 1, out.Number, out.FileSize, out.Smallest, out.Largest)
+  }
+  return db.versions.LogAndApply(c.Edit(), &db.mu)
+}
+
+// Merge the inputs of the compaction into new files at the next level,
+// dropping entries that no snapshot can observe.
+// REQUIRES: db.mu held.
+func (db *DB) doCompactionWork(compact *compactionState) error {
+  c := compact.compaction
+  if db.versions.NumLevelFiles(c.Level()) == 0 {
+    panic("Compaction on an empty level.")
+  }
+  if compact.builder != nil || compact.outfile != nil {
+    panic("Compaction output already open.")
+  }
+  if db.snapshots.Empty() {
+    compact.smallestSnapshot = db.versions.LastSequence()
+  } else {
+    compact.smallestSnapshot = db.snapshots.Oldest().SequenceNumber()
+  }
+
+  input := db.versions.MakeInputIterator(c)
+
+  // Release mutex while we're actually doing the compaction work
+  db.mu.Unlock()
+
+  ucmp := db.internalComparator.UserComparator()
+  var err error
+  defer input.Close()
+  input.SeekToFirst()
+  var currentUserKey []byte
+  hasCurrentUserKey := false
+  lastSequenceForKey := MaxSequenceNumber
+  for input.Valid() && !db.shuttingDown.Load() {
+    // Prioritize immutable compaction work
+    if db.hasImm.Load() {
+      db.mu.Lock()
+      if db.imm != nil {
+        db.compactMemTable()
+        // Wake up makeRoomForWrite() if necessary.
+        db.backgroundWorkFinished.Broadcast()
+      }
+      db.mu.Unlock()
+    }
+
+    key := input.Key()
+    if c.ShouldStopBefore(key) && compact.builder != nil {
+      err = db.finishCompactionOutputFile(compact)
+      if err != nil {
+        break
+      }
+    }
+
+    // Handle key/value, add to state, etc.
+    drop := false
+    ikey, ok := ParseInternalKey(key)
+    if !ok {
+      // Do not hide error keys
+      currentUserKey = nil
+      hasCurrentUserKey = false
+      lastSequenceForKey = MaxSequenceNumber
+    } else {
+      if !hasCurrentUserKey || ucmp.Compare(ikey.UserKey, currentUserKey) != 0 {
+        // First occurrence of this user key
+        currentUserKey = append(currentUserKey[:0], ikey.UserKey...)
+        hasCurrentUserKey = true
+        lastSequenceForKey = MaxSequenceNumber
+      }
+
+      if lastSequenceForKey <= compact.smallestSnapshot {
+        // Hidden by a newer entry for same user key
+        drop = true
+      } else if ikey.Type == TypeDeletion && ikey.Sequence <= compact.smallestSnapshot &&
+          c.IsBaseLevelForKey(ikey.UserKey) {
+        // For this user key:
+        // (1) there is no data in higher levels
+        // (2) data in lower levels will have larger sequence numbers
+        // (3) data in layers that are being compacted here and have
+        //     smaller sequence numbers will be dropped in the next
+        //     few iterations of this loop (by rule (A) above).
+        // Therefore this deletion marker is obsolete and can be dropped.
+        drop = true
+      }
+
+      lastSequenceForKey = ikey.Sequence
+    }
+
+    if !drop {
+      // Open output file if necessary
+      if compact.builder == nil {
+        err = db.openCompactionOutputFile(compact)
+        if err != nil {
+          break
+        }
+      }
+      if compact.builder.NumEntries() == 0 {
+        compact.currentOutput().Smallest = append([]byte(nil), key...)
+      }
+      compact.currentOutput().Largest = append([]byte(nil), key...)
+      compact.builder.Add(key, input.Value())
+
+      // Close output file if it is big enough
+      if uint64(compact.builder.FileSize()) >= c.MaxOutputFileSize() {
+        err = db.finishCompactionOutputFile(compact)
+        if err != nil {
+          break
+        }
+      }
+    }
+
+    input.Next()
+  }
+
+  // The input stops early on a read error; installing the output would
+  // then lose the rest of the data.
+  if err == nil {
+    err = input.Error()
+  }
+  if err == nil && db.shuttingDown.Load() {
+    err = IOError("deleting DB during compaction")
+  }
+  if err == nil && compact.builder != nil {
+    err = db.finishCompactionOutputFile(compact)
+  }
+
+  db.mu.Lock()
+
+  if err == nil {
+    err = db.installCompactionResults(compact)
+  }
+  return err
+}
+
+// Compact the underlying storage for the key range [begin, end]. In
+// particular, deleted and overwritten versions are discarded, and the data
+// is rearranged to reduce the cost of operations needed to access the data.
+//
+// A nil begin is treated as a key before all keys in the database, and a
+// nil end as a key after all keys in the database. Therefore
+// db.CompactRange(nil, nil) compacts the entire database.
+func (db *DB) CompactRange(begin, end []byte) error {
+  maxLevelWithFiles := 1
+  db.mu.Lock()
+  if db.closed {
+    db.mu.Unlock()
+    return errDBClosed
+  }
+  base := db.versions.Current()
+  for level := 1; level < NumLevels; level++ {
+    if base.OverlapInLevel(level, begin, end) {
+      maxLevelWithFiles = level
+    }
+  }
+  db.mu.Unlock()
+
+  if err := db.flushMemTable(); err != nil {
+    return err
+  }
+  for level := 0; level < maxLevelWithFiles; level++ {
+    if err := db.compactLevelRange(level, begin, end); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// Compact any files in the named level that overlap [begin, end].
+func (db *DB) compactLevelRange(level int, begin, end []byte) error {
+  if level < 0 || level + 1 >= NumLevels {
+    panic(fmt.Sprint("Invalid compaction level ", level))
+  }
+
+  manual := &manualCompaction{}
+  manual.level = level
+  manual.done = false
+  if begin != nil {
+    manual.begin = AppendInternalKey(nil, begin, MaxSequenceNumber, ValueTypeForSeek)
+  }
+  if end != nil {
+    manual.end = AppendInternalKey(nil, end, 0, TypeDeletion)
+  }
+
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  for !manual.done && !db.shuttingDown.Load() && db.backgroundError == nil {
+    if db.manualCompaction == nil {  // Idle
+      db.manualCompaction = manual
+      db.maybeScheduleCompaction()
+    } else {  // Running either my compaction or another compaction.
+      db.backgroundWorkFinished.Wait()
+    }
+  }
+  if db.manualCompaction == manual {
+    // Cancel my manual compaction since we aborted early for some reason.
+    db.manualCompaction = nil
+  }
+  return db.backgroundError
+}
+
+// Force the current memtable contents to be flushed to a table and wait
+// until the flush completes.
+func (db *DB) flushMemTable() error {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  err := db.makeRoomForWrite(true)
+  // Wait until the compaction completes
+  for err == nil && db.imm != nil && db.backgroundError == nil {
+    db.backgroundWorkFinished.Wait()
+  }
+  if err == nil {
+    err = db.backgroundError
+  }
+  return err
+}
+
+// Make sure the memtable has room for another write, switching to a new
+// memtable and log when it is full. The full memtable is flushed in the
+// background.
+// REQUIRES: db.mu held.
+func (db *DB) makeRoomForWrite(force bool) error {
+  allowDelay := !force
+  for {
+    if db.backgroundError != nil {
+      // Yield previous error
+      return db.backgroundError
+    } else if allowDelay && db.versions.NumLevelFiles(0) >= l0SlowdownWritesTrigger {
+      // We are getting close to hitting a hard limit on the number of
+      // L0 files. Rather than delaying a single write by several
+      // seconds when we hit the hard limit, start delaying each
+      // individual write by 1ms to reduce latency variance. Also,
+      // this delay hands over some CPU to the compaction goroutine in
+      // case it is sharing the same core as the writer.
+      db.mu.Unlock()
+      db.env.SleepForMicroseconds(1000)
+      allowDelay = false  // Do not delay a single write more than once
+      db.mu.Lock()
+    } else if !force && db.mem.ApproximateMemoryUsage() <= db.options.WriteBufferSize {
+      // There is room in current memtable
+      return nil
+    } else if db.imm != nil {
+      // We have filled up the current memtable, but the previous one is
+      // still being compacted, so we wait.
+      db.backgroundWorkFinished.Wait()
+    } else if db.versions.NumLevelFiles(0) >= l0StopWritesTrigger {
+      // There are too many level-0 files.
+      db.backgroundWorkFinished.Wait()
+    } else {
+      // Attempt to switch to a new memtable and trigger compaction of old
+      if err := db.newLog(); err != nil {
+        return err
+      }
+      db.imm = db.mem
+      db.hasImm.Store(true)
+      db.mem = NewMemTable(db.internalComparator)
+      force = false  // Do not force another compaction if have room
+      db.maybeScheduleCompaction()
+    }
+  }
+}
+
+// Delete any unneeded files.
+// REQUIRES: db.mu held.
+func (db *DB) deleteObsoleteFiles() {
+  if db.backgroundError != nil {
+    // After a background error, we don't know whether a new version may or
+    // may not have been committed, so we cannot safely garbage collect.
+    return
+  }
+
+  // Make a set of all of the live files
+  live := make(map[uint64]bool)
+  for number := range(db.pendingOutputs) {
+    live[number] = true
+  }
+  db.versions.AddLiveFiles(live)
+
+  filenames, err := db.env.GetChildren(db.dbname)
+  if err != nil {
+    return
+  }
+  for _, filename := range(filenames) {
+    number, fileType, ok := ParseFileName(filename)
+    if !ok {
+      continue
+    }
+    keep := true
+    switch fileType {
+    case LogFile:
+      keep = number >= db.versions.LogNumber() || number == db.versions.PrevLogNumber()
+    case DescriptorFile:
+      // Keep my manifest file, and any newer incarnations'
+      // (in case there is a race that allows other incarnations)
+      keep = number >= db.versions.ManifestFileNumber()
+    case TableFile, TempFile:
+      keep = live[number]
+    }
+    if !keep {
+      if fileType == TableFile {
+        db.tableCache.Evict(number)
+      }
+      db.env.DeleteFile(db.dbname + "/" + filename)
+    }
+  }
+}
+
+// Switch to a new write-ahead log file.
+// REQUIRES: db.mu held.
+func (db *DB) newLog() error {
+  number := db.versions.NewFileNumber()
+  filename := LogFileName(db.dbname, number)
+  file, err := db.env.NewWritableFile(filename)
+  if err == nil {
+    // Synced writes to the log must survive a crash even before the log is
+    // recorded in the descriptor, so make its directory entry durable.
+    if err = db.env.SyncDir(db.dbname); err != nil {
+      file.Close()
+      db.env.DeleteFile(filename)
+    }
+  }
+  if err != nil {
+    db.versions.ReuseFileNumber(number)
+    return err
+  }
+  if db.logFile != nil {
+    db.logFile.Close()
+  }
+  db.logFile = file
+  db.logFileNumber = number
+  db.log = log.NewLogWriter(file, 0)
+  return nil
+}
@@ -0,0 +1,269 @@
+package leveldb
+
+import (
+)
+
+// Which direction is the iterator currently moving?
+// (1) When moving forward, the internal iterator is positioned at the exact
+//     entry that yields Key(), Value().
+// (2) When moving backwards, the internal iterator is positioned just before
+//     all entries whose user key == Key().
+type dbIterDirection byte
+const (
+  dbIterForward dbIterDirection = 0x0
+  dbIterReverse dbIterDirection = 0x1
+)
+
+// DBIterator yields the user-visible contents of a DB: the internal keys
+// produced by the memtables and tables are stripped of their sequence
+// number and type, only the newest version of each user key at or below
+// the iterator's sequence number is shown, and deleted keys are skipped.
+type DBIterator struct {
+  ucmp Comparator
+  iter Iterator
+  sequence SequenceNumber
+  status error
+  savedKey []byte  // == current key when direction == dbIterReverse
+  savedValue []byte  // == current raw value when direction == dbIterReverse
+  direction dbIterDirection
+  valid bool
+  cleanup func() error
+}
+
+// Return a new iterator that converts internal keys (yielded by
+// internalIter) that were live at the specified sequence number into
+// appropriate user keys. cleanup, if non-nil, is called once by Close(),
+// and its error is returned.
+func newDBIterator(ucmp Comparator, internalIter Iterator, sequence SequenceNumber, cleanup func() error) *DBIterator {
+  iter := &DBIterator{}
+  iter.ucmp = ucmp
+  iter.iter = internalIter
+  iter.sequence = sequence
+  iter.direction = dbIterForward
+  iter.valid = false
+  iter.cleanup = cleanup
+  return iter
+}
+
+func (iter *DBIterator) Valid() bool {
+  return iter.valid
+}
+
+func (iter *DBIterator) Key() []byte {
+  if !iter.valid {
+    panic("")
+  }
+  if iter.direction == dbIterForward {
+    return ExtractUserKey(iter.iter.Key())
+  }
+  return iter.savedKey
+}
+
+func (iter *DBIterator) Value() []byte {
+  if !iter.valid {
+    panic("")
+  }
+  if iter.direction == dbIterForward {
+    return iter.iter.Value()
+  }
+  return iter.savedValue
+}
+
+// Return the error encountered while iterating, if any.
+func (iter *DBIterator) Error() error {
+  if iter.status != nil {
+    return iter.status
+  }
+  return iter.iter.Error()
+}
+
+// Release the resources held by the iterator, and return the error
+// encountered while iterating, if any. The iterator must not be used
+// afterwards.
+func (iter *DBIterator) Close() error {
+  var err error
+  if iter.cleanup != nil {
+    err = iter.cleanup()
+    iter.cleanup = nil
+  }
+  iter.valid = false
+  if iter.status != nil {
+    return iter.status
+  }
+  return err
+}
+
+func (iter *DBIterator) parseKey() (ParsedInternalKey, bool) {
+  ikey, ok := ParseInternalKey(iter.iter.Key())
+  if !ok {
+    iter.status = CorruptionError("corrupted internal key in DBIterator")
+  }
+  return ikey, ok
+}
+
+func (iter *DBIterator) Next() {
+  if !iter.valid {
+    panic("")
+  }
+
+  if iter.direction == dbIterReverse {  // Switch directions?
+    iter.direction = dbIterForward
+    // iter.iter is pointing just before the entries for iter.Key(), so
+    // advance into the range of entries for iter.Key() and then use the
+    // normal skipping code below.
+    if !iter.iter.Valid() {
+      iter.iter.SeekToFirst()
+    } else {
+      iter.iter.Next()
+    }
+    if !iter.iter.Valid() {
+      iter.valid = false
+      iter.savedKey = iter.savedKey[:0]
+      return
+    }
+    // savedKey already contains the key to skip past.
+  } else {
+    // Store in savedKey the current key so we skip it below.
+    iter.savedKey = append(iter.savedKey[:0], ExtractUserKey(iter.iter.Key())...)
+
+    // iter.iter is pointing to current key. We can now safely move to the
+    // next to avoid checking current key.
+    iter.iter.Next()
+    if !iter.iter.Valid() {
+      iter.valid = false
+      iter.savedKey = iter.savedKey[:0]
+      return
+    }
+  }
+
+  iter.findNextUserEntry(true)
+}
+
+// Advance to the first visible entry at or after the current position of
+// the internal iterator. If skipping is true, entries whose user key is at
+// or before savedKey are hidden.
+func (iter *DBIterator) findNextUserEntry(skipping bool) {
+  // Loop until we hit an acceptable entry to yield
+  if !iter.iter.Valid() || iter.direction != dbIterForward {
+    panic("")
+  }
+  for {
+    if ikey, ok := iter.parseKey(); ok && ikey.Sequence <= iter.sequence {
+      switch ikey.Type {
+      case TypeDeletion:
+        // Arrange to skip all upcoming entries for this key since
+        // they are hidden by this deletion.
+        iter.savedKey = append(iter.savedKey[:0], ikey.UserKey...)
+        skipping = true
+      case TypeValue:
+        if skipping && iter.ucmp.Compare(ikey.UserKey, iter.savedKey) <= 0 {
+          // Entry hidden
+        } else {
+          iter.valid = true
+          iter.savedKey = iter.savedKey[:0]
+          return
+        }
+      }
+    }
+    iter.iter.Next()
+    if !iter.iter.Valid() {
+      break
+    }
+  }
+  iter.savedKey = iter.savedKey[:0]
+  iter.valid = false
+}
+
+func (iter *DBIterator) Prev() {
+  if !iter.valid {
+    panic("")
+  }
+
+  if iter.direction == dbIterForward {  // Switch directions?
+    // iter.iter is pointing at the current entry. Scan backwards until
+    // the key changes so we can use the normal reverse scanning code.
+    iter.savedKey = append(iter.savedKey[:0], ExtractUserKey(iter.iter.Key())...)
+    for {
+      iter.iter.Prev()
+      if !iter.iter.Valid() {
+        iter.valid = false
+        iter.savedKey = iter.savedKey[:0]
+        iter.savedValue = iter.savedValue[:0]
+        return
+      }
+      if iter.ucmp.Compare(ExtractUserKey(iter.iter.Key()), iter.savedKey) < 0 {
+        break
+      }
+    }
+    iter.direction = dbIterReverse
+  }
+
+  iter.findPrevUserEntry()
+}
+
+// Move backwards to the newest visible entry of the closest user key before
+// the current position of the internal iterator.
+func (iter *DBIterator) findPrevUserEntry() {
+  if iter.direction != dbIterReverse {
+    panic("")
+  }
+
+  valueType := TypeDeletion
+  for iter.iter.Valid() {
+    if ikey, ok := iter.parseKey(); ok && ikey.Sequence <= iter.sequence {
+      if valueType != TypeDeletion && iter.ucmp.Compare(ikey.UserKey, iter.savedKey) < 0 {
+        // We encountered a non-deleted value in entries for previous keys,
+        break
+      }
+      valueType = ikey.Type
+      if valueType == TypeDeletion {
+        iter.savedKey = iter.savedKey[:0]
+        iter.savedValue = iter.savedValue[:0]
+      } else {
+        iter.savedValue = append(iter.savedValue[:0], iter.iter.Value()...)
+        iter.savedKey = append(iter.savedKey[:0], ExtractUserKey(iter.iter.Key())...)
+      }
+    }
+    iter.iter.Prev()
+  }
+
+  if valueType == TypeDeletion {
+    // End
+    iter.valid = false
+    iter.savedKey = iter.savedKey[:0]
+    iter.savedValue = iter.savedValue[:0]
+    iter.direction = dbIterForward
+  } else {
+    iter.valid = true
+  }
+}
+
+func (iter *DBIterator) Seek(target []byte) {
+  iter.direction = dbIterForward
+  iter.savedValue = iter.savedValue[:0]
+  iter.savedKey = AppendInternalKey(iter.savedKey[:0], target, iter.sequence, ValueTypeForSeek)
+  iter.iter.Seek(iter.savedKey)
+  if iter.iter.Valid() {
+    iter.findNextUserEntry(false)
+  } else {
+    iter.valid = false
+  }
+}
+
+func (iter *DBIterator) SeekToFirst() {
+  iter.direction = dbIterForward
+  iter.savedValue = iter.savedValue[:0]
+  iter.iter.SeekToFirst()
+  if iter.iter.Valid() {
+    iter.findNextUserEntry(false)
+  } else {
+    iter.valid = false
+  }
+}
+
+func (iter *DBIterator) SeekToLast() {
+  iter.direction = dbIterReverse
+  iter.savedValue = iter.savedValue[:0]
+  iter.iter.SeekToLast()
+  iter.findPrevUserEntry()
+}
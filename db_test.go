@@ -0,0 +1,1189 @@
+package leveldb
+
+import (
+  "errors"
+  "fmt"
+  "math/rand"
+  "os"
+  "strings"
+  "sync"
+  "sync/atomic"
+  "testing"
+  "time"
+
+  "github.com/chenlanbo/leveldb/log"
+)
+
+func newTestDBName() string {
+  return fmt.Sprint("/tmp/leveldb_db_test-", time.Now().UnixNano())
+}
+
+func testDBOptions() *Options {
+  return &Options{CreateIfMissing:true}
+}
+
+func TestDBPutGetDelete(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  if err := db.Put(nil, []byte("foo"), []byte("v1")); err != nil {
+    t.Fatal("Put failed: ", err)
+  }
+  value, err := db.Get(nil, []byte("foo"))
+  if err != nil || string(value) != "v1" {
+    t.Error("Get returned unexpected result: ", string(value), " ", err)
+  }
+
+  db.Put(&WriteOptions{Sync:true}, []byte("foo"), []byte("v2"))
+  value, err = db.Get(nil, []byte("foo"))
+  if err != nil || string(value) != "v2" {
+    t.Error("Get should return the latest value: ", string(value), " ", err)
+  }
+
+  if _, err := db.Get(nil, []byte("bar")); !errors.Is(err, ErrNotFound) {
+    t.Error("Key 'bar' should not be found: ", err)
+  }
+
+  db.Delete(nil, []byte("foo"))
+  if _, err := db.Get(nil, []byte("foo")); !errors.Is(err, ErrNotFound) {
+    t.Error("Key 'foo' should be deleted: ", err)
+  }
+}
+
+func TestDBWrite(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+
+  db.Put(nil, []byte("a"), []byte("1"))
+  batch := NewWriteBatch()
+  batch.Put([]byte("b"), []byte("2"))
+  batch.Delete([]byte("a"))
+  batch.Put([]byte("c"), []byte("3"))
+  if err := db.Write(nil, batch); err != nil {
+    t.Fatal("Write failed: ", err)
+  }
+
+  if _, err := db.Get(nil, []byte("a")); err == nil {
+    t.Error("Key 'a' should be deleted.")
+  }
+  for _, key := range([]string{"b", "c"}) {
+    if _, err := db.Get(nil, []byte(key)); err != nil {
+      t.Error("Key should be found: ", key)
+    }
+  }
+
+  if err := db.Close(); err != nil {
+    t.Error("Close failed: ", err)
+  }
+  if err := db.Put(nil, []byte("a"), []byte("1")); !errors.Is(err, ErrInvalidArgument) {
+    t.Error("Put should fail on a closed database: ", err)
+  }
+}
+
+func TestDBRecovery(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  db.Put(nil, []byte("foo"), []byte("v1"))
+  db.Put(nil, []byte("baz"), []byte("v5"))
+  db.Close()
+
+  for i := 0; i < 2; i++ {
+    db, err = Open(dbname, testDBOptions())
+    if err != nil {
+      t.Fatal("Cannot reopen database: ", err)
+    }
+    if value, err := db.Get(nil, []byte("foo")); err != nil || string(value) != "v1" {
+      t.Error("Unexpected value after recovery: ", string(value), " ", err)
+    }
+    if value, err := db.Get(nil, []byte("baz")); err != nil || string(value) != "v5" {
+      t.Error("Unexpected value after recovery: ", string(value), " ", err)
+    }
+    db.Close()
+  }
+
+  // Writes after recovery must be ordered after the recovered ones.
+  db, _ = Open(dbname, testDBOptions())
+  db.Put(nil, []byte("foo"), []byte("v2"))
+  db.Delete(nil, []byte("baz"))
+  db.Close()
+
+  db, err = Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot reopen database: ", err)
+  }
+  defer db.Close()
+  if value, err := db.Get(nil, []byte("foo")); err != nil || string(value) != "v2" {
+    t.Error("Unexpected value after recovery: ", string(value), " ", err)
+  }
+  if _, err := db.Get(nil, []byte("baz")); err == nil {
+    t.Error("Key 'baz' should stay deleted after recovery.")
+  }
+}
+
+// Write two records to a fresh database and return the name of its log.
+func writeTestLog(t *testing.T, dbname string) string {
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  db.Put(nil, []byte("foo"), []byte("v1"))
+  db.Put(nil, []byte("bar"), []byte("v2"))
+  filename := LogFileName(dbname, db.logFileNumber)
+  db.Close()
+  return filename
+}
+
+func TestDBRecoveryCorruptedTail(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  filename := writeTestLog(t, dbname)
+  info, _ := os.Stat(filename)
+  os.Truncate(filename, info.Size() - 2)
+
+  options := testDBOptions()
+  options.WALRecoveryMode = AbsoluteConsistency
+  if _, err := Open(dbname, options); err == nil {
+    t.Error("Open should fail on a truncated log in AbsoluteConsistency mode.")
+  }
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Open should tolerate a truncated log tail: ", err)
+  }
+  defer db.Close()
+  if value, err := db.Get(nil, []byte("foo")); err != nil || string(value) != "v1" {
+    t.Error("Unexpected value after recovery: ", string(value), " ", err)
+  }
+  if _, err := db.Get(nil, []byte("bar")); err == nil {
+    t.Error("Key 'bar' was in the truncated record.")
+  }
+}
+
+func TestDBRecoveryCorruptedRecord(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  filename := writeTestLog(t, dbname)
+  // Flip a byte in the payload of the first record.
+  f, _ := os.OpenFile(filename, os.O_RDWR, 0644)
+  f.WriteAt([]byte{0xff}, 10)
+  f.Close()
+
+  if _, err := Open(dbname, testDBOptions()); err == nil {
+    t.Error("Open should fail on a corrupted record in TolerateCorruptedTailRecords mode.")
+  }
+
+  options := testDBOptions()
+  options.WALRecoveryMode = SkipAnyCorruptedRecords
+  db, err := Open(dbname, options)
+  if err != nil {
+    t.Fatal("Open should skip corrupted records: ", err)
+  }
+  defer db.Close()
+  if _, err := db.Get(nil, []byte("foo")); err == nil {
+    t.Error("Key 'foo' was in the corrupted record.")
+  }
+  db.Put(nil, []byte("foo"), []byte("v3"))
+  if value, err := db.Get(nil, []byte("foo")); err != nil || string(value) != "v3" {
+    t.Error("Unexpected value after recovery: ", string(value), " ", err)
+  }
+}
+
+// testdata/db holds a database as left by C++ LevelDB after a few writes
+// that are only in its log: Put(foo, v1), Put(bar, v2), a batch of
+// Delete(foo) and Put(big, 50000 * "y"), then Put(baz, v3).
+func TestDBRecoverCppLevelDBFiles(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+  if err := os.MkdirAll(dbname, 0755); err != nil {
+    t.Fatal("Cannot create directory: ", err)
+  }
+  for _, name := range([]string{"CURRENT", "MANIFEST-000002", "000003.log"}) {
+    contents, err := os.ReadFile("testdata/db/" + name)
+    if err != nil {
+      t.Fatal("Missing fixture: ", err)
+    }
+    if err := os.WriteFile(dbname + "/" + name, contents, 0644); err != nil {
+      t.Fatal("Cannot copy fixture: ", err)
+    }
+  }
+
+  check := func(db *DB) {
+    if _, err := db.Get(nil, []byte("foo")); err == nil {
+      t.Error("Key 'foo' should be deleted.")
+    }
+    if value, err := db.Get(nil, []byte("bar")); err != nil || string(value) != "v2" {
+      t.Error("Unexpected value: ", string(value), " ", err)
+    }
+    if value, err := db.Get(nil, []byte("big")); err != nil || string(value) != strings.Repeat("y", 50000) {
+      t.Error("Unexpected value for 'big': ", len(value), " bytes ", err)
+    }
+    if value, err := db.Get(nil, []byte("baz")); err != nil || string(value) != "v3" {
+      t.Error("Unexpected value: ", string(value), " ", err)
+    }
+  }
+
+  db, err := Open(dbname, &Options{})
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  check(db)
+  if seq := db.versions.LastSequence(); seq != 5 {
+    t.Error("Unexpected last sequence: ", seq)
+  }
+  db.Put(nil, []byte("bar"), []byte("v4"))
+  db.Close()
+
+  db, err = Open(dbname, &Options{})
+  if err != nil {
+    t.Fatal("Cannot reopen database: ", err)
+  }
+  defer db.Close()
+  if value, err := db.Get(nil, []byte("bar")); err != nil || string(value) != "v4" {
+    t.Error("Writes after recovery should win: ", string(value), " ", err)
+  }
+}
+
+// Create a database and append edit to its MANIFEST.
+func writeTestManifestEdit(t *testing.T, dbname string, edit *VersionEdit) {
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  db.Close()
+
+  env := DefaultEnv()
+  current, err := readFileToString(env, CurrentFileName(dbname))
+  if err != nil {
+    t.Fatal("Cannot read CURRENT: ", err)
+  }
+  manifest := dbname + "/" + strings.TrimSuffix(string(current), "\n")
+  size, err := env.GetFileSize(manifest)
+  if err != nil {
+    t.Fatal("Cannot stat MANIFEST: ", err)
+  }
+  file, err := env.NewAppendableFile(manifest)
+  if err != nil {
+    t.Fatal("Cannot open MANIFEST: ", err)
+  }
+  if err := log.NewLogWriter(file, size).AddRecord(edit.EncodeTo()); err != nil {
+    t.Fatal("Cannot append to MANIFEST: ", err)
+  }
+  file.Close()
+}
+
+func TestDBRecoveryOverlappingFiles(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  edit := NewVersionEdit()
+  edit.AddFile(1, 100, 100, AppendInternalKey(nil, []byte("a"), 1, TypeValue),
+      AppendInternalKey(nil, []byte("c"), 1, TypeValue))
+  edit.AddFile(1, 101, 100, AppendInternalKey(nil, []byte("b"), 1, TypeValue),
+      AppendInternalKey(nil, []byte("d"), 1, TypeValue))
+  writeTestManifestEdit(t, dbname, edit)
+
+  if _, err := Open(dbname, testDBOptions()); !errors.Is(err, ErrCorruption) {
+    t.Error("Overlapping level-1 files should be reported as corruption: ", err)
+  }
+}
+
+func TestDBRecoveryShortKeys(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  edit := NewVersionEdit()
+  edit.AddFile(1, 100, 100, []byte("a"), []byte("b"))
+  writeTestManifestEdit(t, dbname, edit)
+
+  if _, err := Open(dbname, testDBOptions()); !errors.Is(err, ErrCorruption) {
+    t.Error("Short file keys should be reported as corruption: ", err)
+  }
+}
+
+func TestDBCreateIfMissingAndErrorIfExists(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  if _, err := Open(dbname, nil); !errors.Is(err, ErrInvalidArgument) {
+    t.Error("Open should fail on a missing database without CreateIfMissing: ", err)
+  }
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot create database: ", err)
+  }
+  db.Close()
+
+  options := testDBOptions()
+  options.ErrorIfExists = true
+  if _, err := Open(dbname, options); !errors.Is(err, ErrInvalidArgument) {
+    t.Error("Open should fail on an existing database with ErrorIfExists: ", err)
+  }
+
+  db, err = Open(dbname, nil)
+  if err != nil {
+    t.Fatal("Cannot reopen database: ", err)
+  }
+  db.Close()
+}
+
+func TestDBLocked(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  if _, err := Open(dbname, testDBOptions()); !errors.Is(err, ErrIOError) {
+    t.Error("Database in use should not be opened again: ", err)
+  }
+  db.Close()
+
+  db, err = Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Closed database should be opened again: ", err)
+  }
+  db.Close()
+}
+
+func TestDBObsoleteFiles(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  for i := 0; i < 3; i++ {
+    db, err := Open(dbname, testDBOptions())
+    if err != nil {
+      t.Fatal("Cannot open database: ", err)
+    }
+    db.Put(nil, []byte(fmt.Sprint("key", i)), []byte(fmt.Sprint("value", i)))
+    db.Close()
+  }
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  counts := make(map[FileType]int)
+  filenames, _ := DefaultEnv().GetChildren(dbname)
+  for _, filename := range(filenames) {
+    if _, fileType, ok := ParseFileName(filename); ok {
+      counts[fileType]++
+    }
+  }
+  if counts[LogFile] != 1 || counts[DescriptorFile] != 1 || counts[TableFile] != 3 {
+    t.Error("Unexpected files in database: ", filenames)
+  }
+
+  for i := 0; i < 3; i++ {
+    value, err := db.Get(nil, []byte(fmt.Sprint("key", i)))
+    if err != nil || string(value) != fmt.Sprint("value", i) {
+      t.Error("Unexpected value: ", string(value), " ", err)
+    }
+  }
+}
+
+func TestDBFlush(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  db.Put(nil, []byte("foo"), []byte("v1"))
+  if err := db.flushMemTable(); err != nil {
+    t.Fatal("Cannot flush memtable: ", err)
+  }
+  // Nothing overlaps the new table, so it is pushed past level-0.
+  if db.versions.NumLevelFiles(maxMemCompactLevel) != 1 {
+    t.Error("Memtable should be flushed to a table: ", db.versions.Current())
+  }
+  db.Put(nil, []byte("bar"), []byte("v2"))
+  for _, key := range([]string{"foo", "bar"}) {
+    if _, err := db.Get(nil, []byte(key)); err != nil {
+      t.Error("Key should be found: ", key)
+    }
+  }
+}
+
+func TestDBWriteBufferRotation(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  options := testDBOptions()
+  options.WriteBufferSize = 100 << 10
+  db, err := Open(dbname, options)
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+
+  value := make([]byte, 1000)
+  n := 1000
+  for i := 0; i < n; i++ {
+    if err := db.Put(nil, []byte(fmt.Sprintf("key%06d", i)), value); err != nil {
+      t.Fatal("Put failed: ", err)
+    }
+  }
+  db.flushMemTable()
+  // Every rotation allocates a log file number and a table file number.
+  if db.logFileNumber < 10 {
+    t.Error("Expected the memtable to rotate several times: ", db.logFileNumber)
+  }
+  for i := 0; i < n; i++ {
+    if v, err := db.Get(nil, []byte(fmt.Sprintf("key%06d", i))); err != nil || len(v) != len(value) {
+      t.Fatal("Unexpected value for key ", i, ": ", err)
+    }
+  }
+  db.Close()
+
+  // Everything must survive a reopen.
+  db, err = Open(dbname, options)
+  if err != nil {
+    t.Fatal("Cannot reopen database: ", err)
+  }
+  defer db.Close()
+  for i := 0; i < n; i++ {
+    if _, err := db.Get(nil, []byte(fmt.Sprintf("key%06d", i))); err != nil {
+      t.Fatal("Key lost after reopen: ", i)
+    }
+  }
+}
+
+// Readers call Get and iterate while a writer fills the active memtable and
+// forces it to rotate. Run with -race to check that memtable reads do not
+// need db.mu.
+func TestDBConcurrentReadsDuringWrites(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  options := testDBOptions()
+  options.WriteBufferSize = 64 << 10
+  db, err := Open(dbname, options)
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  const n = 5000
+  value := make([]byte, 100)
+  // Keys below written are known to be in the database.
+  var written atomic.Int64
+  var done atomic.Bool
+  var wg sync.WaitGroup
+  errs := make(chan string, 4)
+  for r := 0; r < 4; r++ {
+    wg.Add(1)
+    go func(seed int64) {
+      defer wg.Done()
+      rnd := rand.New(rand.NewSource(seed))
+      for !done.Load() {
+        w := int(written.Load())
+        if w == 0 {
+          continue
+        }
+        i := rnd.Intn(w)
+        if v, err := db.Get(nil, []byte(fmt.Sprintf("key%06d", i))); err != nil || len(v) != len(value) {
+          errs <- fmt.Sprint("Unexpected value for key ", i, ": ", err)
+          return
+        }
+        iter, err := db.NewIterator(nil)
+        if err != nil {
+          errs <- fmt.Sprint("Cannot create iterator: ", err)
+          return
+        }
+        count := 0
+        for iter.SeekToFirst(); iter.Valid() && count < 100; iter.Next() {
+          count++
+        }
+        iter.Close()
+        if count < 1 {
+          errs <- "Iterator should see the written keys."
+          return
+        }
+      }
+    }(int64(r))
+  }
+
+  for i := 0; i < n; i++ {
+    if err := db.Put(nil, []byte(fmt.Sprintf("key%06d", i)), value); err != nil {
+      t.Fatal("Put failed: ", err)
+    }
+    written.Store(int64(i + 1))
+  }
+  done.Store(true)
+  wg.Wait()
+  close(errs)
+  for err := range(errs) {
+    t.Error(err)
+  }
+}
+
+// Wait until no background compaction is scheduled or running.
+func (db *DB) waitForBackgroundWork() {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  for db.backgroundCompactionScheduled {
+    db.backgroundWorkFinished.Wait()
+  }
+}
+
+// Return the number of entries for userKey in all the tables of the current
+// version, including overwritten values and deletion markers.
+func (db *DB) numTableEntries(userKey string) int {
+  db.mu.Lock()
+  current := db.versions.Current()
+  current.Ref()
+  db.mu.Unlock()
+  defer func() {
+    db.mu.Lock()
+    current.Unref()
+    db.mu.Unlock()
+  }()
+
+  count := 0
+  for level := 0; level < NumLevels; level++ {
+    for _, f := range(current.files[level]) {
+      iter := db.tableCache.NewIterator(&ReadOptions{}, f.Number, f.FileSize)
+      for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+        if string(ExtractUserKey(iter.Key())) == userKey {
+          count++
+        }
+      }
+      iter.Close()
+    }
+  }
+  return count
+}
+
+func TestDBCompactionTrigger(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  // Every flush covers the same key range, so the tables pile up in level-0
+  // until a compaction merges them into level-1.
+  for round := 0; round < 2 * l0CompactionTrigger; round++ {
+    for i := 0; i < 100; i++ {
+      key := fmt.Sprintf("key%03d", i)
+      if err := db.Put(nil, []byte(key), []byte(fmt.Sprint("value", round, "-", i))); err != nil {
+        t.Fatal("Put failed: ", err)
+      }
+    }
+    if err := db.flushMemTable(); err != nil {
+      t.Fatal("Cannot flush memtable: ", err)
+    }
+  }
+  db.waitForBackgroundWork()
+
+  if n := db.versions.NumLevelFiles(0); n >= l0CompactionTrigger {
+    t.Error("Level-0 should have been compacted: ", db.versions.Current())
+  }
+  if db.versions.NumLevelFiles(1) == 0 {
+    t.Error("Level-0 should be compacted into level-1: ", db.versions.Current())
+  }
+  for i := 0; i < 100; i++ {
+    value, err := db.Get(nil, []byte(fmt.Sprintf("key%03d", i)))
+    if err != nil || string(value) != fmt.Sprint("value", 2 * l0CompactionTrigger - 1, "-", i) {
+      t.Fatal("Unexpected value for key ", i, ": ", string(value), " ", err)
+    }
+  }
+}
+
+func TestDBCompactRangeDropsObsoleteEntries(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  for i := 0; i < 5; i++ {
+    db.Put(nil, []byte("foo"), []byte(fmt.Sprint("v", i)))
+    db.Put(nil, []byte("bar"), []byte(fmt.Sprint("v", i)))
+    db.Put(nil, []byte("baz"), []byte(fmt.Sprint("v", i)))
+    if err := db.flushMemTable(); err != nil {
+      t.Fatal("Cannot flush memtable: ", err)
+    }
+  }
+  db.Delete(nil, []byte("bar"))
+  if n := db.numTableEntries("foo"); n != 5 {
+    t.Fatal("Expected one entry per flush: ", n)
+  }
+
+  if err := db.CompactRange(nil, nil); err != nil {
+    t.Fatal("CompactRange failed: ", err)
+  }
+  if n := db.numTableEntries("foo"); n != 1 {
+    t.Error("Overwritten values should be dropped: ", n)
+  }
+  if n := db.numTableEntries("bar"); n != 0 {
+    t.Error("Deleted key should be dropped at the bottommost level: ", n)
+  }
+  if value, err := db.Get(nil, []byte("foo")); err != nil || string(value) != "v4" {
+    t.Error("Unexpected value: ", string(value), " ", err)
+  }
+  if _, err := db.Get(nil, []byte("bar")); err == nil {
+    t.Error("Deleted key should not be found.")
+  }
+  if value, err := db.Get(nil, []byte("baz")); err != nil || string(value) != "v4" {
+    t.Error("Unexpected value: ", string(value), " ", err)
+  }
+}
+
+// Make the first data block of every table in dbname unreadable by giving
+// it an unknown compression type.
+func corruptTableFirstBlocks(t *testing.T, dbname string) {
+  env := DefaultEnv()
+  children, err := env.GetChildren(dbname)
+  if err != nil {
+    t.Fatal("Cannot list database: ", err)
+  }
+  for _, child := range(children) {
+    if _, fileType, ok := ParseFileName(child); !ok || fileType != TableFile {
+      continue
+    }
+    filename := dbname + "/" + child
+    contents, err := readFileToString(env, filename)
+    if err != nil {
+      t.Fatal("Cannot read table: ", err)
+    }
+    file, err := env.NewRandomAccessFile(filename)
+    if err != nil {
+      t.Fatal("Cannot open table: ", err)
+    }
+    icmp := NewInternalKeyComparator(DefaultComparator)
+    table, err := NewTable(&Options{Comparator:&icmp}, file, uint64(len(contents)))
+    if err != nil {
+      t.Fatal("Cannot open table: ", err)
+    }
+    indexIter := table.indexBlock.NewIterator(&icmp)
+    indexIter.SeekToFirst()
+    var handle BlockHandle
+    if err := handle.DecodeFrom(indexIter.Value()); err != nil {
+      t.Fatal("Cannot decode block handle: ", err)
+    }
+    file.Close()
+    contents[handle.offset + handle.size] = 0xff
+    if err := writeStringToFile(env, contents, filename, false); err != nil {
+      t.Fatal("Cannot write table: ", err)
+    }
+  }
+}
+
+func TestDBCompactionInputError(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  for i := 0; i < 100; i++ {
+    db.Put(nil, []byte(fmt.Sprint("key", i)), []byte(fmt.Sprint("value", i)))
+  }
+  db.flushMemTable()
+  db.Put(nil, []byte("key50"), []byte("value50"))
+  db.flushMemTable()
+  db.Close()
+
+  corruptTableFirstBlocks(t, dbname)
+  db, err = Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+  before := db.versions.Current().String()
+  if err := db.CompactRange(nil, nil); err == nil {
+    t.Error("Compaction of unreadable input should fail.")
+  }
+  db.mu.Lock()
+  after := db.versions.Current().String()
+  db.mu.Unlock()
+  if after != before {
+    t.Error("Failed compaction should keep its inputs: ", after)
+  }
+}
+
+// An iterator that fails after yielding limit entries.
+type failingIterator struct {
+  Iterator
+  limit int
+}
+
+func (iter *failingIterator) Valid() bool {
+  return iter.limit > 0 && iter.Iterator.Valid()
+}
+
+func (iter *failingIterator) Next() {
+  iter.limit--
+  iter.Iterator.Next()
+}
+
+func (iter *failingIterator) Error() error {
+  if iter.limit <= 0 {
+    return IOError("injected read error")
+  }
+  return iter.Iterator.Error()
+}
+
+func TestBuildTableInputError(t *testing.T) {
+  env := NewMemEnv()
+  mem := NewMemTable(NewInternalKeyComparator(DefaultComparator))
+  for i := 0; i < 10; i++ {
+    mem.Add(SequenceNumber(i + 1), TypeValue, []byte(fmt.Sprint("key", i)), []byte("value"))
+  }
+  options := sanitizeOptions(nil)
+  icmp := NewInternalKeyComparator(DefaultComparator)
+  options.Comparator = &icmp
+
+  meta := &FileMetaData{Number:7}
+  iter := &failingIterator{Iterator:mem.NewIterator(), limit:5}
+  if err := buildTable("/dir", env, &options, iter, meta); !errors.Is(err, ErrIOError) {
+    t.Error("Read error of the input should fail the build: ", err)
+  }
+  if env.FileExists(TableFileName("/dir", 7)) {
+    t.Error("Truncated table should be deleted.")
+  }
+}
+
+func TestDBCompactionOutputFileSize(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  options := testDBOptions()
+  options.WriteBufferSize = 100 << 10
+  options.MaxFileSize = 100 << 10
+  db, err := Open(dbname, options)
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+
+  value := make([]byte, 1000)
+  n := 1000
+  for i := 0; i < n; i++ {
+    if err := db.Put(nil, []byte(fmt.Sprintf("key%06d", i)), value); err != nil {
+      t.Fatal("Put failed: ", err)
+    }
+  }
+  if err := db.CompactRange(nil, nil); err != nil {
+    t.Fatal("CompactRange failed: ", err)
+  }
+
+  db.mu.Lock()
+  current := db.versions.Current()
+  files := 0
+  for level := 0; level < NumLevels; level++ {
+    for _, f := range(current.files[level]) {
+      files++
+      if f.FileSize > uint64(2 * options.MaxFileSize) {
+        t.Error("Compaction output is too large: ", f.FileSize)
+      }
+    }
+  }
+  db.mu.Unlock()
+  if files < 5 {
+    t.Error("Compaction output should be split into several files: ", current)
+  }
+  db.Close()
+
+  db, err = Open(dbname, options)
+  if err != nil {
+    t.Fatal("Cannot reopen database: ", err)
+  }
+  defer db.Close()
+  for i := 0; i < n; i++ {
+    if v, err := db.Get(nil, []byte(fmt.Sprintf("key%06d", i))); err != nil || len(v) != len(value) {
+      t.Fatal("Unexpected value for key ", i, ": ", err)
+    }
+  }
+}
+
+func TestDBGetSnapshot(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  db.Put(nil, []byte("foo"), []byte("v1"))
+  s1 := db.GetSnapshot()
+  db.Put(nil, []byte("foo"), []byte("v2"))
+  db.Put(nil, []byte("bar"), []byte("v1"))
+  s2 := db.GetSnapshot()
+  db.Delete(nil, []byte("foo"))
+
+  check := func(snapshot *Snapshot, key, expected string) {
+    value, err := db.Get(&ReadOptions{Snapshot:snapshot}, []byte(key))
+    if expected == "NOT_FOUND" {
+      if err == nil {
+        t.Error("Key ", key, " should not be visible: ", string(value))
+      }
+    } else if err != nil || string(value) != expected {
+      t.Error("Unexpected value for ", key, ": ", string(value), " ", err)
+    }
+  }
+  verify := func() {
+    check(s1, "foo", "v1")
+    check(s1, "bar", "NOT_FOUND")
+    check(s2, "foo", "v2")
+    check(s2, "bar", "v1")
+    check(nil, "foo", "NOT_FOUND")
+    check(nil, "bar", "v1")
+  }
+
+  verify()
+  if err := db.flushMemTable(); err != nil {
+    t.Fatal("Cannot flush memtable: ", err)
+  }
+  verify()
+
+  // CompactRange does not rewrite the bottommost level on its own, so
+  // every round writes a new overlapping table to merge with it.
+  compact := func() {
+    db.Put(nil, []byte("baz"), []byte("v1"))
+    if err := db.CompactRange(nil, nil); err != nil {
+      t.Fatal("CompactRange failed: ", err)
+    }
+  }
+
+  // Compaction must keep the versions the live snapshots need.
+  compact()
+  verify()
+  if n := db.numTableEntries("foo"); n != 3 {
+    t.Error("Compaction dropped versions needed by snapshots: ", n)
+  }
+
+  db.ReleaseSnapshot(s1)
+  compact()
+  check(s2, "foo", "v2")
+  if n := db.numTableEntries("foo"); n != 2 {
+    t.Error("Unexpected versions after releasing a snapshot: ", n)
+  }
+
+  db.ReleaseSnapshot(s2)
+  compact()
+  check(nil, "foo", "NOT_FOUND")
+  if n := db.numTableEntries("foo"); n != 0 {
+    t.Error("Versions should be dropped once no snapshot needs them: ", n)
+  }
+}
+
+func iterStatus(iter *DBIterator) string {
+  if iter.Valid() {
+    return string(iter.Key()) + "->" + string(iter.Value())
+  }
+  return "(invalid)"
+}
+
+func checkIter(t *testing.T, iter *DBIterator, expected string) {
+  if result := iterStatus(iter); result != expected {
+    t.Errorf("Unexpected iterator position: got %q, want %q", result, expected)
+  }
+}
+
+func TestDBIterEmpty(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  iter, err := db.NewIterator(nil)
+  if err != nil {
+    t.Fatal("Cannot create iterator: ", err)
+  }
+  defer iter.Close()
+
+  iter.SeekToFirst()
+  checkIter(t, iter, "(invalid)")
+  iter.SeekToLast()
+  checkIter(t, iter, "(invalid)")
+  iter.Seek([]byte("foo"))
+  checkIter(t, iter, "(invalid)")
+}
+
+func TestDBIterMulti(t *testing.T) {
+  // Run once with everything in the memtable and once with everything in
+  // tables.
+  for _, flush := range([]bool{false, true}) {
+    dbname := newTestDBName()
+    defer os.RemoveAll(dbname)
+
+    db, err := Open(dbname, testDBOptions())
+    if err != nil {
+      t.Fatal("Cannot open database: ", err)
+    }
+    defer db.Close()
+
+    db.Put(nil, []byte("a"), []byte("va"))
+    db.Put(nil, []byte("b"), []byte("vb"))
+    db.Put(nil, []byte("c"), []byte("vc"))
+    if flush {
+      if err := db.flushMemTable(); err != nil {
+        t.Fatal("Cannot flush memtable: ", err)
+      }
+    }
+
+    iter, _ := db.NewIterator(nil)
+
+    iter.SeekToFirst()
+    checkIter(t, iter, "a->va")
+    iter.Next()
+    checkIter(t, iter, "b->vb")
+    iter.Next()
+    checkIter(t, iter, "c->vc")
+    iter.Next()
+    checkIter(t, iter, "(invalid)")
+    iter.SeekToFirst()
+    checkIter(t, iter, "a->va")
+    iter.Prev()
+    checkIter(t, iter, "(invalid)")
+
+    iter.SeekToLast()
+    checkIter(t, iter, "c->vc")
+    iter.Prev()
+    checkIter(t, iter, "b->vb")
+    iter.Prev()
+    checkIter(t, iter, "a->va")
+    iter.Prev()
+    checkIter(t, iter, "(invalid)")
+    iter.SeekToLast()
+    checkIter(t, iter, "c->vc")
+    iter.Next()
+    checkIter(t, iter, "(invalid)")
+
+    iter.Seek([]byte(""))
+    checkIter(t, iter, "a->va")
+    iter.Seek([]byte("a"))
+    checkIter(t, iter, "a->va")
+    iter.Seek([]byte("ax"))
+    checkIter(t, iter, "b->vb")
+    iter.Seek([]byte("b"))
+    checkIter(t, iter, "b->vb")
+    iter.Seek([]byte("z"))
+    checkIter(t, iter, "(invalid)")
+
+    // Switch from reverse to forward
+    iter.SeekToLast()
+    iter.Prev()
+    iter.Prev()
+    iter.Next()
+    checkIter(t, iter, "b->vb")
+
+    // Switch from forward to reverse
+    iter.SeekToFirst()
+    iter.Next()
+    iter.Next()
+    iter.Prev()
+    checkIter(t, iter, "b->vb")
+
+    // Make sure iter stays at snapshot
+    db.Put(nil, []byte("a"), []byte("va2"))
+    db.Put(nil, []byte("a2"), []byte("va3"))
+    db.Put(nil, []byte("b"), []byte("vb2"))
+    db.Put(nil, []byte("c"), []byte("vc2"))
+    db.Delete(nil, []byte("b"))
+    iter.SeekToFirst()
+    checkIter(t, iter, "a->va")
+    iter.Next()
+    checkIter(t, iter, "b->vb")
+    iter.Next()
+    checkIter(t, iter, "c->vc")
+    iter.Next()
+    checkIter(t, iter, "(invalid)")
+    iter.SeekToLast()
+    checkIter(t, iter, "c->vc")
+    iter.Prev()
+    checkIter(t, iter, "b->vb")
+    iter.Prev()
+    checkIter(t, iter, "a->va")
+    iter.Prev()
+    checkIter(t, iter, "(invalid)")
+
+    if err := iter.Error(); err != nil {
+      t.Error("Unexpected iterator error: ", err)
+    }
+    iter.Close()
+  }
+}
+
+func TestDBIterMultiWithDelete(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  db.Put(nil, []byte("ka"), []byte("va"))
+  db.Put(nil, []byte("kb"), []byte("vb"))
+  db.Put(nil, []byte("kc"), []byte("vc"))
+  db.Delete(nil, []byte("kb"))
+  if err := db.flushMemTable(); err != nil {
+    t.Fatal("Cannot flush memtable: ", err)
+  }
+  // Overwrite "ka" in the memtable so that its versions are spread over the
+  // memtable and a table.
+  db.Put(nil, []byte("ka"), []byte("va2"))
+
+  iter, _ := db.NewIterator(nil)
+  defer iter.Close()
+
+  iter.Seek([]byte("kc"))
+  checkIter(t, iter, "kc->vc")
+  iter.Prev()
+  checkIter(t, iter, "ka->va2")
+  iter.Next()
+  checkIter(t, iter, "kc->vc")
+  iter.Prev()
+  iter.Prev()
+  checkIter(t, iter, "(invalid)")
+  iter.SeekToFirst()
+  checkIter(t, iter, "ka->va2")
+  iter.Next()
+  checkIter(t, iter, "kc->vc")
+}
+
+func TestDBIterPrevMaxSkip(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  for i := 0; i < 2; i++ {
+    db.Put(nil, []byte("key1"), []byte("v1"))
+    db.Put(nil, []byte("key2"), []byte("v2"))
+    db.Put(nil, []byte("key3"), []byte("v3"))
+    db.Put(nil, []byte("key4"), []byte("v4"))
+    db.Put(nil, []byte("key5"), []byte("v5"))
+  }
+  for i := 1; i <= 5; i++ {
+    db.Delete(nil, []byte(fmt.Sprint("key", i)))
+  }
+  db.Put(nil, []byte("key0"), []byte("v0"))
+
+  iter, _ := db.NewIterator(nil)
+  defer iter.Close()
+  iter.SeekToLast()
+  checkIter(t, iter, "key0->v0")
+  iter.Prev()
+  checkIter(t, iter, "(invalid)")
+}
+
+func TestDBIterSnapshot(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  db, err := Open(dbname, testDBOptions())
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  for i := 0; i < 100; i++ {
+    db.Put(nil, []byte(fmt.Sprintf("key%03d", i)), []byte("v1"))
+  }
+  snapshot := db.GetSnapshot()
+  defer db.ReleaseSnapshot(snapshot)
+  for i := 0; i < 100; i += 2 {
+    db.Put(nil, []byte(fmt.Sprintf("key%03d", i)), []byte("v2"))
+    db.Delete(nil, []byte(fmt.Sprintf("key%03d", i + 1)))
+  }
+  if err := db.flushMemTable(); err != nil {
+    t.Fatal("Cannot flush memtable: ", err)
+  }
+
+  iter, _ := db.NewIterator(&ReadOptions{Snapshot:snapshot})
+  count := 0
+  for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+    if string(iter.Key()) != fmt.Sprintf("key%03d", count) || string(iter.Value()) != "v1" {
+      t.Fatal("Unexpected entry in snapshot: ", iterStatus(iter))
+    }
+    count++
+  }
+  iter.Close()
+  if count != 100 {
+    t.Error("Snapshot should see all keys: ", count)
+  }
+
+  iter, _ = db.NewIterator(nil)
+  count = 0
+  for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+    if string(iter.Key()) != fmt.Sprintf("key%03d", 98 - 2 * count) || string(iter.Value()) != "v2" {
+      t.Fatal("Unexpected entry: ", iterStatus(iter))
+    }
+    count++
+  }
+  iter.Close()
+  if count != 50 {
+    t.Error("Deleted keys should be skipped: ", count)
+  }
+}
+
+func TestDBIterReadErrors(t *testing.T) {
+  test := newFaultInjectionTest(t)
+  test.write(2000, true)
+  if err := test.db.CompactRange(nil, nil); err != nil {
+    t.Fatal("Compaction failed: ", err)
+  }
+
+  // Every table read fails: the iterator reports it, and so does Close for
+  // callers that only check Close.
+  test.env.setErrorProbability(faultReadAt, 1)
+  iter, err := test.db.NewIterator(&ReadOptions{})
+  if err != nil {
+    t.Fatal("Cannot create iterator: ", err)
+  }
+  for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+  }
+  if err := iter.Error(); !errors.Is(err, ErrIOError) {
+    t.Error("Iterator should report the read error: ", err)
+  }
+  if err := iter.Close(); !errors.Is(err, ErrIOError) {
+    t.Error("Close should report the read error: ", err)
+  }
+  test.env.setErrorProbability(faultReadAt, 0)
+  test.db.Close()
+}
@@ -4,9 +4,31 @@ import (
   "encoding/binary"
 )
 
+// Grouping of constants. We may want to make some of these parameters set
+// via options.
+const (
+  // Level-0 compaction is started when we hit this many files.
+  l0CompactionTrigger = 4
+  // Soft limit on number of level-0 files. We slow down writes at this point.
+  l0SlowdownWritesTrigger = 8
+  // Maximum number of level-0 files. We stop writes at this point.
+  l0StopWritesTrigger = 12
+  // Maximum level to which a new compacted memtable is pushed if it does not
+  // create overlap. We try to push to level 2 to avoid the relatively
+  // expensive level 0=>1 compactions and to avoid some expensive manifest
+  // file operations. We do not push all the way to the largest level since
+  // that can generate a lot of wasted disk space if the same key space is
+  // being repeatedly overwritten.
+  maxMemCompactLevel = 2
+)
+
 // Sequence number
 type SequenceNumber uint64
 
+// Leave eight bits empty at the bottom so a type and sequence number can be
+// packed together into 64 bits.
+const MaxSequenceNumber SequenceNumber = (1 << 56) - 1
+
 // Value type
 type ValueType uint64
 const (
@@ -14,6 +36,45 @@ const (
   TypeValue ValueType = 0x1
 )
 
+// ValueTypeForSeek defines the ValueType that should be passed when
+// constructing an internal key for seeking to a particular sequence number
+// (since we sort sequence numbers in decreasing order and the value type is
+// embedded as the low 8 bits in the sequence number in internal keys, we need
+// to use the highest-numbered ValueType, not the lowest).
+const ValueTypeForSeek = TypeValue
+
+func packSequenceAndType(seq SequenceNumber, t ValueType) uint64 {
+  return (uint64(seq) << 8) | uint64(t)
+}
+
+// Append the internal key of (userKey, seq, t) to dst.
+func AppendInternalKey(dst []byte, userKey []byte, seq SequenceNumber, t ValueType) []byte {
+  var tag [8]byte
+  binary.LittleEndian.PutUint64(tag[:], packSequenceAndType(seq, t))
+  dst = append(dst, userKey...)
+  return append(dst, tag[:]...)
+}
+
+// Parsed form of an internal key.
+type ParsedInternalKey struct {
+  UserKey []byte
+  Sequence SequenceNumber
+  Type ValueType
+}
+
+func ParseInternalKey(internalKey []byte) (ParsedInternalKey, bool) {
+  var parsed ParsedInternalKey
+  l := len(internalKey)
+  if l < 8 {
+    return parsed, false
+  }
+  num := binary.LittleEndian.Uint64(internalKey[l-8:])
+  parsed.UserKey = internalKey[:l-8]
+  parsed.Sequence = SequenceNumber(num >> 8)
+  parsed.Type = ValueType(num & 0xff)
+  return parsed, parsed.Type <= TypeValue
+}
+
 func ExtractUserKey(internalKey []byte) []byte {
   if len(internalKey) < 8 {
     panic("Invalid internal key.")
@@ -51,7 +112,7 @@ func (c *InternalKeyComparator) Compare(a, b []byte) int {
     bnum := binary.LittleEndian.Uint64(b[len(b) - 8: len(b)])
     if anum > bnum {
       r = -1
-    } else {
+    } else if anum < bnum {
       r = 1
     }
   }
@@ -59,17 +120,55 @@ func (c *InternalKeyComparator) Compare(a, b []byte) int {
 }
 
 func (c *InternalKeyComparator) FindShortestSeparator(start, limit []byte) []byte {
-  return nil
+  // Attempt to shorten the user portion of the key
+  userStart := ExtractUserKey(start)
+  userLimit := ExtractUserKey(limit)
+  tmp := c.comparator.FindShortestSeparator(append([]byte(nil), userStart...), userLimit)
+  if len(tmp) < len(userStart) && c.comparator.Compare(userStart, tmp) < 0 {
+    // User key has become shorter physically, but larger logically.
+    // Tack on the earliest possible number to the shortened user key.
+    return AppendInternalKey(nil, tmp, MaxSequenceNumber, ValueTypeForSeek)
+  }
+  return start
 }
 
 func (c *InternalKeyComparator) FindShortestSuccessor(key []byte) []byte {
-  return nil
+  userKey := ExtractUserKey(key)
+  tmp := c.comparator.FindShortestSuccessor(append([]byte(nil), userKey...))
+  if len(tmp) < len(userKey) && c.comparator.Compare(userKey, tmp) < 0 {
+    // User key has become shorter physically, but larger logically.
+    // Tack on the earliest possible number to the shortened user key.
+    return AppendInternalKey(nil, tmp, MaxSequenceNumber, ValueTypeForSeek)
+  }
+  return key
 }
 
 func (c *InternalKeyComparator) UserComparator() Comparator {
   return c.comparator
 }
 
+// Filter policy that wraps a user policy and applies it to the user key part
+// of internal keys.
+type internalFilterPolicy struct {
+  userPolicy FilterPolicy
+}
+
+func (p internalFilterPolicy) Name() string {
+  return p.userPolicy.Name()
+}
+
+func (p internalFilterPolicy) CreateFilter(keys [][]byte) []byte {
+  userKeys := make([][]byte, len(keys))
+  for i, key := range(keys) {
+    userKeys[i] = ExtractUserKey(key)
+  }
+  return p.userPolicy.CreateFilter(userKeys)
+}
+
+func (p internalFilterPolicy) MayContain(filter, key []byte) bool {
+  return p.userPolicy.MayContain(filter, ExtractUserKey(key))
+}
+
 // Lookup key
 type LookupKey struct {
   userKeySize int
@@ -1,19 +1,54 @@
 package leveldb
 
 import (
+    "io"
     "os"
+    "sync"
+    "sync/atomic"
+    "time"
 )
 
 // Environment interface.
 type Env interface {
   NewSequentialFile(string) (SequentialFile, error)
   NewRandomAccessFile(string) (RandomAccessFile, error)
+  // Create a new file, truncating any existing one.
   NewWritableFile(string) (WritableFile, error)
+  // Open a file for appending, creating it if needed.
   NewAppendableFile(string) (WritableFile, error)
   DeleteFile(string) error
   GetFileSize(string) (uint64, error)
+  CreateDir(string) error
+  RemoveDir(string) error
+  GetChildren(string) ([]string, error)
+  FileExists(string) bool
+  RenameFile(string, string) error
+  // Make the entries of a directory, e.g. created or renamed files,
+  // durable.
+  SyncDir(string) error
+
+  // Lock the named file, creating it if needed, to prevent concurrent
+  // access to a database by several processes. Fails if the file is
+  // already locked, by this process or another one. The lock is held until
+  // it is passed to UnlockFile.
+  LockFile(string) (FileLock, error)
+  UnlockFile(FileLock) error
+
+  // Number of microseconds since some fixed point in time. Only useful for
+  // computing deltas of time.
+  NowMicros() uint64
+  SleepForMicroseconds(int)
+
+  // Arrange to run f once in a background goroutine. Functions passed to
+  // Schedule run one at a time, in the order they were scheduled.
+  Schedule(f func())
+  // Start a new goroutine running f.
+  StartThread(f func())
 }
 
+// A lock acquired by Env.LockFile.
+type FileLock interface {}
+
 // File for sequential read.
 type SequentialFile interface {
   Close() error
@@ -27,6 +62,15 @@ type RandomAccessFile interface {
   ReadAt([]byte, int64) (int, error)
 }
 
+// A RandomAccessFile that can return its contents without copying them,
+// e.g. because the file is mapped in memory. Like ReadAt, Slice returns
+// io.EOF with fewer than n bytes at the end of the file. The returned slice
+// must not be modified, and is only valid until the file is closed.
+type SliceableFile interface {
+  RandomAccessFile
+  Slice(off int64, n int) ([]byte, error)
+}
+
 // File for sequential write.
 type WritableFile interface {
   Close() error
@@ -36,72 +80,244 @@ type WritableFile interface {
 
 // Implementation.
 type env struct {
+  // Work queue of the background goroutine, started by the first Schedule.
+  mu sync.Mutex
+  queueNotEmpty *sync.Cond
+  queue []func()
+  started bool
+
+  // Number of files NewRandomAccessFile may still map in memory.
+  mmapSlots atomic.Int64
 }
 
+var defaultEnv = &env{}
+
+// Return the Env backed by the operating system. It is shared by every
+// caller, and so is its background goroutine.
 func DefaultEnv() Env {
-  return &env{}
+  return defaultEnv
 }
 
 func (e *env) NewSequentialFile(filename string) (SequentialFile, error) {
   f, err := os.OpenFile(filename, os.O_RDONLY, 0644)
   if err != nil {
-    return nil, err
+    return nil, fileError(filename, err)
   }
   return &sequentialFile{filename:filename, f:f}, nil
 }
 
+// Return an Env backed by the operating system that maps up to
+// maxMappedFiles of the files opened by NewRandomAccessFile in memory, so
+// that table reads return slices of the mapping instead of copies. Other
+// files, and every file on systems other than Linux, are read as with the
+// default Env. Every call returns a new Env, with its own background
+// goroutine.
+func NewMmapEnv(maxMappedFiles int) Env {
+  e := &env{}
+  e.mmapSlots.Store(int64(maxMappedFiles))
+  return e
+}
+
 func (e *env) NewRandomAccessFile(filename string) (RandomAccessFile, error) {
   f, err := os.OpenFile(filename, os.O_RDONLY, 0644)
   if err != nil {
-    return nil, err
+    return nil, fileError(filename, err)
   }
+  if e.mmapSlots.Add(-1) >= 0 {
+    file, err := newMmapFile(filename, f, func() { e.mmapSlots.Add(1) })
+    if err == nil {
+      return file, nil
+    }
+  }
+  // Fall back to reads when no slot is left or the file cannot be mapped.
+  e.mmapSlots.Add(1)
   return &randomAccessFile{filename:filename, f:f}, nil
 }
 
 func (e *env) NewWritableFile(filename string) (WritableFile, error) {
-  f, err := os.OpenFile(filename, os.O_CREATE | os.O_WRONLY, 0644)
+  f, err := os.OpenFile(filename, os.O_CREATE | os.O_WRONLY | os.O_TRUNC, 0644)
   if err != nil {
-    return nil, err
+    return nil, fileError(filename, err)
   }
   return &writableFile{filename:filename, f:f}, nil
 }
 
 func (e *env) NewAppendableFile(filename string) (WritableFile, error) {
-  f, err := os.OpenFile(filename, os.O_CREATE | os.O_APPEND, 0644)
+  f, err := os.OpenFile(filename, os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0644)
   if err != nil {
-    return nil, err
+    return nil, fileError(filename, err)
   }
   return &writableFile{filename:filename, f:f}, nil
 }
 
 func (e *env) DeleteFile(filename string) error {
-  return os.Remove(filename)
+  return fileError(filename, os.Remove(filename))
 }
 
 func (e *env) GetFileSize(filename string) (uint64, error) {
   info, err := os.Stat(filename)
   if err != nil {
-    return 0, err
+    return 0, fileError(filename, err)
   }
   return uint64(info.Size()), nil
 }
 
+func (e *env) CreateDir(dirname string) error {
+  return fileError(dirname, os.Mkdir(dirname, 0755))
+}
+
+func (e *env) RemoveDir(dirname string) error {
+  return fileError(dirname, os.Remove(dirname))
+}
+
+func (e *env) GetChildren(dirname string) ([]string, error) {
+  d, err := os.Open(dirname)
+  if err != nil {
+    return nil, fileError(dirname, err)
+  }
+  defer d.Close()
+  names, err := d.Readdirnames(-1)
+  return names, fileError(dirname, err)
+}
+
+func (e *env) FileExists(filename string) bool {
+  _, err := os.Stat(filename)
+  return err == nil
+}
+
+func (e *env) RenameFile(src, target string) error {
+  return fileError(src, os.Rename(src, target))
+}
+
+func (e *env) SyncDir(dirname string) error {
+  return fileError(dirname, syncDir(dirname))
+}
+
+type posixFileLock struct {
+  filename string
+  f *os.File
+}
+
+func (e *env) LockFile(filename string) (FileLock, error) {
+  f, err := os.OpenFile(filename, os.O_CREATE | os.O_RDWR, 0644)
+  if err != nil {
+    return nil, fileError(filename, err)
+  }
+  if err := lockFile(f); err != nil {
+    f.Close()
+    s := newStatus(StatusIOError, "lock")
+    s.File = filename
+    s.err = err
+    return nil, s
+  }
+  return &posixFileLock{filename:filename, f:f}, nil
+}
+
+func (e *env) UnlockFile(lock FileLock) error {
+  l := lock.(*posixFileLock)
+  err := unlockFile(l.f)
+  if closeErr := l.f.Close(); err == nil {
+    err = closeErr
+  }
+  return fileError(l.filename, err)
+}
+
+func (e *env) NowMicros() uint64 {
+  return uint64(time.Now().UnixMicro())
+}
+
+func (e *env) SleepForMicroseconds(micros int) {
+  time.Sleep(time.Duration(micros) * time.Microsecond)
+}
+
+func (e *env) Schedule(f func()) {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  // Start background goroutine if necessary
+  if !e.started {
+    e.started = true
+    e.queueNotEmpty = sync.NewCond(&e.mu)
+    go e.backgroundLoop()
+  }
+  e.queue = append(e.queue, f)
+  e.queueNotEmpty.Signal()
+}
+
+func (e *env) backgroundLoop() {
+  for {
+    e.mu.Lock()
+    for len(e.queue) == 0 {
+      e.queueNotEmpty.Wait()
+    }
+    f := e.queue[0]
+    e.queue[0] = nil
+    e.queue = e.queue[1:]
+    e.mu.Unlock()
+    f()
+  }
+}
+
+func (e *env) StartThread(f func()) {
+  go f()
+}
+
+// Write data to the named file, syncing it if requested.
+func writeStringToFile(env Env, data []byte, filename string, sync bool) error {
+  file, err := env.NewWritableFile(filename)
+  if err != nil {
+    return err
+  }
+  _, err = file.Write(data)
+  if err == nil && sync {
+    err = file.Sync()
+  }
+  if closeErr := file.Close(); err == nil {
+    err = closeErr
+  }
+  if err != nil {
+    env.DeleteFile(filename)
+  }
+  return err
+}
+
+// Read the whole contents of the named file.
+func readFileToString(env Env, filename string) ([]byte, error) {
+  file, err := env.NewSequentialFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+
+  data := make([]byte, 0)
+  buf := make([]byte, 8192)
+  for {
+    n, err := file.Read(buf)
+    data = append(data, buf[:n]...)
+    if err == io.EOF {
+      return data, nil
+    } else if err != nil {
+      return nil, err
+    }
+  }
+}
+
 type sequentialFile struct {
   filename string
   f *os.File
 }
 
 func (f *sequentialFile) Close() error {
-  return f.f.Close()
+  return fileError(f.filename, f.f.Close())
 }
 
 func (f *sequentialFile) Read(b []byte) (int, error) {
-  return f.f.Read(b)
+  n, err := f.f.Read(b)
+  return n, fileError(f.filename, err)
 }
 
 func (f *sequentialFile) Skip(n int64) error {
   _, err := f.f.Seek(n, os.SEEK_CUR)
-  return err
+  return fileError(f.filename, err)
 }
 
 type randomAccessFile struct {
@@ -110,11 +326,12 @@ type randomAccessFile struct {
 }
 
 func (f *randomAccessFile) Close() error {
-  return f.f.Close()
+  return fileError(f.filename, f.f.Close())
 }
 
 func (f *randomAccessFile) ReadAt(b []byte, off int64) (int, error) {
-  return f.f.ReadAt(b, off)
+  n, err := f.f.ReadAt(b, off)
+  return n, fileError(f.filename, err)
 }
 
 type writableFile struct {
@@ -123,14 +340,15 @@ type writableFile struct {
 }
 
 func (f *writableFile) Close() error {
-  return f.f.Close()
+  return fileError(f.filename, f.f.Close())
 }
 
 func (f *writableFile) Write(b []byte) (int, error) {
-  return f.f.Write(b)
+  n, err := f.f.Write(b)
+  return n, fileError(f.filename, err)
 }
 
 func (f *writableFile) Sync() error {
-  return f.f.Sync()
+  return fileError(f.filename, f.f.Sync())
 }
 
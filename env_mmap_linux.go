@@ -0,0 +1,66 @@
+//go:build linux
+
+package leveldb
+
+import (
+  "io"
+  "os"
+  "syscall"
+)
+
+// A RandomAccessFile mapped in memory. The mapping outlives the descriptor
+// it was made from, which is closed right away.
+type mmapFile struct {
+  filename string
+  data []byte
+  release func()  // Give back the slot of the mapping
+}
+
+// Map f in memory, and close it on success. Empty files cannot be mapped.
+func newMmapFile(filename string, f *os.File, release func()) (RandomAccessFile, error) {
+  info, err := f.Stat()
+  if err != nil {
+    return nil, err
+  }
+  if info.Size() == 0 || int64(int(info.Size())) != info.Size() {
+    return nil, syscall.EINVAL
+  }
+  data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+  if err != nil {
+    return nil, err
+  }
+  f.Close()
+  return &mmapFile{filename:filename, data:data, release:release}, nil
+}
+
+func (f *mmapFile) Close() error {
+  if f.data == nil {
+    return fileError(f.filename, os.ErrClosed)
+  }
+  err := syscall.Munmap(f.data)
+  f.data = nil
+  f.release()
+  return fileError(f.filename, err)
+}
+
+func (f *mmapFile) ReadAt(b []byte, off int64) (int, error) {
+  data, err := f.Slice(off, len(b))
+  n := copy(b, data)
+  return n, err
+}
+
+func (f *mmapFile) Slice(off int64, n int) ([]byte, error) {
+  if off < 0 || n < 0 {
+    return nil, fileError(f.filename, &os.PathError{Op:"read", Path:f.filename, Err:os.ErrInvalid})
+  }
+  if off >= int64(len(f.data)) {
+    if n == 0 {
+      return nil, nil
+    }
+    return nil, io.EOF
+  }
+  if rest := int64(len(f.data)) - off; int64(n) > rest {
+    return f.data[off:], io.EOF
+  }
+  return f.data[off:off + int64(n):off + int64(n)], nil
+}
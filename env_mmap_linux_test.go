@@ -0,0 +1,131 @@
+//go:build linux
+
+package leveldb
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "testing"
+)
+
+func TestMmapEnvRandomAccessFile(t *testing.T) {
+  dir := newTestDBName()
+  defer os.RemoveAll(dir)
+  env := NewMmapEnv(1)
+  if err := env.CreateDir(dir); err != nil {
+    t.Fatal("Cannot create directory: ", err)
+  }
+  filename := dir + "/f"
+  if err := writeStringToFile(env, []byte("hello world"), filename, false); err != nil {
+    t.Fatal("Cannot write file: ", err)
+  }
+
+  file, err := env.NewRandomAccessFile(filename)
+  if err != nil {
+    t.Fatal("Cannot open file: ", err)
+  }
+  sliceable, ok := file.(SliceableFile)
+  if !ok {
+    t.Fatal("File should be mapped.")
+  }
+  if data, err := sliceable.Slice(6, 5); err != nil || string(data) != "world" {
+    t.Error("Unexpected slice: ", string(data), " ", err)
+  }
+  if data, err := sliceable.Slice(6, 10); err != io.EOF || string(data) != "world" {
+    t.Error("Slice past the end should be short: ", string(data), " ", err)
+  }
+  buf := make([]byte, 8)
+  if n, err := file.ReadAt(buf, 6); err != io.EOF || string(buf[:n]) != "world" {
+    t.Error("Unexpected read: ", string(buf[:n]), " ", err)
+  }
+  if _, err := sliceable.Slice(-1, 1); !errors.Is(err, ErrIOError) {
+    t.Error("Negative offset should fail: ", err)
+  }
+
+  // The only slot is taken, so the next file is read instead.
+  other, err := env.NewRandomAccessFile(filename)
+  if err != nil {
+    t.Fatal("Cannot open file: ", err)
+  }
+  if _, ok := other.(SliceableFile); ok {
+    t.Error("File over the limit should not be mapped.")
+  }
+  if n, err := other.ReadAt(buf[:5], 0); err != nil || string(buf[:n]) != "hello" {
+    t.Error("Unexpected read: ", string(buf[:n]), " ", err)
+  }
+  other.Close()
+
+  if err := file.Close(); err != nil {
+    t.Error("Cannot close file: ", err)
+  }
+  if err := file.Close(); err == nil {
+    t.Error("File should not be closed twice.")
+  }
+  file, err = env.NewRandomAccessFile(filename)
+  if err != nil {
+    t.Fatal("Cannot open file: ", err)
+  }
+  if _, ok := file.(SliceableFile); !ok {
+    t.Error("Closing a mapped file should free its slot.")
+  }
+  file.Close()
+
+  // Empty files cannot be mapped.
+  writeStringToFile(env, nil, filename, false)
+  file, err = env.NewRandomAccessFile(filename)
+  if err != nil {
+    t.Fatal("Cannot open file: ", err)
+  }
+  if _, ok := file.(SliceableFile); ok {
+    t.Error("Empty file should not be mapped.")
+  }
+  file.Close()
+}
+
+func TestMmapEnvDB(t *testing.T) {
+  for _, compression := range([]CompressionType{NoCompression, SnappyCompression}) {
+    dbname := newTestDBName()
+    defer os.RemoveAll(dbname)
+
+    options := testDBOptions()
+    options.Env = NewMmapEnv(1000)
+    options.CompressionType = compression
+    db, err := Open(dbname, options)
+    if err != nil {
+      t.Fatal("Cannot open database: ", err)
+    }
+    defer db.Close()
+
+    for i := 0; i < 1000; i++ {
+      db.Put(nil, []byte(fmt.Sprint("key", i)), []byte(fmt.Sprint("value", i)))
+    }
+    if err := db.flushMemTable(); err != nil {
+      t.Fatal("Cannot flush memtable: ", err)
+    }
+
+    // Uncompressed blocks point into the mapping and must not be cached,
+    // since the mapping is gone once the table is evicted.
+    usage := db.options.BlockCache.TotalCharge()
+    value, err := db.Get(&ReadOptions{VerifyChecksums:true, FillCache:true}, []byte("key7"))
+    if err != nil || string(value) != "value7" {
+      t.Fatal("Unexpected value: ", string(value), " ", err)
+    }
+    if cached := db.options.BlockCache.TotalCharge() > usage; cached != (compression != NoCompression) {
+      t.Error("Unexpected caching of a block with compression ", compression, ": ", cached)
+    }
+
+    iter, err := db.NewIterator(nil)
+    if err != nil {
+      t.Fatal("Cannot create iterator: ", err)
+    }
+    n := 0
+    for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+      n++
+    }
+    if err := iter.Close(); err != nil || n != 1000 {
+      t.Error("Unexpected scan: ", n, " ", err)
+    }
+  }
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package leveldb
+
+import (
+  "errors"
+  "os"
+)
+
+// Files are only mapped in memory on Linux.
+func newMmapFile(filename string, f *os.File, release func()) (RandomAccessFile, error) {
+  return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package leveldb
+
+import (
+  "errors"
+  "os"
+)
+
+func lockFile(f *os.File) error {
+  return errors.ErrUnsupported
+}
+
+func unlockFile(f *os.File) error {
+  return errors.ErrUnsupported
+}
+
+// Directories cannot be synced on this platform; renames are made durable
+// by the file system itself.
+func syncDir(dirname string) error {
+  return nil
+}
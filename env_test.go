@@ -0,0 +1,150 @@
+package leveldb
+
+import (
+  "errors"
+  "os"
+  "sync"
+  "testing"
+)
+
+// Run test against the default Env in a fresh directory, and against a
+// memory Env.
+func forEachEnv(t *testing.T, test func(t *testing.T, env Env, dir string)) {
+  t.Run("Default", func(t *testing.T) {
+    dir := newTestDBName()
+    defer os.RemoveAll(dir)
+    env := DefaultEnv()
+    if err := env.CreateDir(dir); err != nil {
+      t.Fatal("Cannot create directory: ", err)
+    }
+    test(t, env, dir)
+  })
+  t.Run("Mem", func(t *testing.T) {
+    env := NewMemEnv()
+    if err := env.CreateDir("/dir"); err != nil {
+      t.Fatal("Cannot create directory: ", err)
+    }
+    test(t, env, "/dir")
+  })
+}
+
+func TestEnvWritableAndAppendableFiles(t *testing.T) {
+  forEachEnv(t, func(t *testing.T, env Env, dir string) {
+    filename := dir + "/f"
+    if err := writeStringToFile(env, []byte("hello world"), filename, true); err != nil {
+      t.Fatal("Cannot write file: ", err)
+    }
+
+    // Appending keeps the existing contents.
+    file, err := env.NewAppendableFile(filename)
+    if err != nil {
+      t.Fatal("Cannot open file for appending: ", err)
+    }
+    if _, err := file.Write([]byte("!")); err != nil {
+      t.Error("Cannot append: ", err)
+    }
+    file.Close()
+    if data, err := readFileToString(env, filename); err != nil || string(data) != "hello world!" {
+      t.Error("Unexpected contents: ", string(data), " ", err)
+    }
+
+    // A new writable file replaces a longer one entirely.
+    if err := writeStringToFile(env, []byte("bye"), filename, false); err != nil {
+      t.Fatal("Cannot write file: ", err)
+    }
+    if data, err := readFileToString(env, filename); err != nil || string(data) != "bye" {
+      t.Error("Writable file should be truncated: ", string(data), " ", err)
+    }
+  })
+}
+
+func TestEnvDirs(t *testing.T) {
+  forEachEnv(t, func(t *testing.T, env Env, dir string) {
+    sub := dir + "/sub"
+    if err := env.CreateDir(sub); err != nil {
+      t.Fatal("Cannot create directory: ", err)
+    }
+    if err := env.CreateDir(sub); err == nil {
+      t.Error("Existing directory should not be created again.")
+    }
+    writeStringToFile(env, []byte("x"), sub + "/f", false)
+    if err := env.RemoveDir(sub); err == nil {
+      t.Error("Non-empty directory should not be removed.")
+    }
+    env.DeleteFile(sub + "/f")
+    if err := env.SyncDir(sub); err != nil {
+      t.Error("Cannot sync directory: ", err)
+    }
+    if err := env.RemoveDir(sub); err != nil {
+      t.Error("Cannot remove directory: ", err)
+    }
+    if env.FileExists(sub) {
+      t.Error("Directory should have been removed.")
+    }
+    if err := env.RemoveDir(sub); !errors.Is(err, ErrNotFound) {
+      t.Error("Missing directory should not be removed: ", err)
+    }
+  })
+}
+
+func TestEnvLockFile(t *testing.T) {
+  forEachEnv(t, func(t *testing.T, env Env, dir string) {
+    filename := dir + "/LOCK"
+    lock, err := env.LockFile(filename)
+    if err != nil {
+      t.Fatal("Cannot lock file: ", err)
+    }
+    if !env.FileExists(filename) {
+      t.Error("Lock file should be created.")
+    }
+    if _, err := env.LockFile(filename); !errors.Is(err, ErrIOError) {
+      t.Error("Locked file should not be locked again: ", err)
+    }
+    if err := env.UnlockFile(lock); err != nil {
+      t.Error("Cannot unlock file: ", err)
+    }
+    lock, err = env.LockFile(filename)
+    if err != nil {
+      t.Fatal("Cannot lock unlocked file: ", err)
+    }
+    env.UnlockFile(lock)
+  })
+}
+
+func TestEnvSchedule(t *testing.T) {
+  env := DefaultEnv()
+  var mu sync.Mutex
+  var order []int
+  var wg sync.WaitGroup
+  for i := 0; i < 100; i++ {
+    wg.Add(1)
+    i := i
+    env.Schedule(func() {
+      defer wg.Done()
+      mu.Lock()
+      order = append(order, i)
+      mu.Unlock()
+    })
+  }
+  wg.Wait()
+  for i, v := range(order) {
+    if v != i {
+      t.Fatal("Scheduled functions should run in order: ", order)
+    }
+  }
+
+  done := make(chan bool)
+  env.StartThread(func() {
+    done <- true
+  })
+  <-done
+}
+
+func TestEnvTime(t *testing.T) {
+  env := DefaultEnv()
+  start := env.NowMicros()
+  env.SleepForMicroseconds(10000)
+  if elapsed := env.NowMicros() - start; elapsed < 10000 {
+    t.Error("Sleep was too short: ", elapsed)
+  }
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package leveldb
+
+import (
+  "os"
+  "syscall"
+)
+
+// Take an exclusive flock on f. The lock belongs to the open file, so a
+// second attempt through another open of the same file fails even within
+// one process.
+func lockFile(f *os.File) error {
+  return syscall.Flock(int(f.Fd()), syscall.LOCK_EX | syscall.LOCK_NB)
+}
+
+func unlockFile(f *os.File) error {
+  return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+func syncDir(dirname string) error {
+  d, err := os.Open(dirname)
+  if err != nil {
+    return err
+  }
+  err = d.Sync()
+  if closeErr := d.Close(); err == nil {
+    err = closeErr
+  }
+  return err
+}
@@ -1,17 +1,159 @@
 package leveldb
 
 import (
+  "errors"
+  "fmt"
+  "io"
+  "os"
 )
 
+// The kind of failure reported by a Status.
+type StatusCode int
+const (
+  StatusNotFound StatusCode = 1
+  StatusCorruption StatusCode = 2
+  StatusNotSupported StatusCode = 3
+  StatusInvalidArgument StatusCode = 4
+  StatusIOError StatusCode = 5
+)
+
+func (code StatusCode) String() string {
+  switch code {
+  case StatusNotFound:
+    return "NotFound"
+  case StatusCorruption:
+    return "Corruption"
+  case StatusNotSupported:
+    return "Not implemented"
+  case StatusInvalidArgument:
+    return "Invalid argument"
+  case StatusIOError:
+    return "IO error"
+  }
+  return fmt.Sprint("Unknown code(", int(code), ")")
+}
+
+// Status is the error returned by the operations of a DB. Its kind can be
+// tested with errors.Is against ErrNotFound, ErrCorruption, ErrNotSupported,
+// ErrInvalidArgument and ErrIOError; errors.As gives access to the file and
+// offset the error was found at.
 type Status struct {
-	code int
-	msg string
+  code StatusCode
+  msg string
+
+  // The file the error refers to, or "" if unknown.
+  File string
+  // The offset in the file the error refers to, or -1 if unknown.
+  Offset int64
+
+  // The underlying error, if any.
+  err error
+}
+
+// Sentinel values to test the kind of a Status with errors.Is.
+var (
+  ErrNotFound error = &Status{code:StatusNotFound, Offset:-1}
+  ErrCorruption error = &Status{code:StatusCorruption, Offset:-1}
+  ErrNotSupported error = &Status{code:StatusNotSupported, Offset:-1}
+  ErrInvalidArgument error = &Status{code:StatusInvalidArgument, Offset:-1}
+  ErrIOError error = &Status{code:StatusIOError, Offset:-1}
+)
+
+func (s *Status) Code() StatusCode {
+  return s.code
 }
 
 func (s *Status) Error() string {
-	return s.msg
+  r := s.code.String()
+  if s.msg != "" {
+    r += ": " + s.msg
+  }
+  if s.File != "" && s.Offset >= 0 {
+    r += fmt.Sprint(" (", s.File, " at offset ", s.Offset, ")")
+  } else if s.File != "" {
+    r += fmt.Sprint(" (", s.File, ")")
+  } else if s.Offset >= 0 {
+    r += fmt.Sprint(" (at offset ", s.Offset, ")")
+  }
+  if s.err != nil {
+    r += ": " + s.err.Error()
+  }
+  return r
+}
+
+func (s *Status) Unwrap() error {
+  return s.err
+}
+
+// A Status matches the sentinel of its kind.
+func (s *Status) Is(target error) bool {
+  switch target {
+  case ErrNotFound, ErrCorruption, ErrNotSupported, ErrInvalidArgument, ErrIOError:
+    return target.(*Status).code == s.code
+  }
+  return false
+}
+
+func newStatus(code StatusCode, msg string) *Status {
+  return &Status{code:code, msg:msg, Offset:-1}
 }
 
 func NotFoundError(msg string) error {
-	return &Status{1, msg}
+  return newStatus(StatusNotFound, msg)
+}
+
+func CorruptionError(msg string) error {
+  return newStatus(StatusCorruption, msg)
+}
+
+func NotSupportedError(msg string) error {
+  return newStatus(StatusNotSupported, msg)
+}
+
+func InvalidArgumentError(msg string) error {
+  return newStatus(StatusInvalidArgument, msg)
+}
+
+func IOError(msg string) error {
+  return newStatus(StatusIOError, msg)
+}
+
+// Return a corruption error found at offset.
+func corruptionAt(offset uint64, msg string) error {
+  s := newStatus(StatusCorruption, msg)
+  s.Offset = int64(offset)
+  return s
+}
+
+// Return err annotated with the file it was found in, unless it already
+// names a file.
+func withFile(err error, file string) error {
+  s, ok := err.(*Status)
+  if !ok || s.File != "" {
+    return err
+  }
+  annotated := *s
+  annotated.File = file
+  return &annotated
+}
+
+// Convert an error of the os package about file into a Status. A missing
+// file is reported as NotFound, anything else as an IOError; the original
+// error stays available through errors.Is and errors.As. io.EOF is passed
+// through since readers rely on it.
+func fileError(file string, err error) error {
+  if err == nil || err == io.EOF {
+    return err
+  }
+  if _, ok := err.(*Status); ok {
+    return err
+  }
+  code := StatusIOError
+  if errors.Is(err, os.ErrNotExist) {
+    code = StatusNotFound
+  }
+  s := newStatus(code, "")
+  s.File = file
+  s.err = err
+  return s
 }
@@ -0,0 +1,75 @@
+package leveldb
+
+import (
+  "errors"
+  "os"
+  "strings"
+  "testing"
+)
+
+func TestStatusIs(t *testing.T) {
+  for _, c := range([]struct{
+    err error
+    kind error
+  }{
+    {NotFoundError("a"), ErrNotFound},
+    {CorruptionError("b"), ErrCorruption},
+    {NotSupportedError("c"), ErrNotSupported},
+    {InvalidArgumentError("d"), ErrInvalidArgument},
+    {IOError("e"), ErrIOError},
+  }) {
+    if !errors.Is(c.err, c.kind) {
+      t.Error(c.err, " should be a ", c.kind)
+    }
+    for _, other := range([]error{ErrNotFound, ErrCorruption, ErrNotSupported, ErrInvalidArgument, ErrIOError}) {
+      if other != c.kind && errors.Is(c.err, other) {
+        t.Error(c.err, " should not be a ", other)
+      }
+    }
+  }
+  if errors.Is(CorruptionError("a"), CorruptionError("a")) {
+    t.Error("Only the sentinels should match a kind.")
+  }
+}
+
+func TestStatusContext(t *testing.T) {
+  err := withFile(corruptionAt(4096, "block checksum mismatch"), "/tmp/db/000005.ldb")
+  var s *Status
+  if !errors.As(err, &s) {
+    t.Fatal("Status expected: ", err)
+  }
+  if s.Code() != StatusCorruption || s.File != "/tmp/db/000005.ldb" || s.Offset != 4096 {
+    t.Error("Unexpected status: ", s.Code(), " ", s.File, " ", s.Offset)
+  }
+  if err.Error() != "Corruption: block checksum mismatch (/tmp/db/000005.ldb at offset 4096)" {
+    t.Error("Unexpected message: ", err)
+  }
+
+  // A file that is already known is kept.
+  if s := withFile(err, "other").(*Status); s.File != "/tmp/db/000005.ldb" {
+    t.Error("File should not be replaced: ", s.File)
+  }
+  if withFile(nil, "file") != nil {
+    t.Error("withFile(nil) should be nil.")
+  }
+}
+
+func TestFileError(t *testing.T) {
+  env := DefaultEnv()
+  _, err := env.NewSequentialFile("/tmp/leveldb_error_test_missing_file")
+  if !errors.Is(err, ErrNotFound) || !errors.Is(err, os.ErrNotExist) {
+    t.Error("Missing file should be NotFound: ", err)
+  }
+  var s *Status
+  if !errors.As(err, &s) || s.File != "/tmp/leveldb_error_test_missing_file" {
+    t.Error("Error should name the file: ", err)
+  }
+
+  err = env.CreateDir("/tmp/leveldb_error_test_missing_dir/child")
+  if !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrIOError) {
+    t.Error("Unexpected error: ", err)
+  }
+  if !strings.HasPrefix(IOError("disk full").Error(), "IO error: ") {
+    t.Error("Unexpected message: ", IOError("disk full"))
+  }
+}
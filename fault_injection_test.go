@@ -0,0 +1,464 @@
+package leveldb
+
+import (
+  "errors"
+  "fmt"
+  "math/rand"
+  "path/filepath"
+  "sync"
+  "testing"
+)
+
+// The operations of a faultInjectionEnv that can fail on purpose.
+type faultOp int
+const (
+  faultWrite faultOp = iota
+  faultSync
+  faultReadAt
+  faultRename
+  numFaultOps
+)
+
+var errFilesystemInactive = IOError("filesystem inactive (simulated crash)")
+var errInjectedFault = IOError("injected fault")
+
+// How much of a file written through a faultInjectionEnv is durable.
+type faultFileState struct {
+  pos int64  // Bytes written
+  syncedPos int64  // Bytes written before the last Sync
+}
+
+// An Env that keeps track of the data that would survive a power loss: the
+// bytes of each file up to its last Sync, in the files whose directory
+// entries were synced. resetToSyncedState then simulates the power loss by
+// dropping everything else. Until then, the file system can be made to fail
+// all writes from a chosen point on, which simulates a crash, and to fail
+// some operations at random.
+type faultInjectionEnv struct {
+  Env
+  mu sync.Mutex
+  // Files written since the last reset
+  files map[string]*faultFileState
+  // Files created since the last sync of their directory, by directory
+  newFiles map[string]map[string]bool
+  active bool
+  writesUntilCrash int  // Writes and syncs to allow before crashing, or -1
+  errorProbability [numFaultOps]float64
+  rnd *rand.Rand
+}
+
+func newFaultInjectionEnv(base Env) *faultInjectionEnv {
+  env := &faultInjectionEnv{Env:base}
+  env.files = make(map[string]*faultFileState)
+  env.newFiles = make(map[string]map[string]bool)
+  env.active = true
+  env.writesUntilCrash = -1
+  env.rnd = rand.New(rand.NewSource(301))
+  return env
+}
+
+// Make every write to the file system fail from now on.
+func (env *faultInjectionEnv) crash() {
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  env.active = false
+}
+
+// Crash once n more writes or syncs have succeeded.
+func (env *faultInjectionEnv) crashAfter(n int) {
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  env.writesUntilCrash = n
+}
+
+func (env *faultInjectionEnv) setErrorProbability(op faultOp, p float64) {
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  env.errorProbability[op] = p
+}
+
+// Return the error op must fail with, if any.
+func (env *faultInjectionEnv) check(op faultOp) error {
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  if !env.active && op != faultReadAt {
+    return errFilesystemInactive
+  }
+  if op == faultWrite || op == faultSync {
+    if env.writesUntilCrash == 0 {
+      env.active = false
+      return errFilesystemInactive
+    } else if env.writesUntilCrash > 0 {
+      env.writesUntilCrash--
+    }
+  }
+  if env.rnd.Float64() < env.errorProbability[op] {
+    return errInjectedFault
+  }
+  return nil
+}
+
+func (env *faultInjectionEnv) checkActive() error {
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  if !env.active {
+    return errFilesystemInactive
+  }
+  return nil
+}
+
+// Record that filename was created and its directory entry is not durable.
+// REQUIRES: env.mu held.
+func (env *faultInjectionEnv) addNewFile(filename string) {
+  dir, name := filepath.Split(filepath.Clean(filename))
+  dir = filepath.Clean(dir)
+  if env.newFiles[dir] == nil {
+    env.newFiles[dir] = make(map[string]bool)
+  }
+  env.newFiles[dir][name] = true
+}
+
+// Forget filename, returning whether it was created since the last sync of
+// its directory.
+// REQUIRES: env.mu held.
+func (env *faultInjectionEnv) removeFile(filename string) bool {
+  dir, name := filepath.Split(filepath.Clean(filename))
+  dir = filepath.Clean(dir)
+  delete(env.files, filepath.Clean(filename))
+  isNew := env.newFiles[dir][name]
+  delete(env.newFiles[dir], name)
+  return isNew
+}
+
+func (env *faultInjectionEnv) NewWritableFile(filename string) (WritableFile, error) {
+  if err := env.checkActive(); err != nil {
+    return nil, err
+  }
+  existed := env.Env.FileExists(filename)
+  file, err := env.Env.NewWritableFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  // A file replaced since the last sync of its directory is still new.
+  if isNew := env.removeFile(filename); isNew || !existed {
+    env.addNewFile(filename)
+  }
+  state := &faultFileState{}
+  env.files[filepath.Clean(filename)] = state
+  return &faultWritableFile{WritableFile:file, env:env, state:state}, nil
+}
+
+func (env *faultInjectionEnv) NewAppendableFile(filename string) (WritableFile, error) {
+  if err := env.checkActive(); err != nil {
+    return nil, err
+  }
+  existed := env.Env.FileExists(filename)
+  file, err := env.Env.NewAppendableFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  state, ok := env.files[filepath.Clean(filename)]
+  if !ok {
+    // The existing contents are durable.
+    size, _ := env.Env.GetFileSize(filename)
+    state = &faultFileState{pos:int64(size), syncedPos:int64(size)}
+    env.files[filepath.Clean(filename)] = state
+  }
+  if !existed {
+    env.addNewFile(filename)
+  }
+  return &faultWritableFile{WritableFile:file, env:env, state:state}, nil
+}
+
+func (env *faultInjectionEnv) NewRandomAccessFile(filename string) (RandomAccessFile, error) {
+  file, err := env.Env.NewRandomAccessFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  return &faultRandomAccessFile{RandomAccessFile:file, env:env}, nil
+}
+
+func (env *faultInjectionEnv) DeleteFile(filename string) error {
+  if err := env.checkActive(); err != nil {
+    return err
+  }
+  if err := env.Env.DeleteFile(filename); err != nil {
+    return err
+  }
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  env.removeFile(filename)
+  return nil
+}
+
+func (env *faultInjectionEnv) RenameFile(src, target string) error {
+  if err := env.check(faultRename); err != nil {
+    return err
+  }
+  if err := env.Env.RenameFile(src, target); err != nil {
+    return err
+  }
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  state := env.files[filepath.Clean(src)]
+  isNew := env.removeFile(src)
+  env.removeFile(target)
+  if state != nil {
+    env.files[filepath.Clean(target)] = state
+  }
+  if isNew {
+    env.addNewFile(target)
+  }
+  return nil
+}
+
+func (env *faultInjectionEnv) SyncDir(dirname string) error {
+  if err := env.check(faultSync); err != nil {
+    return err
+  }
+  if err := env.Env.SyncDir(dirname); err != nil {
+    return err
+  }
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  delete(env.newFiles, filepath.Clean(dirname))
+  return nil
+}
+
+// Simulate a power loss followed by a reboot: delete the files whose
+// directory entries were not synced, truncate the others to their synced
+// size, then clear every fault.
+func (env *faultInjectionEnv) resetToSyncedState() error {
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  for dir, names := range(env.newFiles) {
+    for name := range(names) {
+      filename := filepath.Join(dir, name)
+      if err := env.Env.DeleteFile(filename); err != nil && !errors.Is(err, ErrNotFound) {
+        return err
+      }
+      delete(env.files, filename)
+    }
+  }
+  for filename, state := range(env.files) {
+    if state.pos == state.syncedPos {
+      continue
+    }
+    data, err := readFileToString(env.Env, filename)
+    if err != nil {
+      return err
+    }
+    if int64(len(data)) > state.syncedPos {
+      data = data[:state.syncedPos]
+    }
+    if err := writeStringToFile(env.Env, data, filename, true); err != nil {
+      return err
+    }
+  }
+  env.files = make(map[string]*faultFileState)
+  env.newFiles = make(map[string]map[string]bool)
+  env.active = true
+  env.writesUntilCrash = -1
+  env.errorProbability = [numFaultOps]float64{}
+  return nil
+}
+
+type faultWritableFile struct {
+  WritableFile
+  env *faultInjectionEnv
+  state *faultFileState
+}
+
+func (f *faultWritableFile) Write(b []byte) (int, error) {
+  if err := f.env.check(faultWrite); err != nil {
+    return 0, err
+  }
+  n, err := f.WritableFile.Write(b)
+  f.env.mu.Lock()
+  f.state.pos += int64(n)
+  f.env.mu.Unlock()
+  return n, err
+}
+
+func (f *faultWritableFile) Sync() error {
+  if err := f.env.check(faultSync); err != nil {
+    return err
+  }
+  if err := f.WritableFile.Sync(); err != nil {
+    return err
+  }
+  f.env.mu.Lock()
+  f.state.syncedPos = f.state.pos
+  f.env.mu.Unlock()
+  return nil
+}
+
+type faultRandomAccessFile struct {
+  RandomAccessFile
+  env *faultInjectionEnv
+}
+
+func (f *faultRandomAccessFile) ReadAt(b []byte, off int64) (int, error) {
+  if err := f.env.check(faultReadAt); err != nil {
+    return 0, err
+  }
+  return f.RandomAccessFile.ReadAt(b, off)
+}
+
+// A database on a faultInjectionEnv, and the writes it acknowledged as
+// synced.
+type faultInjectionTest struct {
+  t *testing.T
+  env *faultInjectionEnv
+  dbname string
+  options *Options
+  db *DB
+  synced map[string]string
+  next int  // Number of the next key to write
+}
+
+func newFaultInjectionTest(t *testing.T) *faultInjectionTest {
+  test := &faultInjectionTest{t:t}
+  test.env = newFaultInjectionEnv(NewMemEnv())
+  test.dbname = "/fault/db"
+  test.options = testDBOptions()
+  test.options.Env = test.env
+  // Small memtables and tables, to crash during flushes and compactions too.
+  test.options.WriteBufferSize = 8 << 10
+  test.options.MaxFileSize = 16 << 10
+  test.synced = make(map[string]string)
+  test.open()
+  return test
+}
+
+func faultInjectionKey(i int) string {
+  return fmt.Sprintf("key%06d", i)
+}
+
+func faultInjectionValue(i int) string {
+  return fmt.Sprint(faultInjectionKey(i), "-", string(make([]byte, 100 + i % 50)))
+}
+
+func (test *faultInjectionTest) open() {
+  db, err := Open(test.dbname, test.options)
+  if err != nil {
+    test.t.Fatal("Cannot open database: ", err)
+  }
+  test.db = db
+}
+
+// Write n keys, stopping at the first failure. Return the number of writes
+// that succeeded.
+func (test *faultInjectionTest) write(n int, sync bool) int {
+  for i := 0; i < n; i++ {
+    key, value := faultInjectionKey(test.next), faultInjectionValue(test.next)
+    if err := test.db.Put(&WriteOptions{Sync:sync}, []byte(key), []byte(value)); err != nil {
+      return i
+    }
+    test.next++
+    if sync {
+      test.synced[key] = value
+    }
+  }
+  return n
+}
+
+// Lose power, then reopen the database and check that no synced write was
+// lost and that every key it holds has the value that was written.
+func (test *faultInjectionTest) crashAndVerify() {
+  test.env.crash()
+  test.db.Close()
+  if err := test.env.resetToSyncedState(); err != nil {
+    test.t.Fatal("Cannot reset file system: ", err)
+  }
+  test.open()
+
+  for key, value := range(test.synced) {
+    v, err := test.db.Get(nil, []byte(key))
+    if err != nil || string(v) != value {
+      test.t.Fatal("Synced write lost: ", key, " ", err)
+    }
+  }
+  iter, err := test.db.NewIterator(nil)
+  if err != nil {
+    test.t.Fatal("Cannot create iterator: ", err)
+  }
+  defer iter.Close()
+  for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+    var i int
+    if _, err := fmt.Sscanf(string(iter.Key()), "key%06d", &i); err != nil || string(iter.Value()) != faultInjectionValue(i) {
+      test.t.Fatal("Unexpected entry: ", string(iter.Key()))
+    }
+  }
+  if err := iter.Error(); err != nil {
+    test.t.Fatal("Iteration failed: ", err)
+  }
+}
+
+func TestFaultInjectionDropUnsyncedData(t *testing.T) {
+  test := newFaultInjectionTest(t)
+  for round := 0; round < 10; round++ {
+    test.write(200, true)
+    test.write(200, false)
+    test.crashAndVerify()
+  }
+  test.db.Close()
+}
+
+func TestFaultInjectionCrashPoints(t *testing.T) {
+  test := newFaultInjectionTest(t)
+  // Crash after every number of file system writes up to a bound, which
+  // covers crashes in log writes, flushes, compactions and descriptor
+  // updates.
+  for n := 0; n < 300; n += 7 {
+    test.env.crashAfter(n)
+    test.write(1000, true)
+    test.crashAndVerify()
+  }
+  test.db.Close()
+}
+
+func TestFaultInjectionRandomErrors(t *testing.T) {
+  test := newFaultInjectionTest(t)
+  for round := 0; round < 20; round++ {
+    test.env.setErrorProbability(faultWrite, 0.005)
+    test.env.setErrorProbability(faultSync, 0.005)
+    test.env.setErrorProbability(faultRename, 0.1)
+    test.write(500, round % 2 == 0)
+    test.crashAndVerify()
+  }
+  test.db.Close()
+}
+
+func TestFaultInjectionReadErrors(t *testing.T) {
+  test := newFaultInjectionTest(t)
+  test.write(2000, true)
+  if err := test.db.CompactRange(nil, nil); err != nil {
+    t.Fatal("Compaction failed: ", err)
+  }
+
+  // A failed read is reported as an error, never as a missing key or a
+  // wrong value.
+  test.env.setErrorProbability(faultReadAt, 0.2)
+  failures := 0
+  for i := 0; i < test.next; i++ {
+    v, err := test.db.Get(&ReadOptions{}, []byte(faultInjectionKey(i)))
+    if err != nil {
+      if !errors.Is(err, ErrIOError) {
+        t.Fatal("Unexpected error: ", err)
+      }
+      failures++
+    } else if string(v) != faultInjectionValue(i) {
+      t.Fatal("Unexpected value for key ", i)
+    }
+  }
+  if failures == 0 {
+    t.Error("Expected some reads to fail.")
+  }
+  test.env.setErrorProbability(faultReadAt, 0)
+  test.db.Close()
+}
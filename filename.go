@@ -0,0 +1,117 @@
+package leveldb
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+type FileType byte
+const (
+  LogFile FileType = 0x0
+  TableFile FileType = 0x1
+  DescriptorFile FileType = 0x2
+  CurrentFile FileType = 0x3
+  TempFile FileType = 0x4
+  DBLockFile FileType = 0x5
+)
+
+func makeFileName(dbname string, number uint64, suffix string) string {
+  return fmt.Sprintf("%s/%06d.%s", dbname, number, suffix)
+}
+
+// Name of the write-ahead log file with the given number.
+func LogFileName(dbname string, number uint64) string {
+  return makeFileName(dbname, number, "log")
+}
+
+// Name of the sstable file with the given number.
+func TableFileName(dbname string, number uint64) string {
+  return makeFileName(dbname, number, "ldb")
+}
+
+// Name of the sstable file with the given number, using the older ".sst"
+// suffix.
+func SSTTableFileName(dbname string, number uint64) string {
+  return makeFileName(dbname, number, "sst")
+}
+
+// Name of the descriptor file with the given number.
+func DescriptorFileName(dbname string, number uint64) string {
+  return fmt.Sprintf("%s/MANIFEST-%06d", dbname, number)
+}
+
+// Name of the file that points to the current descriptor.
+func CurrentFileName(dbname string) string {
+  return dbname + "/CURRENT"
+}
+
+// Name of the file locked by the process that has the database open.
+func LockFileName(dbname string) string {
+  return dbname + "/LOCK"
+}
+
+// Name of a temporary file that is about to be renamed.
+func TempFileName(dbname string, number uint64) string {
+  return makeFileName(dbname, number, "dbtmp")
+}
+
+// Parse a file name inside the database directory into its number and type.
+// Owned filenames have the form:
+//    dbname/CURRENT
+//    dbname/LOCK
+//    dbname/MANIFEST-[0-9]+
+//    dbname/[0-9]+.(log|ldb|sst|dbtmp)
+func ParseFileName(filename string) (uint64, FileType, bool) {
+  if filename == "CURRENT" {
+    return 0, CurrentFile, true
+  }
+  if filename == "LOCK" {
+    return 0, DBLockFile, true
+  }
+  if strings.HasPrefix(filename, "MANIFEST-") {
+    number, err := strconv.ParseUint(filename[len("MANIFEST-"):], 10, 64)
+    if err != nil {
+      return 0, 0, false
+    }
+    return number, DescriptorFile, true
+  }
+
+  dot := strings.IndexByte(filename, '.')
+  if dot <= 0 {
+    return 0, 0, false
+  }
+  number, err := strconv.ParseUint(filename[:dot], 10, 64)
+  if err != nil {
+    return 0, 0, false
+  }
+
+  switch filename[dot + 1:] {
+  case "log":
+    return number, LogFile, true
+  case "ldb", "sst":
+    return number, TableFile, true
+  case "dbtmp":
+    return number, TempFile, true
+  }
+  return 0, 0, false
+}
+
+// Make the CURRENT file point to the descriptor file with the given number.
+func setCurrentFile(env Env, dbname string, descriptorNumber uint64) error {
+  // Remove leading "dbname/" and add newline to manifest file name
+  manifest := DescriptorFileName(dbname, descriptorNumber)[len(dbname) + 1:]
+  tmp := TempFileName(dbname, descriptorNumber)
+  err := writeStringToFile(env, []byte(manifest + "\n"), tmp, true)
+  if err == nil {
+    err = env.RenameFile(tmp, CurrentFileName(dbname))
+  }
+  if err == nil {
+    // Make the rename durable.
+    err = env.SyncDir(dbname)
+  }
+  if err != nil {
+    env.DeleteFile(tmp)
+  }
+  return err
+}
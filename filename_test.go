@@ -0,0 +1,57 @@
+package leveldb
+
+import (
+  "testing"
+)
+
+func TestParseFileName(t *testing.T) {
+  cases := []struct {
+    filename string
+    number uint64
+    fileType FileType
+  }{
+    {"100.log", 100, LogFile},
+    {"0.log", 0, LogFile},
+    {"0.sst", 0, TableFile},
+    {"0.ldb", 0, TableFile},
+    {"CURRENT", 0, CurrentFile},
+    {"LOCK", 0, DBLockFile},
+    {"MANIFEST-2", 2, DescriptorFile},
+    {"MANIFEST-7", 7, DescriptorFile},
+    {"100.dbtmp", 100, TempFile},
+    {"18446744073709551615.log", 18446744073709551615, LogFile},
+  }
+  for _, c := range(cases) {
+    number, fileType, ok := ParseFileName(c.filename)
+    if !ok || number != c.number || fileType != c.fileType {
+      t.Error("Cannot parse file name: ", c.filename)
+    }
+  }
+
+  errors := []string{"", "foo", "foo-dx-100.log", ".log", "100", "100.", "100.lop",
+      "18446744073709551616.log", "184467440737095516150.log", "CURRENTX", "LOCKX", "MANIFEST",
+      "MANIFEST-", "MANIFEST-XYZ", "MANIFEST-3x", "XMANIFEST-3"}
+  for _, filename := range(errors) {
+    if _, _, ok := ParseFileName(filename); ok {
+      t.Error("Should not parse file name: ", filename)
+    }
+  }
+}
+
+func TestConstructFileName(t *testing.T) {
+  if LogFileName("foo", 192) != "foo/000192.log" {
+    t.Error("Unexpected log file name: ", LogFileName("foo", 192))
+  }
+  if TableFileName("bar", 200) != "bar/000200.ldb" {
+    t.Error("Unexpected table file name: ", TableFileName("bar", 200))
+  }
+  if DescriptorFileName("bar", 100) != "bar/MANIFEST-000100" {
+    t.Error("Unexpected descriptor file name: ", DescriptorFileName("bar", 100))
+  }
+  if CurrentFileName("foo") != "foo/CURRENT" {
+    t.Error("Unexpected current file name: ", CurrentFileName("foo"))
+  }
+  if TempFileName("tmp", 999) != "tmp/000999.dbtmp" {
+    t.Error("Unexpected temp file name: ", TempFileName("tmp", 999))
+  }
+}
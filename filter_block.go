@@ -69,7 +69,9 @@ func (b *FilterBlockBuilder) Finish() []byte {
 func (b *FilterBlockBuilder) generateFilter() {
   numKeys := len(b.start)
   if numKeys == 0 {
-    b.filterOffsets = append(b.filterOffsets, 0)
+    // Fast path if there are no keys for this filter
+    b.filterOffsets = append(b.filterOffsets, uint32(len(b.result)))
+    return
   }
 
   b.start = append(b.start, len(b.keys))
@@ -123,8 +125,9 @@ func (r *FilterBlockReader) MayContain(blockOffset uint64, key []byte) bool {
   if start <= limit && int(limit) <= r.offset {
     return r.policy.MayContain(r.data[start:limit], key)
   } else if start == limit {
+    // Empty filters do not match any keys
     return false
   }
 
-  return false
+  return true  // Errors are treated as potential matches
 }
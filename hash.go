@@ -18,11 +18,14 @@ func Hash(data []byte, seed uint32) uint32 {
     h ^= (h >> 16)
   }
 
+  // Pick up remaining bytes
   switch len(data) - i {
   case 3:
     h += uint32(data[i+2]) << 16
+    fallthrough
   case 2:
     h += uint32(data[i+1]) << 8
+    fallthrough
   case 1:
     h += uint32(data[i])
     h *= m
@@ -0,0 +1,22 @@
+package leveldb
+
+import (
+  "testing"
+)
+
+func TestHashSignedUnsignedIssue(t *testing.T) {
+  for _, c := range([]struct{
+    data []byte
+    expected uint32
+  }{
+    {[]byte{}, 0xbc9f1d34},
+    {[]byte{0x62}, 0xef1345c4},
+    {[]byte{0xc3, 0x97}, 0x5b663814},
+    {[]byte{0xe2, 0x99, 0xa5}, 0x323c078f},
+    {[]byte{0xe1, 0x80, 0xb9, 0x32}, 0xed21633a},
+  }) {
+    if h := Hash(c.data, 0xbc9f1d34); h != c.expected {
+      t.Errorf("Hash(%x) = %#x, expected %#x", c.data, h, c.expected)
+    }
+  }
+}
@@ -13,4 +13,37 @@ type Iterator interface {
   Prev()
   Key() []byte
   Value() []byte
+
+  // Return the error encountered while iterating, e.g. on corrupted data,
+  // if any. An iterator that hit an error becomes invalid.
+  Error() error
+
+  // Release the resources pinned by the iterator, such as cache handles
+  // and table references, and return the error encountered, if any. The
+  // iterator must not be used afterwards.
+  Close() error
+}
+
+// An iterator that runs cleanup once it is closed.
+type cleanupIterator struct {
+  Iterator
+  cleanup func()
+  closed bool
+}
+
+func newCleanupIterator(iter Iterator, cleanup func()) Iterator {
+  return &cleanupIterator{Iterator:iter, cleanup:cleanup}
+}
+
+func (iter *cleanupIterator) Close() error {
+  if iter.closed {
+    return nil
+  }
+  iter.closed = true
+  err := iter.Iterator.Close()
+  if iter.cleanup != nil {
+    iter.cleanup()
+    iter.cleanup = nil
+  }
+  return err
 }
@@ -1,6 +1,7 @@
 package log
 
 import (
+  "github.com/chenlanbo/leveldb/crc32c"
 )
 
 type RecordType byte
@@ -10,9 +11,18 @@ const (
   FirstType RecordType = 0x2
   MiddleType RecordType = 0x3
   LastType RecordType = 0x4
+
+  MaxRecordType = LastType
 )
 
 const (
   BlockSize = 32768
   HeaderSize = 7  // checksum (4 bytes), length (2 bytes), type (1 byte).
 )
+
+// Checksum of the record type followed by the payload, masked as it is
+// stored in the record header.
+func recordChecksum(t RecordType, data []byte) uint32 {
+  checksum := crc32c.Value([]byte{byte(t)})
+  return crc32c.Mask(crc32c.Extend(checksum, data))  // Adjust for storage
+}
@@ -0,0 +1,305 @@
+package log
+
+import (
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+)
+
+// Source of the log records, satisfied by leveldb.SequentialFile.
+type SequentialFile interface {
+  Read([]byte) (int, error)
+  Skip(int64) error
+}
+
+// Reporter is notified when the reader drops bytes because of corruption.
+type Reporter interface {
+  // Some corruption was detected. bytes is the approximate number of bytes
+  // dropped due to the corruption.
+  Corruption(bytes int, reason error)
+}
+
+// Extend record types with the following special values.
+const (
+  // Returned whenever we reach the end of the input.
+  eofType RecordType = MaxRecordType + 1
+  // Returned whenever we find an invalid physical record. Currently there
+  // are three situations in which this happens:
+  // * The record has an invalid CRC.
+  // * The record is a 0-length record.
+  // * The record is below the reader's initial offset.
+  badRecordType RecordType = MaxRecordType + 2
+)
+
+type LogReader struct {
+  file SequentialFile
+  reporter Reporter
+  checksum bool
+  backingStore []byte
+  buffer []byte
+  eof bool  // Last Read() indicated EOF by returning < BlockSize
+
+  // Offset of the last record returned by ReadRecord.
+  lastRecordOffset uint64
+  // Offset of the first location past the end of buffer.
+  endOfBufferOffset uint64
+  // Offset at which to start looking for the first record to return.
+  initialOffset uint64
+  // True if we are resynchronizing after a seek (initialOffset > 0). In
+  // particular, a run of MiddleType and LastType records can be silently
+  // skipped in this mode.
+  resyncing bool
+  // Report a record left incomplete at the end of the file as a corruption
+  // instead of treating it as a clean EOF.
+  reportEOFInconsistency bool
+  scratch []byte
+}
+
+// Create a reader that will return log records from file. If reporter is
+// non-nil, it is notified whenever some data is dropped due to a detected
+// corruption. If checksum is true, verify checksums if available. The reader
+// will start reading at the first record located at physical position >=
+// initialOffset within the file.
+func NewLogReader(file SequentialFile, reporter Reporter, checksum bool, initialOffset uint64) *LogReader {
+  reader := &LogReader{}
+  reader.file = file
+  reader.reporter = reporter
+  reader.checksum = checksum
+  reader.backingStore = make([]byte, BlockSize)
+  reader.buffer = reader.backingStore[:0]
+  reader.eof = false
+  reader.lastRecordOffset = 0
+  reader.endOfBufferOffset = 0
+  reader.initialOffset = initialOffset
+  reader.resyncing = initialOffset > 0
+  return reader
+}
+
+// Read the next record. Returns false when the end of the input is reached.
+// The returned slice is only valid until the next call to ReadRecord.
+func (reader *LogReader) ReadRecord() ([]byte, bool) {
+  if reader.lastRecordOffset < reader.initialOffset {
+    if !reader.skipToInitialBlock() {
+      return nil, false
+    }
+  }
+
+  reader.scratch = reader.scratch[:0]
+  inFragmentedRecord := false
+  // Record offset of the logical record that we're reading.
+  var prospectiveRecordOffset uint64 = 0
+
+  for {
+    recordType, fragment := reader.readPhysicalRecord()
+
+    // readPhysicalRecord may have only had an empty trailer remaining in its
+    // internal buffer. Calculate the offset of the next physical record now
+    // that it has returned, properly accounting for its header size.
+    physicalRecordOffset := reader.endOfBufferOffset - uint64(len(reader.buffer)) - HeaderSize - uint64(len(fragment))
+
+    if reader.resyncing {
+      if recordType == MiddleType {
+        continue
+      } else if recordType == LastType {
+        reader.resyncing = false
+        continue
+      } else {
+        reader.resyncing = false
+      }
+    }
+
+    switch recordType {
+    case FullType:
+      if inFragmentedRecord && len(reader.scratch) > 0 {
+        reader.reportCorruption(len(reader.scratch), "Partial record without end(1).")
+      }
+      prospectiveRecordOffset = physicalRecordOffset
+      reader.scratch = reader.scratch[:0]
+      reader.lastRecordOffset = prospectiveRecordOffset
+      return fragment, true
+
+    case FirstType:
+      if inFragmentedRecord && len(reader.scratch) > 0 {
+        reader.reportCorruption(len(reader.scratch), "Partial record without end(2).")
+      }
+      prospectiveRecordOffset = physicalRecordOffset
+      reader.scratch = append(reader.scratch[:0], fragment...)
+      inFragmentedRecord = true
+
+    case MiddleType:
+      if !inFragmentedRecord {
+        reader.reportCorruption(len(fragment), "Missing start of fragmented record(1).")
+      } else {
+        reader.scratch = append(reader.scratch, fragment...)
+      }
+
+    case LastType:
+      if !inFragmentedRecord {
+        reader.reportCorruption(len(fragment), "Missing start of fragmented record(2).")
+      } else {
+        reader.scratch = append(reader.scratch, fragment...)
+        reader.lastRecordOffset = prospectiveRecordOffset
+        return reader.scratch, true
+      }
+
+    case eofType:
+      // This can be caused by the writer dying immediately after writing a
+      // physical record but before completing the next; don't treat it as a
+      // corruption, just ignore the entire logical record.
+      if inFragmentedRecord && reader.reportEOFInconsistency {
+        reader.reportCorruption(len(reader.scratch), "Partial record without end(3).")
+      }
+      reader.scratch = reader.scratch[:0]
+      return nil, false
+
+    case badRecordType:
+      if inFragmentedRecord {
+        reader.reportCorruption(len(reader.scratch), "Error in middle of record.")
+        inFragmentedRecord = false
+        reader.scratch = reader.scratch[:0]
+      }
+
+    default:
+      dropped := len(fragment)
+      if inFragmentedRecord {
+        dropped += len(reader.scratch)
+      }
+      reader.reportCorruption(dropped, fmt.Sprint("Unknown record type ", recordType, "."))
+      inFragmentedRecord = false
+      reader.scratch = reader.scratch[:0]
+    }
+  }
+}
+
+// If report is true, a truncated record at the end of the input, which is
+// what a writer crashing mid-record leaves behind, is reported to the
+// Reporter. By default it is silently treated as the end of the log.
+func (reader *LogReader) SetReportEOFInconsistency(report bool) {
+  reader.reportEOFInconsistency = report
+}
+
+// Physical offset of the last record returned by ReadRecord.
+func (reader *LogReader) LastRecordOffset() uint64 {
+  return reader.lastRecordOffset
+}
+
+// Skip all possible blocks that do not contain the initial offset.
+func (reader *LogReader) skipToInitialBlock() bool {
+  offsetInBlock := reader.initialOffset % BlockSize
+  blockStartLocation := reader.initialOffset - offsetInBlock
+
+  // Don't search a block if we'd be in the trailer.
+  if offsetInBlock > BlockSize - 6 {
+    blockStartLocation += BlockSize
+  }
+
+  reader.endOfBufferOffset = blockStartLocation
+
+  // Skip to start of first block that can contain the initial record.
+  if blockStartLocation > 0 {
+    if err := reader.file.Skip(int64(blockStartLocation)); err != nil {
+      reader.reportDrop(int(blockStartLocation), err)
+      return false
+    }
+  }
+  return true
+}
+
+func (reader *LogReader) readPhysicalRecord() (RecordType, []byte) {
+  for {
+    if len(reader.buffer) < HeaderSize {
+      if !reader.eof {
+        // Last read was a full read, so this is a trailer to skip.
+        n, err := io.ReadFull(reader.file, reader.backingStore)
+        reader.buffer = reader.backingStore[:n]
+        reader.endOfBufferOffset += uint64(n)
+        if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+          reader.buffer = reader.backingStore[:0]
+          reader.reportDrop(BlockSize, err)
+          reader.eof = true
+          return eofType, nil
+        } else if n < BlockSize {
+          reader.eof = true
+        }
+        continue
+      } else {
+        // Note that if buffer is non-empty, we have a truncated header at the
+        // end of the file, which can be caused by the writer crashing in the
+        // middle of writing the header. Instead of considering this an error,
+        // just report EOF.
+        if len(reader.buffer) > 0 && reader.reportEOFInconsistency {
+          dropSize := len(reader.buffer)
+          reader.buffer = reader.buffer[:0]
+          reader.reportCorruption(dropSize, "Truncated header.")
+        }
+        reader.buffer = reader.buffer[:0]
+        return eofType, nil
+      }
+    }
+
+    // Parse the header.
+    header := reader.buffer[:HeaderSize]
+    length := int(header[4]) | int(header[5]) << 8
+    recordType := RecordType(header[6])
+    if HeaderSize + length > len(reader.buffer) {
+      dropSize := len(reader.buffer)
+      reader.buffer = reader.buffer[:0]
+      if !reader.eof {
+        reader.reportCorruption(dropSize, "Bad record length.")
+        return badRecordType, nil
+      }
+      // If the end of the file has been reached without reading length bytes
+      // of payload, assume the writer died in the middle of writing the
+      // record. Don't report a corruption.
+      if reader.reportEOFInconsistency {
+        reader.reportCorruption(dropSize, "Truncated record body.")
+      }
+      return eofType, nil
+    }
+
+    if recordType == ZeroType && length == 0 {
+      // Skip zero length record without reporting any drops since such
+      // records are produced by the mmap based writing code that
+      // preallocates file regions.
+      reader.buffer = reader.buffer[:0]
+      return badRecordType, nil
+    }
+
+    // Check crc.
+    if reader.checksum {
+      expected := binary.LittleEndian.Uint32(header[:4])
+      actual := recordChecksum(recordType, reader.buffer[HeaderSize:HeaderSize + length])
+      if actual != expected {
+        // Drop the rest of the buffer since "length" itself may have been
+        // corrupted and if we trust it, we could find some fragment of a real
+        // log record that just happens to look like a valid log record.
+        dropSize := len(reader.buffer)
+        reader.buffer = reader.buffer[:0]
+        reader.reportCorruption(dropSize, "Checksum mismatch.")
+        return badRecordType, nil
+      }
+    }
+
+    fragment := reader.buffer[HeaderSize:HeaderSize + length]
+    reader.buffer = reader.buffer[HeaderSize + length:]
+
+    // Skip physical record that started before initialOffset.
+    if reader.endOfBufferOffset - uint64(len(reader.buffer)) - HeaderSize - uint64(length) < reader.initialOffset {
+      return badRecordType, nil
+    }
+
+    return recordType, fragment
+  }
+}
+
+func (reader *LogReader) reportCorruption(bytes int, reason string) {
+  reader.reportDrop(bytes, errors.New(reason))
+}
+
+func (reader *LogReader) reportDrop(bytes int, reason error) {
+  if reader.reporter != nil &&
+      reader.endOfBufferOffset - uint64(len(reader.buffer)) - uint64(bytes) >= reader.initialOffset {
+    reader.reporter.Corruption(bytes, reason)
+  }
+}
@@ -0,0 +1,602 @@
+package log
+
+import (
+  "bytes"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "math/rand"
+  "os"
+  "strings"
+  "testing"
+)
+
+// Construct a string of the specified length made out of the supplied
+// partial string.
+func bigString(partial string, n int) string {
+  return strings.Repeat(partial, n / len(partial) + 1)[:n]
+}
+
+// Construct a string from a number.
+func numberString(n int) string {
+  return fmt.Sprint(n, ".")
+}
+
+// Return a skewed potentially long string.
+func randomSkewedString(i int, rnd *rand.Rand) string {
+  return bigString(numberString(i), rnd.Intn(1 << uint(rnd.Intn(18))))
+}
+
+type stringDest struct {
+  contents []byte
+}
+
+func (dest *stringDest) Write(b []byte) (int, error) {
+  dest.contents = append(dest.contents, b...)
+  return len(b), nil
+}
+
+func (dest *stringDest) Sync() error {
+  return nil
+}
+
+type stringSource struct {
+  contents []byte
+  forceError bool
+}
+
+func (source *stringSource) Read(b []byte) (int, error) {
+  if source.forceError {
+    source.forceError = false
+    return 0, errors.New("read error")
+  }
+  if len(source.contents) == 0 {
+    return 0, io.EOF
+  }
+  n := copy(b, source.contents)
+  source.contents = source.contents[n:]
+  return n, nil
+}
+
+func (source *stringSource) Skip(n int64) error {
+  if n > int64(len(source.contents)) {
+    source.contents = nil
+    return errors.New("in-memory file skipped past end")
+  }
+  source.contents = source.contents[n:]
+  return nil
+}
+
+type reportCollector struct {
+  droppedBytes int
+  message string
+}
+
+func (report *reportCollector) Corruption(bytes int, reason error) {
+  report.droppedBytes += bytes
+  report.message += reason.Error()
+}
+
+// Record sizes and offsets used by the initial offset tests.
+var initialOffsetRecordSizes = []int{
+  10000,  // Two sizable records in first block
+  10000,
+  2 * BlockSize - 1000,  // Span three blocks
+  1,
+  13716,  // Consume all but two bytes of block 3.
+  BlockSize - HeaderSize,  // Consume the entirety of block 4.
+}
+
+var initialOffsetLastRecordOffsets = []uint64{
+  0,
+  HeaderSize + 10000,
+  2 * (HeaderSize + 10000),
+  2 * (HeaderSize + 10000) + (2 * BlockSize - 1000) + 3 * HeaderSize,
+  2 * (HeaderSize + 10000) + (2 * BlockSize - 1000) + 3 * HeaderSize + HeaderSize + 1,
+  3 * BlockSize,
+}
+
+type logTest struct {
+  t *testing.T
+  dest stringDest
+  source stringSource
+  report reportCollector
+  reading bool
+  writer *LogWriter
+  reader *LogReader
+}
+
+func newLogTest(t *testing.T) *logTest {
+  lt := &logTest{t:t}
+  lt.writer = NewLogWriter(&lt.dest, 0)
+  lt.reader = NewLogReader(&lt.source, &lt.report, true, 0)
+  return lt
+}
+
+func (lt *logTest) reopenForAppend() {
+  lt.writer = NewLogWriter(&lt.dest, uint64(len(lt.dest.contents)))
+}
+
+func (lt *logTest) write(msg string) {
+  if lt.reading {
+    lt.t.Fatal("Write() after starting to read.")
+  }
+  lt.writer.AddRecord([]byte(msg))
+}
+
+func (lt *logTest) writtenBytes() int {
+  return len(lt.dest.contents)
+}
+
+func (lt *logTest) read() string {
+  if !lt.reading {
+    lt.reading = true
+    lt.source.contents = append([]byte(nil), lt.dest.contents...)
+  }
+  record, ok := lt.reader.ReadRecord()
+  if !ok {
+    return "EOF"
+  }
+  return string(record)
+}
+
+func (lt *logTest) incrementByte(offset int, delta int) {
+  lt.dest.contents[offset] += byte(delta)
+}
+
+func (lt *logTest) setByte(offset int, b byte) {
+  lt.dest.contents[offset] = b
+}
+
+func (lt *logTest) shrinkSize(bytes int) {
+  lt.dest.contents = lt.dest.contents[:len(lt.dest.contents) - bytes]
+}
+
+func (lt *logTest) fixChecksum(headerOffset int, length int) {
+  // Compute crc of type/len/data.
+  t := RecordType(lt.dest.contents[headerOffset + 6])
+  data := lt.dest.contents[headerOffset + HeaderSize:headerOffset + HeaderSize + length]
+  binary.LittleEndian.PutUint32(lt.dest.contents[headerOffset:], recordChecksum(t, data))
+}
+
+func (lt *logTest) forceError() {
+  lt.source.forceError = true
+}
+
+func (lt *logTest) matchError(msg string) bool {
+  return strings.Contains(lt.report.message, msg)
+}
+
+func (lt *logTest) writeInitialOffsetLog() {
+  for i := range(initialOffsetRecordSizes) {
+    lt.write(strings.Repeat(string(rune('a' + i)), initialOffsetRecordSizes[i]))
+  }
+}
+
+func (lt *logTest) checkOffsetPastEndReturnsNoRecords(offsetPastEnd uint64) {
+  lt.writeInitialOffsetLog()
+  lt.reading = true
+  lt.source.contents = append([]byte(nil), lt.dest.contents...)
+  reader := NewLogReader(&lt.source, &lt.report, true, uint64(lt.writtenBytes()) + offsetPastEnd)
+  if _, ok := reader.ReadRecord(); ok {
+    lt.t.Error("Reading past the end should return no records.")
+  }
+}
+
+func (lt *logTest) checkInitialOffsetRecord(initialOffset uint64, expectedRecordOffset int) {
+  lt.writeInitialOffsetLog()
+  lt.reading = true
+  lt.source.contents = append([]byte(nil), lt.dest.contents...)
+  reader := NewLogReader(&lt.source, &lt.report, true, initialOffset)
+
+  // Read all records from expectedRecordOffset through the last one.
+  for ; expectedRecordOffset < len(initialOffsetRecordSizes); expectedRecordOffset++ {
+    record, ok := reader.ReadRecord()
+    if !ok {
+      lt.t.Fatal("Missing record ", expectedRecordOffset)
+    }
+    if len(record) != initialOffsetRecordSizes[expectedRecordOffset] {
+      lt.t.Error("Unexpected record size: ", len(record))
+    }
+    if reader.LastRecordOffset() != initialOffsetLastRecordOffsets[expectedRecordOffset] {
+      lt.t.Error("Unexpected record offset: ", reader.LastRecordOffset())
+    }
+    if record[0] != byte('a' + expectedRecordOffset) {
+      lt.t.Error("Unexpected record contents.")
+    }
+  }
+}
+
+func (lt *logTest) expect(got, expected string) {
+  if got != expected {
+    lt.t.Errorf("Expected %.32q, got %.32q", expected, got)
+  }
+}
+
+func TestLogEmpty(t *testing.T) {
+  lt := newLogTest(t)
+  lt.expect(lt.read(), "EOF")
+}
+
+func TestLogReadWrite(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("foo")
+  lt.write("bar")
+  lt.write("")
+  lt.write("xxxx")
+  lt.expect(lt.read(), "foo")
+  lt.expect(lt.read(), "bar")
+  lt.expect(lt.read(), "")
+  lt.expect(lt.read(), "xxxx")
+  lt.expect(lt.read(), "EOF")
+  lt.expect(lt.read(), "EOF")  // Make sure reads at eof work
+}
+
+func TestLogManyBlocks(t *testing.T) {
+  lt := newLogTest(t)
+  for i := 0; i < 100000; i++ {
+    lt.write(numberString(i))
+  }
+  for i := 0; i < 100000; i++ {
+    lt.expect(lt.read(), numberString(i))
+  }
+  lt.expect(lt.read(), "EOF")
+}
+
+func TestLogFragmentation(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("small")
+  lt.write(bigString("medium", 50000))
+  lt.write(bigString("large", 100000))
+  lt.expect(lt.read(), "small")
+  lt.expect(lt.read(), bigString("medium", 50000))
+  lt.expect(lt.read(), bigString("large", 100000))
+  lt.expect(lt.read(), "EOF")
+}
+
+func TestLogMarginalTrailer(t *testing.T) {
+  // Make a trailer that is exactly the same length as an empty record.
+  lt := newLogTest(t)
+  n := BlockSize - 2 * HeaderSize
+  lt.write(bigString("foo", n))
+  if lt.writtenBytes() != BlockSize - HeaderSize {
+    t.Error("Unexpected written bytes: ", lt.writtenBytes())
+  }
+  lt.write("")
+  lt.write("bar")
+  lt.expect(lt.read(), bigString("foo", n))
+  lt.expect(lt.read(), "")
+  lt.expect(lt.read(), "bar")
+  lt.expect(lt.read(), "EOF")
+}
+
+func TestLogShortTrailer(t *testing.T) {
+  lt := newLogTest(t)
+  n := BlockSize - 2 * HeaderSize + 4
+  lt.write(bigString("foo", n))
+  if lt.writtenBytes() != BlockSize - HeaderSize + 4 {
+    t.Error("Unexpected written bytes: ", lt.writtenBytes())
+  }
+  lt.write("")
+  lt.write("bar")
+  lt.expect(lt.read(), bigString("foo", n))
+  lt.expect(lt.read(), "")
+  lt.expect(lt.read(), "bar")
+  lt.expect(lt.read(), "EOF")
+}
+
+func TestLogAlignedEof(t *testing.T) {
+  lt := newLogTest(t)
+  n := BlockSize - 2 * HeaderSize + 4
+  lt.write(bigString("foo", n))
+  lt.expect(lt.read(), bigString("foo", n))
+  lt.expect(lt.read(), "EOF")
+}
+
+func TestLogOpenForAppend(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("hello")
+  lt.reopenForAppend()
+  lt.write("world")
+  lt.expect(lt.read(), "hello")
+  lt.expect(lt.read(), "world")
+  lt.expect(lt.read(), "EOF")
+}
+
+func TestLogRandomRead(t *testing.T) {
+  lt := newLogTest(t)
+  n := 500
+  writeRnd := rand.New(rand.NewSource(301))
+  for i := 0; i < n; i++ {
+    lt.write(randomSkewedString(i, writeRnd))
+  }
+  readRnd := rand.New(rand.NewSource(301))
+  for i := 0; i < n; i++ {
+    lt.expect(lt.read(), randomSkewedString(i, readRnd))
+  }
+  lt.expect(lt.read(), "EOF")
+}
+
+func TestLogReadError(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("foo")
+  lt.forceError()
+  lt.expect(lt.read(), "EOF")
+  if lt.report.droppedBytes != BlockSize || !lt.matchError("read error") {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogBadRecordType(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("foo")
+  // Type is stored in header[6]
+  lt.incrementByte(6, 100)
+  lt.fixChecksum(0, 3)
+  lt.expect(lt.read(), "EOF")
+  if lt.report.droppedBytes != 3 || !lt.matchError("Unknown record type") {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogTruncatedTrailingRecordIsIgnored(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("foo")
+  lt.shrinkSize(4)  // Drop all payload as well as a header byte
+  lt.expect(lt.read(), "EOF")
+  // Truncated last record is ignored, not treated as an error.
+  if lt.report.droppedBytes != 0 || lt.report.message != "" {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogBadLength(t *testing.T) {
+  lt := newLogTest(t)
+  payloadSize := BlockSize - HeaderSize
+  lt.write(bigString("bar", payloadSize))
+  lt.write("foo")
+  // Least significant size byte is stored in header[4].
+  lt.incrementByte(4, 1)
+  lt.expect(lt.read(), "foo")
+  if lt.report.droppedBytes != BlockSize || !lt.matchError("Bad record length") {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogTruncatedTrailingRecordIsReported(t *testing.T) {
+  lt := newLogTest(t)
+  lt.reader.SetReportEOFInconsistency(true)
+  lt.write("foo")
+  lt.shrinkSize(4)  // Drop all payload as well as a header byte
+  lt.expect(lt.read(), "EOF")
+  if lt.report.droppedBytes != HeaderSize - 1 || !lt.matchError("Truncated header") {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogBadLengthAtEndIsReported(t *testing.T) {
+  lt := newLogTest(t)
+  lt.reader.SetReportEOFInconsistency(true)
+  lt.write("foo")
+  lt.shrinkSize(1)
+  lt.expect(lt.read(), "EOF")
+  if lt.report.droppedBytes != HeaderSize + 2 || !lt.matchError("Truncated record body") {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogBadLengthAtEndIsIgnored(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("foo")
+  lt.shrinkSize(1)
+  lt.expect(lt.read(), "EOF")
+  if lt.report.droppedBytes != 0 || lt.report.message != "" {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogChecksumMismatch(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("foo")
+  lt.incrementByte(0, 10)
+  lt.expect(lt.read(), "EOF")
+  if lt.report.droppedBytes != 10 || !lt.matchError("Checksum mismatch") {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogUnexpectedMiddleType(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("foo")
+  lt.setByte(6, byte(MiddleType))
+  lt.fixChecksum(0, 3)
+  lt.expect(lt.read(), "EOF")
+  if lt.report.droppedBytes != 3 || !lt.matchError("Missing start") {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogUnexpectedLastType(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("foo")
+  lt.setByte(6, byte(LastType))
+  lt.fixChecksum(0, 3)
+  lt.expect(lt.read(), "EOF")
+  if lt.report.droppedBytes != 3 || !lt.matchError("Missing start") {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogUnexpectedFullType(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("foo")
+  lt.write("bar")
+  lt.setByte(6, byte(FirstType))
+  lt.fixChecksum(0, 3)
+  lt.expect(lt.read(), "bar")
+  lt.expect(lt.read(), "EOF")
+  if lt.report.droppedBytes != 3 || !lt.matchError("Partial record without end") {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogUnexpectedFirstType(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write("foo")
+  lt.write(bigString("bar", 100000))
+  lt.setByte(6, byte(FirstType))
+  lt.fixChecksum(0, 3)
+  lt.expect(lt.read(), bigString("bar", 100000))
+  lt.expect(lt.read(), "EOF")
+  if lt.report.droppedBytes != 3 || !lt.matchError("Partial record without end") {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogMissingLastIsIgnored(t *testing.T) {
+  lt := newLogTest(t)
+  lt.write(bigString("bar", BlockSize))
+  // Remove the LAST block, including header.
+  lt.shrinkSize(14)
+  lt.expect(lt.read(), "EOF")
+  if lt.report.droppedBytes != 0 || lt.report.message != "" {
+    t.Error("Unexpected report: ", lt.report.droppedBytes, " ", lt.report.message)
+  }
+}
+
+func TestLogErrorJoinsRecords(t *testing.T) {
+  // Consider two fragmented records:
+  //    first(R1) last(R1) first(R2) last(R2)
+  // where the middle two fragments disappear. We do not want
+  // first(R1),last(R2) to get joined and returned as a valid record.
+  lt := newLogTest(t)
+
+  // Write records that span two blocks
+  lt.write(bigString("foo", BlockSize))
+  lt.write(bigString("bar", BlockSize))
+  lt.write("correct")
+
+  // Wipe the middle block
+  for offset := BlockSize; offset < 2 * BlockSize; offset++ {
+    lt.setByte(offset, 'x')
+  }
+
+  lt.expect(lt.read(), "correct")
+  lt.expect(lt.read(), "EOF")
+  dropped := lt.report.droppedBytes
+  if dropped < 2 * BlockSize || dropped > 2 * BlockSize + 100 {
+    t.Error("Unexpected dropped bytes: ", dropped)
+  }
+}
+
+func TestLogReadStart(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(0, 0)
+}
+
+func TestLogReadSecondOneOff(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(1, 1)
+}
+
+func TestLogReadSecondTenThousand(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(10000, 1)
+}
+
+func TestLogReadSecondStart(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(10007, 1)
+}
+
+func TestLogReadThirdOneOff(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(10008, 2)
+}
+
+func TestLogReadThirdStart(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(20014, 2)
+}
+
+func TestLogReadFourthOneOff(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(20015, 3)
+}
+
+func TestLogReadFourthFirstBlockTrailer(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(BlockSize - 4, 3)
+}
+
+func TestLogReadFourthMiddleBlock(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(BlockSize + 1, 3)
+}
+
+func TestLogReadFourthLastBlock(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(2 * BlockSize + 1, 3)
+}
+
+func TestLogReadFourthStart(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(
+      2 * (HeaderSize + 1000) + (2 * BlockSize - 1000) + 3 * HeaderSize, 3)
+}
+
+func TestLogReadInitialOffsetIntoBlockPadding(t *testing.T) {
+  newLogTest(t).checkInitialOffsetRecord(3 * BlockSize - 3, 5)
+}
+
+func TestLogReadEnd(t *testing.T) {
+  newLogTest(t).checkOffsetPastEndReturnsNoRecords(0)
+}
+
+func TestLogReadPastEnd(t *testing.T) {
+  newLogTest(t).checkOffsetPastEndReturnsNoRecords(5)
+}
+
+// The records of testdata/records.log, a log written in the format of C++
+// LevelDB. The first four records end 1751 bytes into the fifth block; the
+// fifth record then leaves a 4 byte trailer at the end of that block.
+func goldenRecords() [][]byte {
+  big := make([]byte, 100000)
+  for i := range(big) {
+    big[i] = byte(i % 251)
+  }
+  return [][]byte{
+    []byte("foo"),
+    []byte("bar"),
+    []byte(""),
+    big,
+    []byte(strings.Repeat("x", BlockSize - 4 - HeaderSize - 1751)),
+    []byte("after trailer"),
+  }
+}
+
+func TestLogGoldenRead(t *testing.T) {
+  contents, err := os.ReadFile("testdata/records.log")
+  if err != nil {
+    t.Fatal("Missing fixture: ", err)
+  }
+  var report reportCollector
+  reader := NewLogReader(&stringSource{contents:contents}, &report, true, 0)
+  for i, expected := range(goldenRecords()) {
+    record, ok := reader.ReadRecord()
+    if !ok || !bytes.Equal(record, expected) {
+      t.Fatal("Unexpected record ", i, ": ", len(record), " bytes")
+    }
+  }
+  if _, ok := reader.ReadRecord(); ok {
+    t.Error("Unexpected extra record.")
+  }
+  if report.droppedBytes != 0 {
+    t.Error("Unexpected drops: ", report.droppedBytes, " ", report.message)
+  }
+}
+
+func TestLogGoldenWrite(t *testing.T) {
+  expected, err := os.ReadFile("testdata/records.log")
+  if err != nil {
+    t.Fatal("Missing fixture: ", err)
+  }
+  var dest stringDest
+  writer := NewLogWriter(&dest, 0)
+  for _, record := range(goldenRecords()) {
+    writer.AddRecord(record)
+  }
+  if !bytes.Equal(dest.contents, expected) {
+    t.Error("Log differs from fixture: ", len(dest.contents), " vs ", len(expected), " bytes")
+  }
+}
@@ -2,18 +2,22 @@ package log
 
 import (
   "encoding/binary"
-  "hash/crc32"
-
-  "github.com/chenlanbo/leveldb/db"
+  "io"
 )
 
+// Destination of the log records, satisfied by leveldb.WritableFile.
+type WritableFile interface {
+  Write([]byte) (int, error)
+  Sync() error
+}
+
 type LogWriter struct {
-  dest db.WritableFile
+  dest WritableFile
   blockOffset int  // Current offset in block
 }
 
 // dest must have initial length "destLength"
-func NewLogWriter(dest db.WritableFile, destLength uint64) *LogWriter {
+func NewLogWriter(dest WritableFile, destLength uint64) *LogWriter {
   writer := &LogWriter{}
   writer.dest = dest
   writer.blockOffset = int(destLength % BlockSize)
@@ -33,8 +37,10 @@ func (writer *LogWriter) AddRecord(data []byte) error {
     if leftOver < HeaderSize {
       // Switch to a new block
       if leftOver > 0 {
-        trailer := []byte("\x00\x00\x00\x00\x00\x00")
-        writer.dest.Write(trailer[:leftOver])
+        // Fill the trailer with zeroes, which readers skip.
+        if _, err := writer.dest.Write(make([]byte, leftOver)); err != nil {
+          return err
+        }
       }
       writer.blockOffset = 0
     }
@@ -53,7 +59,7 @@ func (writer *LogWriter) AddRecord(data []byte) error {
 
     var t RecordType = ZeroType
     end := (left == fragmentLength)
-    if begin == end {
+    if begin && end {
       t = FullType
     } else if begin {
       t = FirstType
@@ -76,6 +82,11 @@ func (writer *LogWriter) AddRecord(data []byte) error {
   return err
 }
 
+// Flush the records written so far to stable storage.
+func (writer *LogWriter) Sync() error {
+  return writer.dest.Sync()
+}
+
 func (writer *LogWriter) emitPhysicalRecord(t RecordType, data []byte) error {
   if len(data) > 0xffff {
     panic("")
@@ -88,21 +99,16 @@ func (writer *LogWriter) emitPhysicalRecord(t RecordType, data []byte) error {
   header[5] = byte((len(data) >> 8) & 0xff)
   header[6] = byte(t)
 
-  checksum := crc32.Checksum([]byte{byte(t)}, crc32.IEEETable)
-  checksum = crc32.Update(checksum, crc32.IEEETable, data)
-  binary.LittleEndian.PutUint32(header[:4], checksum)
+  binary.LittleEndian.PutUint32(header[:4], recordChecksum(t, data))
 
   nwrite, err := writer.dest.Write(header)
-  if nwrite != len(header) {
-    panic("")
+  if err == nil && nwrite != len(header) {
+    err = io.ErrShortWrite
   }
   if err == nil {
     nwrite, err = writer.dest.Write(data)
-    if nwrite != len(data) {
-      panic("")
-    }
-    if err == nil {
-      writer.dest.Sync()
+    if err == nil && nwrite != len(data) {
+      err = io.ErrShortWrite
     }
   }
   writer.blockOffset += HeaderSize + len(data)
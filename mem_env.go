@@ -0,0 +1,339 @@
+package leveldb
+
+import (
+  "io"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+)
+
+// The contents of a file of a memEnv. Open handles keep the contents alive
+// after the file is deleted or replaced, like on a POSIX file system.
+type memFileState struct {
+  mu sync.RWMutex
+  data []byte
+}
+
+func (state *memFileState) size() int64 {
+  state.mu.RLock()
+  defer state.mu.RUnlock()
+  return int64(len(state.data))
+}
+
+func (state *memFileState) readAt(b []byte, off int64) int {
+  state.mu.RLock()
+  defer state.mu.RUnlock()
+  if off >= int64(len(state.data)) {
+    return 0
+  }
+  return copy(b, state.data[off:])
+}
+
+func (state *memFileState) append(b []byte) {
+  state.mu.Lock()
+  defer state.mu.Unlock()
+  state.data = append(state.data, b...)
+}
+
+// An Env that keeps its files in memory. Directories only need to exist to
+// be listed: files can be created in any directory. Time and background
+// work are delegated to the default Env.
+type memEnv struct {
+  mu sync.Mutex
+  files map[string]*memFileState
+  dirs map[string]bool
+  locks map[string]bool
+  base Env
+}
+
+// Return an Env that keeps files in memory instead of on disk, for hermetic
+// tests and for databases that do not need to persist. Every call returns a
+// new, empty file system.
+func NewMemEnv() Env {
+  e := &memEnv{}
+  e.files = make(map[string]*memFileState)
+  e.dirs = make(map[string]bool)
+  e.locks = make(map[string]bool)
+  e.base = DefaultEnv()
+  return e
+}
+
+// The error of the os package for a missing file, so that errors look the
+// same as those of the default Env.
+func memNotFound(op, filename string) error {
+  return fileError(filename, &os.PathError{Op:op, Path:filename, Err:os.ErrNotExist})
+}
+
+func (e *memEnv) file(op, filename string) (*memFileState, error) {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  state, ok := e.files[filepath.Clean(filename)]
+  if !ok {
+    return nil, memNotFound(op, filename)
+  }
+  return state, nil
+}
+
+func (e *memEnv) NewSequentialFile(filename string) (SequentialFile, error) {
+  state, err := e.file("open", filename)
+  if err != nil {
+    return nil, err
+  }
+  return &memSequentialFile{filename:filename, state:state}, nil
+}
+
+func (e *memEnv) NewRandomAccessFile(filename string) (RandomAccessFile, error) {
+  state, err := e.file("open", filename)
+  if err != nil {
+    return nil, err
+  }
+  return &memRandomAccessFile{filename:filename, state:state}, nil
+}
+
+// Create an empty file, replacing any existing one.
+func (e *memEnv) NewWritableFile(filename string) (WritableFile, error) {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  state := &memFileState{}
+  e.files[filepath.Clean(filename)] = state
+  return &memWritableFile{filename:filename, state:state}, nil
+}
+
+// Open a file for appending, creating it if needed.
+func (e *memEnv) NewAppendableFile(filename string) (WritableFile, error) {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  name := filepath.Clean(filename)
+  state, ok := e.files[name]
+  if !ok {
+    state = &memFileState{}
+    e.files[name] = state
+  }
+  return &memWritableFile{filename:filename, state:state}, nil
+}
+
+func (e *memEnv) DeleteFile(filename string) error {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  name := filepath.Clean(filename)
+  if _, ok := e.files[name]; !ok {
+    return memNotFound("remove", filename)
+  }
+  delete(e.files, name)
+  return nil
+}
+
+func (e *memEnv) GetFileSize(filename string) (uint64, error) {
+  state, err := e.file("stat", filename)
+  if err != nil {
+    return 0, err
+  }
+  return uint64(state.size()), nil
+}
+
+func (e *memEnv) CreateDir(dirname string) error {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  name := filepath.Clean(dirname)
+  if _, ok := e.files[name]; ok || e.dirs[name] {
+    return fileError(dirname, &os.PathError{Op:"mkdir", Path:dirname, Err:os.ErrExist})
+  }
+  e.dirs[name] = true
+  return nil
+}
+
+// Remove an empty directory.
+func (e *memEnv) RemoveDir(dirname string) error {
+  children, err := e.GetChildren(dirname)
+  if err != nil {
+    return err
+  }
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  name := filepath.Clean(dirname)
+  if len(children) != 0 || !e.dirs[name] {
+    return fileError(dirname, &os.PathError{Op:"remove", Path:dirname, Err:os.ErrInvalid})
+  }
+  delete(e.dirs, name)
+  return nil
+}
+
+// Return the names of the files and directories directly under dirname.
+func (e *memEnv) GetChildren(dirname string) ([]string, error) {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  dir := filepath.Clean(dirname)
+  found := e.dirs[dir]
+  prefix := dir + string(filepath.Separator)
+  if dir == string(filepath.Separator) {
+    prefix = dir
+  }
+  var names []string
+  collect := func(name string) {
+    if strings.HasPrefix(name, prefix) {
+      found = true
+      if child := name[len(prefix):]; !strings.ContainsRune(child, filepath.Separator) {
+        names = append(names, child)
+      }
+    }
+  }
+  for name := range(e.files) {
+    collect(name)
+  }
+  for name := range(e.dirs) {
+    collect(name)
+  }
+  if !found {
+    return nil, memNotFound("open", dirname)
+  }
+  return names, nil
+}
+
+func (e *memEnv) FileExists(filename string) bool {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  name := filepath.Clean(filename)
+  _, ok := e.files[name]
+  return ok || e.dirs[name]
+}
+
+func (e *memEnv) RenameFile(src, target string) error {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  name := filepath.Clean(src)
+  state, ok := e.files[name]
+  if !ok {
+    return memNotFound("rename", src)
+  }
+  delete(e.files, name)
+  e.files[filepath.Clean(target)] = state
+  return nil
+}
+
+func (e *memEnv) SyncDir(dirname string) error {
+  return nil
+}
+
+type memFileLock struct {
+  filename string
+}
+
+func (e *memEnv) LockFile(filename string) (FileLock, error) {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  name := filepath.Clean(filename)
+  if e.locks[name] {
+    s := newStatus(StatusIOError, "lock")
+    s.File = filename
+    s.err = os.ErrExist
+    return nil, s
+  }
+  e.locks[name] = true
+  if _, ok := e.files[name]; !ok {
+    e.files[name] = &memFileState{}
+  }
+  return &memFileLock{filename:name}, nil
+}
+
+func (e *memEnv) UnlockFile(lock FileLock) error {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  delete(e.locks, lock.(*memFileLock).filename)
+  return nil
+}
+
+func (e *memEnv) NowMicros() uint64 {
+  return e.base.NowMicros()
+}
+
+func (e *memEnv) SleepForMicroseconds(micros int) {
+  e.base.SleepForMicroseconds(micros)
+}
+
+func (e *memEnv) Schedule(f func()) {
+  e.base.Schedule(f)
+}
+
+func (e *memEnv) StartThread(f func()) {
+  e.base.StartThread(f)
+}
+
+type memSequentialFile struct {
+  filename string
+  state *memFileState
+  pos int64
+}
+
+func (f *memSequentialFile) Close() error {
+  return nil
+}
+
+func (f *memSequentialFile) Read(b []byte) (int, error) {
+  n := f.state.readAt(b, f.pos)
+  f.pos += int64(n)
+  if n == 0 && len(b) > 0 {
+    return 0, io.EOF
+  }
+  return n, nil
+}
+
+// Skipping past the end of the file leaves it at the end.
+func (f *memSequentialFile) Skip(n int64) error {
+  if size := f.state.size(); f.pos + n > size {
+    f.pos = size
+  } else {
+    f.pos += n
+  }
+  return nil
+}
+
+type memRandomAccessFile struct {
+  filename string
+  state *memFileState
+}
+
+func (f *memRandomAccessFile) Close() error {
+  return nil
+}
+
+// Like os.File.ReadAt, returns io.EOF when fewer than len(b) bytes are read.
+func (f *memRandomAccessFile) ReadAt(b []byte, off int64) (int, error) {
+  if off < 0 {
+    return 0, fileError(f.filename, &os.PathError{Op:"read", Path:f.filename, Err:os.ErrInvalid})
+  }
+  n := f.state.readAt(b, off)
+  if n < len(b) {
+    return n, io.EOF
+  }
+  return n, nil
+}
+
+type memWritableFile struct {
+  filename string
+  state *memFileState
+  closed bool
+}
+
+func (f *memWritableFile) Close() error {
+  if f.closed {
+    return fileError(f.filename, os.ErrClosed)
+  }
+  f.closed = true
+  return nil
+}
+
+func (f *memWritableFile) Write(b []byte) (int, error) {
+  if f.closed {
+    return 0, fileError(f.filename, os.ErrClosed)
+  }
+  f.state.append(b)
+  return len(b), nil
+}
+
+func (f *memWritableFile) Sync() error {
+  if f.closed {
+    return fileError(f.filename, os.ErrClosed)
+  }
+  return nil
+}
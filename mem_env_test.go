@@ -0,0 +1,205 @@
+package leveldb
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "sort"
+  "testing"
+)
+
+func TestMemEnvBasics(t *testing.T) {
+  env := NewMemEnv()
+
+  if err := env.CreateDir("/dir"); err != nil {
+    t.Fatal("Cannot create directory: ", err)
+  }
+  if children, err := env.GetChildren("/dir"); err != nil || len(children) != 0 {
+    t.Error("New directory should be empty: ", children, " ", err)
+  }
+  if _, err := env.GetChildren("/missing"); !errors.Is(err, ErrNotFound) {
+    t.Error("Missing directory should not be listed: ", err)
+  }
+
+  // A missing file cannot be opened, measured, renamed or deleted.
+  if env.FileExists("/dir/f") {
+    t.Error("File should not exist.")
+  }
+  if _, err := env.NewSequentialFile("/dir/f"); !errors.Is(err, ErrNotFound) || !errors.Is(err, os.ErrNotExist) {
+    t.Error("Missing file should not be found: ", err)
+  }
+  if _, err := env.NewRandomAccessFile("/dir/f"); !errors.Is(err, ErrNotFound) {
+    t.Error("Missing file should not be found: ", err)
+  }
+  if _, err := env.GetFileSize("/dir/f"); !errors.Is(err, ErrNotFound) {
+    t.Error("Missing file should not be found: ", err)
+  }
+  if err := env.RenameFile("/dir/f", "/dir/g"); !errors.Is(err, ErrNotFound) {
+    t.Error("Missing file should not be renamed: ", err)
+  }
+  if err := env.DeleteFile("/dir/f"); !errors.Is(err, ErrNotFound) {
+    t.Error("Missing file should not be deleted: ", err)
+  }
+
+  // Create a file and rename it.
+  if err := writeStringToFile(env, []byte("abc"), "/dir/f", false); err != nil {
+    t.Fatal("Cannot write file: ", err)
+  }
+  if size, err := env.GetFileSize("/dir/f"); err != nil || size != 3 {
+    t.Error("Unexpected size: ", size, " ", err)
+  }
+  if err := env.RenameFile("/dir/f", "/dir/g"); err != nil {
+    t.Error("Cannot rename file: ", err)
+  }
+  if env.FileExists("/dir/f") || !env.FileExists("/dir/g") {
+    t.Error("File should have been renamed.")
+  }
+  if children, err := env.GetChildren("/dir"); err != nil || len(children) != 1 || children[0] != "g" {
+    t.Error("Unexpected children: ", children, " ", err)
+  }
+
+  // Appending keeps the contents, creating a writable file drops them.
+  file, err := env.NewAppendableFile("/dir/g")
+  if err != nil {
+    t.Fatal("Cannot open file: ", err)
+  }
+  file.Write([]byte("def"))
+  file.Close()
+  if data, err := readFileToString(env, "/dir/g"); err != nil || string(data) != "abcdef" {
+    t.Error("Unexpected contents: ", string(data), " ", err)
+  }
+  if err := writeStringToFile(env, []byte("x"), "/dir/g", false); err != nil {
+    t.Fatal("Cannot write file: ", err)
+  }
+  if data, err := readFileToString(env, "/dir/g"); err != nil || string(data) != "x" {
+    t.Error("Unexpected contents: ", string(data), " ", err)
+  }
+
+  if err := env.DeleteFile("/dir/g"); err != nil {
+    t.Error("Cannot delete file: ", err)
+  }
+  if env.FileExists("/dir/g") {
+    t.Error("File should have been deleted.")
+  }
+}
+
+func TestMemEnvReadWrite(t *testing.T) {
+  env := NewMemEnv()
+
+  file, err := env.NewWritableFile("/dir/f")
+  if err != nil {
+    t.Fatal("Cannot create file: ", err)
+  }
+  data := make([]byte, 0, 100000)
+  for i := 0; i < 100000; i++ {
+    data = append(data, byte(i % 251))
+  }
+  for i := 0; i < len(data); i += 1000 {
+    if n, err := file.Write(data[i:i + 1000]); n != 1000 || err != nil {
+      t.Fatal("Write failed: ", n, " ", err)
+    }
+  }
+  if err := file.Sync(); err != nil {
+    t.Error("Sync failed: ", err)
+  }
+
+  // Sequential reads, with skips.
+  seq, err := env.NewSequentialFile("/dir/f")
+  if err != nil {
+    t.Fatal("Cannot open file: ", err)
+  }
+  buf := make([]byte, 100)
+  if n, err := seq.Read(buf); n != 100 || err != nil || string(buf) != string(data[:100]) {
+    t.Error("Unexpected read: ", n, " ", err)
+  }
+  seq.Skip(1000)
+  if n, err := seq.Read(buf); n != 100 || err != nil || string(buf) != string(data[1100:1200]) {
+    t.Error("Unexpected read after skip: ", n, " ", err)
+  }
+  seq.Skip(int64(len(data)))
+  if n, err := seq.Read(buf); n != 0 || err != io.EOF {
+    t.Error("Expected the end of the file: ", n, " ", err)
+  }
+  seq.Close()
+
+  // Random reads; a short read returns io.EOF like os.File.
+  random, err := env.NewRandomAccessFile("/dir/f")
+  if err != nil {
+    t.Fatal("Cannot open file: ", err)
+  }
+  if n, err := random.ReadAt(buf, 5000); n != 100 || err != nil || string(buf) != string(data[5000:5100]) {
+    t.Error("Unexpected random read: ", n, " ", err)
+  }
+  if n, err := random.ReadAt(buf, int64(len(data)) - 10); n != 10 || err != io.EOF {
+    t.Error("Expected a short read: ", n, " ", err)
+  }
+  if n, err := random.ReadAt(buf, int64(len(data)) + 10); n != 0 || err != io.EOF {
+    t.Error("Expected no data past the end: ", n, " ", err)
+  }
+
+  // Open handles keep the contents of a deleted file.
+  file.Close()
+  env.DeleteFile("/dir/f")
+  if n, err := random.ReadAt(buf, 0); n != 100 || err != nil {
+    t.Error("Open file should stay readable after deletion: ", n, " ", err)
+  }
+  random.Close()
+
+  if _, err := file.Write([]byte("x")); !errors.Is(err, os.ErrClosed) {
+    t.Error("Closed file should not be writable: ", err)
+  }
+}
+
+func TestMemEnvDB(t *testing.T) {
+  env := NewMemEnv()
+  dbname := "/mem/db"
+  options := testDBOptions()
+  options.Env = env
+  options.WriteBufferSize = 16 << 10
+
+  db, err := Open(dbname, options)
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  const n = 2000
+  for i := 0; i < n; i++ {
+    if err := db.Put(nil, []byte(fmt.Sprintf("key%06d", i)), []byte(fmt.Sprint("value", i))); err != nil {
+      t.Fatal("Put failed: ", err)
+    }
+  }
+  if err := db.CompactRange(nil, nil); err != nil {
+    t.Fatal("Compaction failed: ", err)
+  }
+  db.Close()
+
+  if _, err := os.Stat(dbname); !os.IsNotExist(err) {
+    t.Error("Database should not be written to disk: ", err)
+  }
+  children, err := env.GetChildren(dbname)
+  if err != nil {
+    t.Fatal("Cannot list database: ", err)
+  }
+  sort.Strings(children)
+  counts := make(map[FileType]int)
+  for _, filename := range(children) {
+    if _, fileType, ok := ParseFileName(filename); ok {
+      counts[fileType]++
+    }
+  }
+  if counts[CurrentFile] != 1 || counts[DescriptorFile] != 1 || counts[TableFile] == 0 {
+    t.Error("Unexpected files in database: ", children)
+  }
+
+  // Reopen from the same Env.
+  db, err = Open(dbname, options)
+  if err != nil {
+    t.Fatal("Cannot reopen database: ", err)
+  }
+  defer db.Close()
+  for i := 0; i < n; i++ {
+    if v, err := db.Get(nil, []byte(fmt.Sprintf("key%06d", i))); err != nil || string(v) != fmt.Sprint("value", i) {
+      t.Fatal("Unexpected value for key ", i, ": ", string(v), " ", err)
+    }
+  }
+}
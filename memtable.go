@@ -5,10 +5,12 @@ import (
   "fmt"
 )
 
+// Return the slice that prefixes a with its length, or nil if a is
+// malformed.
 func getLengthPrefixedSlice(a []byte) []byte {
   l, nread := binary.Uvarint(a)
-  if nread <= 0 {
-    panic("")
+  if nread <= 0 || l > uint64(len(a) - nread) {
+    return nil
   }
   return a[nread:nread + int(l)]
 }
@@ -81,12 +83,21 @@ func (iter *memTableIterator) Value() []byte {
   return getLengthPrefixedSlice(iter.si.Key()[n + len(key):])
 }
 
+func (iter *memTableIterator) Error() error {
+  return iter.si.Error()
+}
+
+func (iter *memTableIterator) Close() error {
+  return iter.si.Close()
+}
+
 // Memtable
 type MemTable struct {
   comparator keyComparator
   arena *Arena
   table *SkipList
   iBuf []byte
+  entryBuf []byte  // Scratch space to encode entries in
 }
 
 func NewMemTable(comparator InternalKeyComparator) *MemTable {
@@ -111,7 +122,11 @@ func (mem *MemTable) Add(seq SequenceNumber, valueType ValueType, key []byte, va
   nwrite2 := binary.PutUvarint(mem.iBuf, uint64(len(value)))
   encodedLen := nwrite1 + internalKeySize + nwrite2 + len(value)
 
-  buf := mem.arena.Allocate(encodedLen)
+  // The skiplist copies the entry into a node in the arena.
+  if cap(mem.entryBuf) < encodedLen {
+    mem.entryBuf = make([]byte, encodedLen)
+  }
+  buf := mem.entryBuf[:encodedLen]
 
   // Write varint of internalKeySize
   n := binary.PutUvarint(buf, uint64(internalKeySize))
@@ -133,31 +148,40 @@ func (mem *MemTable) Add(seq SequenceNumber, valueType ValueType, key []byte, va
   mem.table.Insert(buf)
 }
 
-func (mem *MemTable) Get(key *LookupKey) ([]byte, error) {
+// If the memtable contains a value for key, return it with found set. If the
+// memtable contains a deletion for key, return found with a NotFoundError.
+// Otherwise found is false.
+func (mem *MemTable) Get(key *LookupKey) ([]byte, bool, error) {
   memKey := key.MemtableKey()
   iter := mem.table.NewIterator()
   iter.Seek(memKey)
   if !iter.Valid() {
-    return nil, NotFoundError("")
+    return nil, false, nil
   }
   entry := iter.Key()
   tmp, n := binary.Uvarint(entry)
-  if n <= 0 {
-    panic("")
+  if n <= 0 || tmp < 8 || tmp > uint64(len(entry) - n) {
+    return nil, true, CorruptionError("bad entry in memtable")
   }
   internalKeySize := int(tmp)
   if mem.comparator.comparator.UserComparator().Compare(entry[n:n + internalKeySize - 8], key.UserKey()) == 0 {
     tag := binary.LittleEndian.Uint64(entry[n + internalKeySize - 8: n + internalKeySize])
     t := ValueType(tag & 0xff)
-    if t == TypeValue {
-      tmp, n1 := binary.Uvarint(entry[n + internalKeySize:])
-      if n1 <= 0 {
-        panic("")
+    switch t {
+    case TypeValue:
+      value := getLengthPrefixedSlice(entry[n + internalKeySize:])
+      if value == nil {
+        return nil, true, CorruptionError("bad entry in memtable")
       }
-      valueSize := int(tmp)
-      return entry[n + internalKeySize + n1:n + internalKeySize + n1 + valueSize], nil
+      return value, true, nil
+    case TypeDeletion:
+      return nil, true, NotFoundError("")
     }
   }
-  return nil, NotFoundError("")
+  return nil, false, nil
 }
 
+// Approximate number of bytes of memory in use by the memtable.
+func (mem *MemTable) ApproximateMemoryUsage() int {
+  return mem.arena.MemoryUsage()
+}
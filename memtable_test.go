@@ -12,8 +12,8 @@ func TestMemTable(t *testing.T) {
   for i := 1; i <= 128; i++ {
     mem.Add(SequenceNumber(i), TypeValue, []byte("a"), []byte(fmt.Sprint(i)))
     key := NewLookupKey([]byte("a"), SequenceNumber(i))
-    value, err := mem.Get(key)
-    if err != nil {
+    value, found, err := mem.Get(key)
+    if !found || err != nil {
       t.Error("Key 'a' should be found.")
     }
     if DefaultComparator.Compare(value, []byte(fmt.Sprint(i))) != 0 {
@@ -21,3 +21,26 @@ func TestMemTable(t *testing.T) {
     }
   }
 }
+
+func TestMemTableDeletion(t *testing.T) {
+  comparator := NewInternalKeyComparator(DefaultComparator)
+  mem := NewMemTable(comparator)
+
+  mem.Add(SequenceNumber(1), TypeValue, []byte("a"), []byte("1"))
+  mem.Add(SequenceNumber(2), TypeDeletion, []byte("a"), []byte(""))
+
+  _, found, err := mem.Get(NewLookupKey([]byte("a"), SequenceNumber(2)))
+  if !found || err == nil {
+    t.Error("Key 'a' should be found as deleted.")
+  }
+
+  value, found, err := mem.Get(NewLookupKey([]byte("a"), SequenceNumber(1)))
+  if !found || err != nil || string(value) != "1" {
+    t.Error("Key 'a' should be visible at sequence 1.")
+  }
+
+  _, found, _ = mem.Get(NewLookupKey([]byte("b"), SequenceNumber(2)))
+  if found {
+    t.Error("Key 'b' should not be found.")
+  }
+}
@@ -3,21 +3,105 @@ package leveldb
 import (
 )
 
+// The type byte in the trailer of a block. Types 0x0 to 0x2 are those of the
+// C++ LevelDB format, so that tables can be shared with it; the codecs added
+// by this package use bytes it has not reserved.
 type CompressionType byte
 const (
   NoCompression CompressionType = 0x0
   SnappyCompression CompressionType = 0x1
+  ZlibCompression CompressionType = 0x80
+  DeflateCompression CompressionType = 0x81
+)
+
+// Zstd in the C++ LevelDB format. No codec is provided for it, so that its
+// tables fail to read as not supported rather than as corrupted.
+const upstreamZstdCompression CompressionType = 0x2
+
+// How to treat corruption found in the write-ahead log during recovery.
+type WALRecoveryMode byte
+const (
+  // Ignore an incomplete record at the end of a log, which is what a crash
+  // in the middle of a write leaves behind. Any other corruption fails Open.
+  TolerateCorruptedTailRecords WALRecoveryMode = 0x0
+  // Fail Open on any corruption, including an incomplete trailing record.
+  AbsoluteConsistency WALRecoveryMode = 0x1
+  // Drop corrupted records and recover whatever is left.
+  SkipAnyCorruptedRecords WALRecoveryMode = 0x2
 )
 
 type Options struct {
   Comparator Comparator
   BlockRestartInterval int
   BlockSize int
+  // Compress blocks using the specified compression algorithm. Any type with
+  // a compressor registered through RegisterCompressor can be used.
   CompressionType CompressionType
+
+  // If non-empty, the compression type of the tables written to level L is
+  // CompressionPerLevel[L], or its last entry for deeper levels; this
+  // overrides CompressionType. Memtable flushes use the type of level 0.
+  CompressionPerLevel []CompressionType
+
   FilterPolicy FilterPolicy
+
+  // Options used by the DB.
+  Env Env
+  CreateIfMissing bool  // Create the database if it is missing
+  ErrorIfExists bool  // Raise an error if the database already exists
+  WALRecoveryMode WALRecoveryMode
+
+  // Amount of data to build up in memory (backed by an unsorted log on disk)
+  // before converting to a sorted on-disk file.
+  WriteBufferSize int
+
+  // Target size of the files written by a compaction.
+  MaxFileSize int
+
+  // If non-nil, use the specified cache for blocks.
+  // If nil, the DB will automatically create and use an 8MB internal cache.
+  BlockCache Cache
+
+  // Number of open files that can be used by the DB. You may need to
+  // increase this if your database has a large working set (budget one open
+  // file per 2MB of working set).
+  MaxOpenFiles int
+
+  // If non-nil, throttle the table writes of flushes and compactions and
+  // the reads of tables through the specified limiter.
+  RateLimiter *RateLimiter
 }
 
 type ReadOptions struct {
   VerifyChecksums bool
+
+  // Should the data read for this iteration be cached in memory? Callers
+  // may wish to set this field to false for bulk scans. Reads of a DB with
+  // nil ReadOptions fill the cache.
   FillCache bool
+
+  // If non-nil, read as of the supplied snapshot (which must belong to the
+  // DB that is being read and which must not have been released). If nil,
+  // use an implicit snapshot of the state at the beginning of this read
+  // operation.
+  Snapshot *Snapshot
+
+  // Priority of the table reads of this operation with Options.RateLimiter.
+  IOPriority IOPriority
+}
+
+type WriteOptions struct {
+  // Sync the write-ahead log before the write is acknowledged.
+  Sync bool
+}
+
+// Return the compression type of the tables written to level.
+func (options *Options) compressionForLevel(level int) CompressionType {
+  if len(options.CompressionPerLevel) == 0 {
+    return options.CompressionType
+  }
+  if level >= len(options.CompressionPerLevel) {
+    level = len(options.CompressionPerLevel) - 1
+  }
+  return options.CompressionPerLevel[level]
 }
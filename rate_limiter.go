@@ -0,0 +1,217 @@
+package leveldb
+
+import (
+  "sync"
+)
+
+// The priority of an IO request to a RateLimiter.
+type IOPriority int
+const (
+  // IO on behalf of a user request.
+  IOPriorityUser IOPriority = 0
+  // IO of flushes and compactions.
+  IOPriorityBackground IOPriority = 1
+  numIOPriorities = 2
+)
+
+// Length of the period after which the bucket of a RateLimiter is refilled.
+const rateLimiterRefillPeriodMicros = 100 * 1000
+
+// Background requests are granted before user requests on one refill out of
+// rateLimiterFairness, so that they cannot starve.
+const rateLimiterFairness = 10
+
+// RateLimiter bounds the rate of the IO of a DB with a token bucket, so that
+// flushes and compactions do not saturate the disk. Every refill period the
+// bucket receives the bytes allowed for one period. Requests that find the
+// bucket empty wait in a queue per priority; user requests are granted
+// before background ones. A RateLimiter is safe for concurrent use and can
+// be shared by several DBs.
+type RateLimiter struct {
+  env Env  // Clock to measure refill periods with
+  mu sync.Mutex
+  granted *sync.Cond  // Signalled when waiting requests are granted
+  bytesPerSecond int64
+  available int64
+  nextRefillMicros uint64
+  refills int64
+  queues [numIOPriorities][]*rateLimiterRequest
+  // Is a waiting request sleeping until the next refill? It grants the
+  // waiting requests once it wakes up.
+  leader bool
+
+  totalBytes [numIOPriorities]int64
+  totalRequests [numIOPriorities]int64
+  throttledBytes [numIOPriorities]int64
+}
+
+type rateLimiterRequest struct {
+  bytes int64  // Bytes left to grant
+  granted bool
+}
+
+// Create a RateLimiter that allows bytesPerSecond bytes of IO per second.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+  return newRateLimiter(bytesPerSecond, DefaultEnv())
+}
+
+func newRateLimiter(bytesPerSecond int64, env Env) *RateLimiter {
+  if bytesPerSecond <= 0 {
+    panic("The rate of a RateLimiter must be positive.")
+  }
+  r := &RateLimiter{}
+  r.env = env
+  r.granted = sync.NewCond(&r.mu)
+  r.bytesPerSecond = bytesPerSecond
+  r.available = r.refillBytes()
+  r.nextRefillMicros = env.NowMicros() + rateLimiterRefillPeriodMicros
+  return r
+}
+
+// Change the rate of the limiter. Requests already waiting are granted at
+// the new rate from the next refill on.
+func (r *RateLimiter) SetBytesPerSecond(bytesPerSecond int64) {
+  if bytesPerSecond <= 0 {
+    panic("The rate of a RateLimiter must be positive.")
+  }
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  r.bytesPerSecond = bytesPerSecond
+}
+
+func (r *RateLimiter) BytesPerSecond() int64 {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  return r.bytesPerSecond
+}
+
+// Bytes allowed per refill period.
+// REQUIRES: r.mu held.
+func (r *RateLimiter) refillBytes() int64 {
+  n := r.bytesPerSecond * rateLimiterRefillPeriodMicros / 1000000
+  if n < 1 {
+    n = 1
+  }
+  return n
+}
+
+// Block until n bytes of IO with the given priority may proceed.
+func (r *RateLimiter) Request(n int, priority IOPriority) {
+  if n <= 0 {
+    return
+  }
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  r.totalRequests[priority]++
+  r.totalBytes[priority] += int64(n)
+
+  r.refill()
+  noWaiters := len(r.queues[IOPriorityUser]) == 0 && len(r.queues[IOPriorityBackground]) == 0
+  if noWaiters && r.available >= int64(n) {
+    r.available -= int64(n)
+    return
+  }
+
+  r.throttledBytes[priority] += int64(n)
+  req := &rateLimiterRequest{bytes:int64(n)}
+  r.queues[priority] = append(r.queues[priority], req)
+  r.grant()
+  for !req.granted {
+    if r.leader {
+      r.granted.Wait()
+      continue
+    }
+    r.leader = true
+    now := r.env.NowMicros()
+    if now < r.nextRefillMicros {
+      r.mu.Unlock()
+      r.env.SleepForMicroseconds(int(r.nextRefillMicros - now))
+      r.mu.Lock()
+    }
+    r.leader = false
+    r.refill()
+    r.grant()
+    r.granted.Broadcast()
+  }
+}
+
+// Refill the bucket if a refill period has passed.
+// REQUIRES: r.mu held.
+func (r *RateLimiter) refill() {
+  now := r.env.NowMicros()
+  if now < r.nextRefillMicros {
+    return
+  }
+  r.available = r.refillBytes()
+  r.nextRefillMicros = now + rateLimiterRefillPeriodMicros
+  r.refills++
+}
+
+// Grant the waiting requests that the bucket can satisfy, in priority
+// order. A request larger than what is left takes it all, and waits for
+// the rest of its bytes.
+// REQUIRES: r.mu held.
+func (r *RateLimiter) grant() {
+  order := [numIOPriorities]IOPriority{IOPriorityUser, IOPriorityBackground}
+  if r.refills % rateLimiterFairness == 0 {
+    order = [numIOPriorities]IOPriority{IOPriorityBackground, IOPriorityUser}
+  }
+  for _, priority := range(order) {
+    queue := r.queues[priority]
+    for len(queue) > 0 {
+      req := queue[0]
+      if req.bytes > r.available {
+        req.bytes -= r.available
+        r.available = 0
+        r.queues[priority] = queue
+        return
+      }
+      r.available -= req.bytes
+      req.granted = true
+      queue[0] = nil
+      queue = queue[1:]
+    }
+    r.queues[priority] = queue
+  }
+}
+
+// Total bytes requested with the given priority.
+func (r *RateLimiter) TotalBytesThrough(priority IOPriority) int64 {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  return r.totalBytes[priority]
+}
+
+// Total number of requests with the given priority.
+func (r *RateLimiter) TotalRequests(priority IOPriority) int64 {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  return r.totalRequests[priority]
+}
+
+// Total bytes of the requests with the given priority that had to wait.
+func (r *RateLimiter) TotalBytesThrottled(priority IOPriority) int64 {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  return r.throttledBytes[priority]
+}
+
+// A WritableFile whose writes are throttled by a RateLimiter.
+type rateLimitedWritableFile struct {
+  WritableFile
+  limiter *RateLimiter
+  priority IOPriority
+}
+
+// Return file throttled by limiter, or file itself if limiter is nil.
+func newRateLimitedWritableFile(file WritableFile, limiter *RateLimiter, priority IOPriority) WritableFile {
+  if limiter == nil {
+    return file
+  }
+  return &rateLimitedWritableFile{WritableFile:file, limiter:limiter, priority:priority}
+}
+
+func (f *rateLimitedWritableFile) Write(b []byte) (int, error) {
+  f.limiter.Request(len(b), f.priority)
+  return f.WritableFile.Write(b)
+}
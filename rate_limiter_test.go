@@ -0,0 +1,214 @@
+package leveldb
+
+import (
+  "fmt"
+  "os"
+  "sync"
+  "sync/atomic"
+  "testing"
+)
+
+// An Env whose clock only moves when it is slept on. If sleeps is non-nil,
+// every sleep waits for a value from it before moving the clock.
+type fakeClockEnv struct {
+  Env
+  now atomic.Uint64
+  sleeps chan bool
+}
+
+func newFakeClockEnv() *fakeClockEnv {
+  return &fakeClockEnv{Env:NewMemEnv()}
+}
+
+func (e *fakeClockEnv) NowMicros() uint64 {
+  return e.now.Load()
+}
+
+func (e *fakeClockEnv) SleepForMicroseconds(micros int) {
+  if e.sleeps != nil {
+    <-e.sleeps
+  }
+  e.now.Add(uint64(micros))
+}
+
+func TestRateLimiterRate(t *testing.T) {
+  env := newFakeClockEnv()
+  r := newRateLimiter(1 << 20, env)
+  periodBytes := (1 << 20) * rateLimiterRefillPeriodMicros / 1000000
+
+  // The first period's bytes are available right away.
+  r.Request(periodBytes, IOPriorityBackground)
+  if now := env.NowMicros(); now != 0 {
+    t.Error("Request within the bucket should not wait: ", now)
+  }
+  if n := r.TotalBytesThrottled(IOPriorityBackground); n != 0 {
+    t.Error("Unexpected throttled bytes: ", n)
+  }
+
+  // Four more seconds' worth of bytes, in requests larger than a period.
+  for i := 0; i < 8; i++ {
+    r.Request(1 << 19, IOPriorityBackground)
+  }
+  if now := env.NowMicros(); now < 4000000 || now > 4000000 + 2 * rateLimiterRefillPeriodMicros {
+    t.Error("Requests should take about four seconds: ", now)
+  }
+  if n := r.TotalBytesThrough(IOPriorityBackground); n != int64(periodBytes + 4 << 20) {
+    t.Error("Unexpected bytes through: ", n)
+  }
+  if n := r.TotalRequests(IOPriorityBackground); n != 9 {
+    t.Error("Unexpected requests: ", n)
+  }
+  if n := r.TotalBytesThrottled(IOPriorityBackground); n != 4 << 20 {
+    t.Error("Unexpected throttled bytes: ", n)
+  }
+  if n := r.TotalBytesThrough(IOPriorityUser); n != 0 {
+    t.Error("Unexpected user bytes: ", n)
+  }
+}
+
+func TestRateLimiterSetBytesPerSecond(t *testing.T) {
+  env := newFakeClockEnv()
+  r := newRateLimiter(1 << 20, env)
+  r.Request(1 << 20, IOPriorityUser)
+  start := env.NowMicros()
+
+  r.SetBytesPerSecond(4 << 20)
+  if rate := r.BytesPerSecond(); rate != 4 << 20 {
+    t.Fatal("Unexpected rate: ", rate)
+  }
+  r.Request(4 << 20, IOPriorityUser)
+  if elapsed := env.NowMicros() - start; elapsed > 1000000 + rateLimiterRefillPeriodMicros {
+    t.Error("Requests should take about one second at the new rate: ", elapsed)
+  }
+
+  // At a rate below one byte per refill, every refill still grants a byte.
+  r = newRateLimiter(1, env)
+  start = env.NowMicros()
+  r.Request(3, IOPriorityUser)
+  if elapsed := env.NowMicros() - start; elapsed != 2 * rateLimiterRefillPeriodMicros {
+    t.Error("Request should wait for two refills: ", elapsed)
+  }
+}
+
+// Wait until the limiter has n requests waiting.
+func waitForRateLimiterQueue(r *RateLimiter, n int) {
+  for {
+    r.mu.Lock()
+    waiting := len(r.queues[IOPriorityUser]) + len(r.queues[IOPriorityBackground])
+    r.mu.Unlock()
+    if waiting == n {
+      return
+    }
+    DefaultEnv().SleepForMicroseconds(100)
+  }
+}
+
+func TestRateLimiterPriority(t *testing.T) {
+  env := newFakeClockEnv()
+  env.sleeps = make(chan bool)
+  r := newRateLimiter(1 << 20, env)
+  periodBytes := (1 << 20) * rateLimiterRefillPeriodMicros / 1000000
+  r.Request(periodBytes, IOPriorityUser)
+
+  // Queue a background request before a user request. Each takes a whole
+  // refill, so they are granted one refill after the other.
+  granted := make(chan IOPriority)
+  request := func(priority IOPriority) {
+    go func() {
+      r.Request(periodBytes, priority)
+      granted <- priority
+    }()
+  }
+  request(IOPriorityBackground)
+  waitForRateLimiterQueue(r, 1)
+  request(IOPriorityUser)
+  waitForRateLimiterQueue(r, 2)
+
+  env.sleeps <- true
+  if priority := <-granted; priority != IOPriorityUser {
+    t.Error("User request should be granted first: ", priority)
+  }
+  env.sleeps <- true
+  if priority := <-granted; priority != IOPriorityBackground {
+    t.Error("Background request should be granted at the next refill: ", priority)
+  }
+  if n := r.TotalBytesThrottled(IOPriorityUser); n != int64(periodBytes) {
+    t.Error("Unexpected throttled user bytes: ", n)
+  }
+}
+
+func TestRateLimiterFairness(t *testing.T) {
+  env := newFakeClockEnv()
+  r := newRateLimiter(1 << 20, env)
+  periodBytes := (1 << 20) * rateLimiterRefillPeriodMicros / 1000000
+
+  // Keep the user queue busy: background requests still get through.
+  stop := make(chan bool)
+  var wg sync.WaitGroup
+  for i := 0; i < 4; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for {
+        select {
+        case <-stop:
+          return
+        default:
+          r.Request(periodBytes, IOPriorityUser)
+        }
+      }
+    }()
+  }
+  for i := 0; i < 3; i++ {
+    r.Request(periodBytes, IOPriorityBackground)
+  }
+  close(stop)
+  wg.Wait()
+}
+
+func TestDBRateLimiter(t *testing.T) {
+  dbname := newTestDBName()
+  defer os.RemoveAll(dbname)
+
+  options := testDBOptions()
+  options.RateLimiter = NewRateLimiter(1 << 30)
+  db, err := Open(dbname, options)
+  if err != nil {
+    t.Fatal("Cannot open database: ", err)
+  }
+  defer db.Close()
+
+  for i := 0; i < 100; i++ {
+    db.Put(nil, []byte(fmt.Sprint("key", i)), []byte(fmt.Sprint("value", i)))
+  }
+  if n := options.RateLimiter.TotalBytesThrough(IOPriorityBackground); n != 0 {
+    t.Error("Writes to the log should not be limited: ", n)
+  }
+  if err := db.flushMemTable(); err != nil {
+    t.Fatal("Cannot flush memtable: ", err)
+  }
+  // A second, overlapping table so that the compaction is not a trivial
+  // move.
+  db.Put(nil, []byte("key50"), []byte("value50"))
+  if err := db.flushMemTable(); err != nil {
+    t.Fatal("Cannot flush memtable: ", err)
+  }
+  flushed := options.RateLimiter.TotalBytesThrough(IOPriorityBackground)
+  if flushed == 0 {
+    t.Fatal("Flush should be limited.")
+  }
+
+  if err := db.CompactRange(nil, nil); err != nil {
+    t.Fatal("CompactRange failed: ", err)
+  }
+  if n := options.RateLimiter.TotalBytesThrough(IOPriorityBackground); n <= flushed {
+    t.Error("Compaction should be limited: ", n, " ", flushed)
+  }
+
+  if value, err := db.Get(nil, []byte("key7")); err != nil || string(value) != "value7" {
+    t.Fatal("Unexpected value: ", string(value), " ", err)
+  }
+  if n := options.RateLimiter.TotalBytesThrough(IOPriorityUser); n == 0 {
+    t.Error("Table reads of Get should be limited.")
+  }
+}
@@ -1,118 +1,186 @@
 package leveldb
 
 import (
+  "encoding/binary"
   "fmt"
   "math/rand"
-  // "reflect"
-  // "unsafe"
+  "sync"
+  "sync/atomic"
 )
 
 const (
   kMaxHeight = 12
 )
 
-// Skip list node structure
-type node struct {
-  key []byte
-  next []*node
+// Skip list nodes live in the arena of the list and are addressed by their
+// arenaRef, so that a large memtable holds no Go pointers for the garbage
+// collector to scan. The layout of a node is
+//
+//   key length: uint32
+//   height: uint32
+//   next: [height]arenaRef  // links to the next node at each level
+//   key: [key length]byte
+//
+// The links are read and written atomically so that readers can walk the
+// list while a writer inserts into it. nilArenaRef ends a list.
+type node = arenaRef
+
+const (
+  nodeHeaderSize = 8
+  nodeLinkSize = 8
+)
+
+// Allocate a node holding a copy of key with the given height. Its links
+// are nil.
+func (s *SkipList) newNode(key []byte, height int) node {
+  n := s.arena.AllocateAligned(nodeHeaderSize + nodeLinkSize * height + len(key))
+  buf := s.arena.data(n, nodeHeaderSize + nodeLinkSize * height + len(key))
+  binary.LittleEndian.PutUint32(buf[0:], uint32(len(key)))
+  binary.LittleEndian.PutUint32(buf[4:], uint32(height))
+  copy(buf[nodeHeaderSize + nodeLinkSize * height:], key)
+  return n
+}
+
+func (s *SkipList) height(n node) int {
+  return int(binary.LittleEndian.Uint32(s.arena.data(n + 4, 4)))
 }
 
-func newNode(arena *Arena, key []byte, height int) *node{
-  n := &node{}
-  n.key = key
-  // hBuf := arena.Allocate(24 + 8 * height)
-  // var sh *reflect.SliceHeader = (*reflect.SliceHeader)(unsafe.Pointer(&hBuf[0]))
-  // sh.Len = height
-  // sh.Cap = height
-  // sh.Data = uintptr(unsafe.Pointer(&hBuf[24]))
-  // n.next = *(*[]*node)(unsafe.Pointer(sh))
+func (s *SkipList) key(n node) []byte {
+  block, off := s.arena.block(n)
+  keyLength := int(binary.LittleEndian.Uint32(block[off:]))
+  height := int(binary.LittleEndian.Uint32(block[off + 4:]))
+  start := off + nodeHeaderSize + nodeLinkSize * height
+  return block[start:start + keyLength:start + keyLength]
+}
 
-  n.next = make([]*node, height)
+// Return the successor of n at level l. The load ensures that a reader
+// observes a fully initialized node.
+func (s *SkipList) getNext(n node, l int) node {
+  return node(atomic.LoadUint64(s.arena.word(n + arenaRef(nodeHeaderSize + nodeLinkSize * l))))
+}
 
-  // Note: bulkBarrierPreWrite: unaligned arguments
-  // for i := range(n.next) {
-  //   n.next[i] = nil
-  // }
-  return n
+// Link x after n at level l. The store publishes x, so it must be fully
+// initialized beforehand.
+func (s *SkipList) setNext(n node, l int, x node) {
+  atomic.StoreUint64(s.arena.word(n + arenaRef(nodeHeaderSize + nodeLinkSize * l)), uint64(x))
 }
 
+// SkipList is an ordered set of keys.
+//
+// Writes require external synchronization, most likely a mutex. Reads
+// require a guarantee that the SkipList will not be destroyed while the read
+// is in progress; apart from that, reads progress without any internal
+// locking or synchronization, even while a write is in progress.
+//
+// Nodes are never deleted, and a node's key never changes once the node is
+// linked into the list: only Insert modifies the list, and it initializes a
+// node fully before publishing it with an atomic store.
 type SkipList struct {
   comparator Comparator
   arena *Arena
-  head *node
-  maxHeight int
+  head node
+
+  // Height of the entire list. Modified only by Insert. Read racily by
+  // readers, but stale values are ok.
+  maxHeight atomic.Int32
+
+  // Read and written only by Insert.
+  rnd *rand.Rand
 }
 
+// Source of the seeds of the per-list random number generators, so that
+// the heights of different lists are not correlated.
+var (
+  skipListSeedMu sync.Mutex
+  skipListSeeds = rand.New(rand.NewSource(0xdeadbeef))
+)
+
+// Create a skiplist whose nodes are allocated in arena.
 func NewSkipList(comparator Comparator, arena *Arena) *SkipList {
-  rand.Seed(71)
+  skipListSeedMu.Lock()
+  seed := skipListSeeds.Int63()
+  skipListSeedMu.Unlock()
+
   s := &SkipList{}
   s.comparator = comparator
   s.arena = arena
-  s.head = newNode(s.arena, []byte(""), kMaxHeight)
-  s.maxHeight = 1
+  s.head = s.newNode(nil, kMaxHeight)
+  s.maxHeight.Store(1)
+  s.rnd = rand.New(rand.NewSource(seed))
   return s
 }
 
+func (s *SkipList) getMaxHeight() int {
+  return int(s.maxHeight.Load())
+}
+
+// Insert a copy of key into the list.
+// REQUIRES: nothing that compares equal to key is currently in the list,
+// and no other Insert runs concurrently.
 func (s *SkipList) Insert(key []byte) {
   _, prev := s.findGreaterOrEqual(key)
 
   height := s.randomHeight()
-  if s.maxHeight < height {
-    for i := s.maxHeight; i < height; i++ {
+  if height > s.getMaxHeight() {
+    for i := s.getMaxHeight(); i < height; i++ {
       prev[i] = s.head
     }
-    s.maxHeight = height
+    // A concurrent reader that observes the new height will see either the
+    // old nil links from head, or the new node set in the loop below. In
+    // the former case the reader immediately drops to the next level since
+    // nil sorts after all keys.
+    s.maxHeight.Store(int32(height))
   }
 
-  n := newNode(s.arena, key, height)
+  n := s.newNode(key, height)
   for i := 0; i < height; i++ {
-    n.next[i] = prev[i].next[i]
-    prev[i].next[i] = n
+    // Set the links of the new node before the store into prev[i]
+    // publishes it to readers.
+    s.setNext(n, i, s.getNext(prev[i], i))
+    s.setNext(prev[i], i, n)
   }
 }
 
 func (s *SkipList) Contains(key []byte) bool {
-  _, prev := s.findGreaterOrEqual(key)
-  if prev[0].next[0] != nil && s.comparator.Compare(prev[0].next[0].key, key) == 0 {
-    return true
-  } else {
-    return false
-  }
+  x, _ := s.findGreaterOrEqual(key)
+  return x != nilArenaRef && s.comparator.Compare(s.key(x), key) == 0
 }
 
 func (s *SkipList) NewIterator() Iterator {
-  return &SkipListIterator{s:s, n:nil}
+  return &SkipListIterator{s:s, n:nilArenaRef}
 }
 
 func (s *SkipList) randomHeight() int {
+  // Increase height with probability 1 in 4
   height := 1
-  for height < kMaxHeight {
-    if rand.Intn(4) != 0 {
-      break
-    }
+  for height < kMaxHeight && s.rnd.Intn(4) == 0 {
     height++
   }
   return height
 }
 
-func (s *SkipList) keyIsAfterNode(key []byte, n *node) bool {
-  return (n != nil) && (s.comparator.Compare(n.key, key) < 0)
+func (s *SkipList) keyIsAfterNode(key []byte, n node) bool {
+  return (n != nilArenaRef) && (s.comparator.Compare(s.key(n), key) < 0)
 }
 
-func (s *SkipList) findGreaterOrEqual(key []byte) (*node, [kMaxHeight]*node) {
-  var prev [kMaxHeight]*node
+// Return the earliest node at or after key, or nilArenaRef if there is
+// none, along with the node before it at every level.
+func (s *SkipList) findGreaterOrEqual(key []byte) (node, [kMaxHeight]node) {
+  var prev [kMaxHeight]node
   x := s.head
-  l := s.maxHeight - 1
+  l := s.getMaxHeight() - 1
   for {
-    if l >= len(x.next) {
-      panic(fmt.Sprint(string(x.key), " out of range: ", l, " ", len(x.next)))
+    if l >= s.height(x) {
+      panic(fmt.Sprint(string(s.key(x)), " out of range: ", l, " ", s.height(x)))
     }
-    next := x.next[l]
+    next := s.getNext(x, l)
     if s.keyIsAfterNode(key, next) {
+      // Keep searching in this list
       x = next
     } else {
       prev[l] = x
       if l > 0 {
+        // Switch to next list
         l--
       } else {
         return next, prev
@@ -120,18 +188,20 @@ func (s *SkipList) findGreaterOrEqual(key []byte) (*node, [kMaxHeight]*node) {
     }
   }
 
-  return nil, prev
+  return nilArenaRef, prev
 }
 
-func (s *SkipList) findLessThan(key []byte) *node {
+// Return the latest node with a key < key, or head if there is no such node.
+func (s *SkipList) findLessThan(key []byte) node {
   x := s.head
-  l := s.maxHeight - 1
+  l := s.getMaxHeight() - 1
   for {
-    y := x.next[l]
-    if y == nil || s.comparator.Compare(y.key, key) >= 0 {
+    y := s.getNext(x, l)
+    if y == nilArenaRef || s.comparator.Compare(s.key(y), key) >= 0 {
       if l == 0 {
         return x
       } else {
+        // Switch to next list
         l--
       }
     } else {
@@ -141,12 +211,13 @@ func (s *SkipList) findLessThan(key []byte) *node {
   panic("This should never happen.")
 }
 
-func (s *SkipList) findLast() *node {
+// Return the last node in the list, or head if the list is empty.
+func (s *SkipList) findLast() node {
   x := s.head
-  l := s.maxHeight - 1
+  l := s.getMaxHeight() - 1
   for {
-    y := x.next[l]
-    if y == nil {
+    y := s.getNext(x, l)
+    if y == nilArenaRef {
       if l == 0 {
         return x
       } else {
@@ -157,36 +228,36 @@ func (s *SkipList) findLast() *node {
     }
   }
 
-  return nil
+  return nilArenaRef
 }
 
 type SkipListIterator struct {
   s *SkipList
-  n *node
+  n node
 }
 
 func NewSkipListIterator(s *SkipList) *SkipListIterator {
-  return &SkipListIterator{s:s, n:nil}
+  return &SkipListIterator{s:s, n:nilArenaRef}
 }
 
 func (iter *SkipListIterator) Valid() bool {
-  return iter.n != nil
+  return iter.n != nilArenaRef
 }
 
 func (iter *SkipListIterator) Next() {
   if !iter.Valid() {
     panic("")
   }
-  iter.n = iter.n.next[0]
+  iter.n = iter.s.getNext(iter.n, 0)
 }
 
 func (iter *SkipListIterator) Prev() {
   if !iter.Valid() {
     panic("")
   }
-  iter.n = iter.s.findLessThan(iter.n.key)
+  iter.n = iter.s.findLessThan(iter.s.key(iter.n))
   if iter.n == iter.s.head {
-    iter.n = nil
+    iter.n = nilArenaRef
   }
 }
 
@@ -194,7 +265,7 @@ func (iter *SkipListIterator) Key() []byte {
   if !iter.Valid() {
     panic("")
   }
-  return iter.n.key
+  return iter.s.key(iter.n)
 }
 
 func (iter *SkipListIterator) Value() []byte {
@@ -206,12 +277,21 @@ func (iter *SkipListIterator) Seek(key []byte) {
 }
 
 func (iter *SkipListIterator) SeekToFirst() {
-  iter.n = iter.s.head.next[0]
+  iter.n = iter.s.getNext(iter.s.head, 0)
 }
 
 func (iter *SkipListIterator) SeekToLast() {
   iter.n = iter.s.findLast()
   if iter.n == iter.s.head {
-    iter.n = nil
+    iter.n = nilArenaRef
   }
 }
+
+// Iteration over a skiplist cannot fail.
+func (iter *SkipListIterator) Error() error {
+  return nil
+}
+
+func (iter *SkipListIterator) Close() error {
+  return nil
+}
@@ -1,8 +1,12 @@
 package leveldb
 
 import (
+  "encoding/binary"
   "fmt"
   "math/rand"
+  "runtime"
+  "sync"
+  "sync/atomic"
   "testing"
 )
 
@@ -154,3 +158,219 @@ func TestIteratorComplexOperations(t *testing.T) {
     t.Error("")
   }
 }
+
+// One writer inserts keys while several readers look them up and scan the
+// list. Run with -race to check the synchronization of the links.
+func TestSkipListConcurrentReads(t *testing.T) {
+  const numKeys = 20000
+  const numReaders = 4
+
+  rnd := rand.New(rand.NewSource(301))
+  keys := make([][]byte, numKeys)
+  for i, k := range(rnd.Perm(numKeys)) {
+    keys[i] = make([]byte, 8)
+    binary.BigEndian.PutUint64(keys[i], uint64(k))
+  }
+
+  s := NewSkipList(DefaultComparator, NewArena())
+  // keys[:inserted] are known to be in the list.
+  var inserted atomic.Int64
+  var done atomic.Bool
+  var wg sync.WaitGroup
+  errs := make(chan string, numReaders)
+  for r := 0; r < numReaders; r++ {
+    wg.Add(1)
+    go func(seed int64) {
+      defer wg.Done()
+      rnd := rand.New(rand.NewSource(seed))
+      for !done.Load() {
+        n := int(inserted.Load())
+        if n > 0 {
+          if key := keys[rnd.Intn(n)]; !s.Contains(key) {
+            errs <- fmt.Sprint("Inserted key not found: ", key)
+            return
+          }
+        }
+
+        // A scan yields the keys in order, and at least all the keys known
+        // to be inserted when it started.
+        count := 0
+        var prev []byte
+        iter := s.NewIterator()
+        for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+          if prev != nil && DefaultComparator.Compare(prev, iter.Key()) >= 0 {
+            errs <- fmt.Sprint("Skip list is not ordered: ", prev, " ", iter.Key())
+            return
+          }
+          prev = iter.Key()
+          count++
+        }
+        if count < n {
+          errs <- fmt.Sprint("Scan missed keys: ", count, " < ", n)
+          return
+        }
+      }
+    }(int64(r))
+  }
+
+  for i := 0; i < numKeys; i++ {
+    s.Insert(keys[i])
+    inserted.Store(int64(i + 1))
+  }
+  done.Store(true)
+  wg.Wait()
+  close(errs)
+  for err := range(errs) {
+    t.Error(err)
+  }
+}
+
+// The layout of the skiplist before nodes moved into the arena: every node
+// is a heap object linked by Go pointers, with its key in the arena. Kept
+// as the baseline of the benchmarks below.
+type pointerNode struct {
+  key []byte
+  next []atomic.Pointer[pointerNode]
+}
+
+type pointerSkipList struct {
+  comparator Comparator
+  arena *Arena
+  head *pointerNode
+  maxHeight int
+  rnd *rand.Rand
+}
+
+func newPointerSkipList(comparator Comparator, arena *Arena) *pointerSkipList {
+  s := &pointerSkipList{comparator:comparator, arena:arena, maxHeight:1}
+  s.head = &pointerNode{next:make([]atomic.Pointer[pointerNode], kMaxHeight)}
+  s.rnd = rand.New(rand.NewSource(301))
+  return s
+}
+
+func (s *pointerSkipList) findGreaterOrEqual(key []byte) (*pointerNode, [kMaxHeight]*pointerNode) {
+  var prev [kMaxHeight]*pointerNode
+  x := s.head
+  for l := s.maxHeight - 1; ; {
+    next := x.next[l].Load()
+    if next != nil && s.comparator.Compare(next.key, key) < 0 {
+      x = next
+    } else {
+      prev[l] = x
+      if l == 0 {
+        return next, prev
+      }
+      l--
+    }
+  }
+}
+
+func (s *pointerSkipList) Insert(key []byte) {
+  _, prev := s.findGreaterOrEqual(key)
+  height := 1
+  for height < kMaxHeight && s.rnd.Intn(4) == 0 {
+    height++
+  }
+  for ; s.maxHeight < height; s.maxHeight++ {
+    prev[s.maxHeight] = s.head
+  }
+  n := &pointerNode{next:make([]atomic.Pointer[pointerNode], height)}
+  n.key = s.arena.Allocate(len(key))
+  copy(n.key, key)
+  for i := 0; i < height; i++ {
+    n.next[i].Store(prev[i].next[i].Load())
+    prev[i].next[i].Store(n)
+  }
+}
+
+func (s *pointerSkipList) Contains(key []byte) bool {
+  x, _ := s.findGreaterOrEqual(key)
+  return x != nil && s.comparator.Compare(x.key, key) == 0
+}
+
+type benchmarkSkipList interface {
+  Insert(key []byte)
+  Contains(key []byte) bool
+}
+
+var skipListLayouts = []struct {
+  name string
+  new func() benchmarkSkipList
+}{
+  {"Arena", func() benchmarkSkipList { return NewSkipList(DefaultComparator, NewArena()) }},
+  {"Pointer", func() benchmarkSkipList { return newPointerSkipList(DefaultComparator, NewArena()) }},
+}
+
+// Return n distinct 16-byte keys in random order.
+func benchmarkSkipListKeys(n int) [][]byte {
+  rnd := rand.New(rand.NewSource(301))
+  keys := make([][]byte, n)
+  for i, k := range(rnd.Perm(n)) {
+    keys[i] = make([]byte, 16)
+    binary.BigEndian.PutUint64(keys[i], uint64(k))
+    binary.BigEndian.PutUint64(keys[i][8:], rnd.Uint64())
+  }
+  return keys
+}
+
+func BenchmarkSkipListInsert(b *testing.B) {
+  keys := benchmarkSkipListKeys(1 << 16)
+  for _, layout := range(skipListLayouts) {
+    b.Run(layout.name, func(b *testing.B) {
+      s := layout.new()
+      for i := 0; i < b.N; i++ {
+        if i % len(keys) == 0 && i > 0 {
+          b.StopTimer()
+          s = layout.new()
+          b.StartTimer()
+        }
+        s.Insert(keys[i % len(keys)])
+      }
+    })
+  }
+}
+
+func BenchmarkSkipListSeek(b *testing.B) {
+  keys := benchmarkSkipListKeys(1 << 18)
+  for _, layout := range(skipListLayouts) {
+    b.Run(layout.name, func(b *testing.B) {
+      s := layout.new()
+      for _, key := range(keys) {
+        s.Insert(key)
+      }
+      b.ResetTimer()
+      for i := 0; i < b.N; i++ {
+        if !s.Contains(keys[(i * 7919) % len(keys)]) {
+          b.Fatal("Inserted key not found.")
+        }
+      }
+    })
+  }
+}
+
+// Measure the garbage collections of a heap that holds a large skiplist.
+// Besides the time per collection, reports the average stop-the-world pause.
+func BenchmarkSkipListGC(b *testing.B) {
+  keys := benchmarkSkipListKeys(1 << 20)
+  for _, layout := range(skipListLayouts) {
+    b.Run(layout.name, func(b *testing.B) {
+      s := layout.new()
+      for _, key := range(keys) {
+        s.Insert(key)
+      }
+      runtime.GC()
+      var before, after runtime.MemStats
+      runtime.ReadMemStats(&before)
+      b.ResetTimer()
+      for i := 0; i < b.N; i++ {
+        runtime.GC()
+      }
+      b.StopTimer()
+      runtime.ReadMemStats(&after)
+      if gcs := after.NumGC - before.NumGC; gcs > 0 {
+        b.ReportMetric(float64(after.PauseTotalNs - before.PauseTotalNs) / float64(gcs), "pause-ns/gc")
+      }
+      runtime.KeepAlive(s)
+    })
+  }
+}
@@ -0,0 +1,129 @@
+package snappy
+
+import (
+  "encoding/binary"
+)
+
+// DecodedLen returns the length of the decoded block.
+func DecodedLen(src []byte) (int, error) {
+  v, _, err := decodedLen(src)
+  return v, err
+}
+
+// Return the length of the decoded block and the number of bytes that the
+// length header occupied.
+func decodedLen(src []byte) (int, int, error) {
+  v, n := binary.Uvarint(src)
+  if n <= 0 || v > 0xffffffff {
+    return 0, 0, ErrCorrupt
+  }
+
+  const wordSize = 32 << (^uint(0) >> 32 & 1)
+  if wordSize == 32 && v > 0x7fffffff {
+    return 0, 0, ErrTooLarge
+  }
+  return int(v), n, nil
+}
+
+// Decode returns the decoded form of src. The returned slice may be a
+// sub-slice of dst if dst was large enough to hold the entire decoded
+// block.
+func Decode(dst, src []byte) ([]byte, error) {
+  dLen, s, err := decodedLen(src)
+  if err != nil {
+    return nil, err
+  }
+  if dLen <= len(dst) {
+    dst = dst[:dLen]
+  } else {
+    dst = make([]byte, dLen)
+  }
+  if !decode(dst, src[s:]) {
+    return nil, ErrCorrupt
+  }
+  return dst, nil
+}
+
+// Decode src into dst, which must be exactly the decoded length. Return
+// false if src is not a valid encoding of len(dst) bytes.
+func decode(dst, src []byte) bool {
+  var d, s, offset, length int
+  for s < len(src) {
+    switch src[s] & 0x03 {
+    case tagLiteral:
+      x := uint32(src[s] >> 2)
+      switch {
+      case x < 60:
+        s++
+      case x == 60:
+        s += 2
+        if s > len(src) {
+          return false
+        }
+        x = uint32(src[s - 1])
+      case x == 61:
+        s += 3
+        if s > len(src) {
+          return false
+        }
+        x = uint32(src[s - 2]) | uint32(src[s - 1]) << 8
+      case x == 62:
+        s += 4
+        if s > len(src) {
+          return false
+        }
+        x = uint32(src[s - 3]) | uint32(src[s - 2]) << 8 | uint32(src[s - 1]) << 16
+      case x == 63:
+        s += 5
+        if s > len(src) {
+          return false
+        }
+        x = uint32(src[s - 4]) | uint32(src[s - 3]) << 8 | uint32(src[s - 2]) << 16 | uint32(src[s - 1]) << 24
+      }
+      length = int(x) + 1
+      if length <= 0 || length > len(dst) - d || length > len(src) - s {
+        return false
+      }
+      copy(dst[d:], src[s:s + length])
+      d += length
+      s += length
+      continue
+
+    case tagCopy1:
+      s += 2
+      if s > len(src) {
+        return false
+      }
+      length = 4 + int(src[s - 2]) >> 2 & 0x7
+      offset = int(uint32(src[s - 2]) & 0xe0 << 3 | uint32(src[s - 1]))
+
+    case tagCopy2:
+      s += 3
+      if s > len(src) {
+        return false
+      }
+      length = 1 + int(src[s - 3]) >> 2
+      offset = int(uint32(src[s - 2]) | uint32(src[s - 1]) << 8)
+
+    case tagCopy4:
+      s += 5
+      if s > len(src) {
+        return false
+      }
+      length = 1 + int(src[s - 5]) >> 2
+      offset = int(uint32(src[s - 4]) | uint32(src[s - 3]) << 8 | uint32(src[s - 2]) << 16 | uint32(src[s - 1]) << 24)
+    }
+
+    if offset <= 0 || d < offset || length > len(dst) - d {
+      return false
+    }
+    // Copy from an earlier sub-slice of dst to a later sub-slice. Unlike
+    // the built-in copy function, this byte-by-byte copy always runs
+    // forwards, even if the slices overlap, which a run of repeated
+    // bytes relies on.
+    for end := d + length; d < end; d++ {
+      dst[d] = dst[d - offset]
+    }
+  }
+  return d == len(dst)
+}
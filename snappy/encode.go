@@ -0,0 +1,265 @@
+package snappy
+
+import (
+  "encoding/binary"
+)
+
+const (
+  // The encoder reads 8 bytes at a time while matching, so the last
+  // inputMargin bytes of a block are always emitted as a literal.
+  inputMargin = 16 - 1
+
+  // Blocks shorter than this are emitted as a single literal.
+  minNonLiteralBlockSize = 1 + 1 + inputMargin
+
+  maxTableSize = 1 << 14
+  tableMask = maxTableSize - 1
+)
+
+// MaxEncodedLen returns the maximum length of a snappy block, given its
+// uncompressed length. It returns a negative value if srcLen is too large
+// to encode.
+func MaxEncodedLen(srcLen int) int {
+  n := uint64(srcLen)
+  if n > 0xffffffff {
+    return -1
+  }
+  // Compressed data can be defined as:
+  //    compressed := item* literal*
+  //    item       := literal* copy
+  //
+  // The trailing literal sequence has a space blowup of at most 62/60
+  // since a literal of length 60 needs one tag byte + one extra byte
+  // for length information.
+  //
+  // Item blowup is trickier to measure. Suppose the "copy" op copies
+  // 4 bytes of data. Because of a special check in the encoding code,
+  // we produce a 4-byte copy only if the offset is < 65536. Therefore
+  // the copy op takes 3 bytes to encode, and this type of item leads
+  // to at most the 62/60 blowup for representing literals.
+  //
+  // Suppose the "copy" op copies 5 bytes of data. If the offset is big
+  // enough, it will take 5 bytes to encode the copy op. Therefore the
+  // worst case here is a one-byte literal followed by a five-byte copy.
+  // That is, 6 bytes of input turn into 7 bytes of "compressed" data.
+  //
+  // This last factor dominates the blowup, so the final estimate is:
+  n = 32 + n + n / 6
+  if n > 0xffffffff {
+    return -1
+  }
+  return int(n)
+}
+
+// Encode returns the encoded form of src. The returned slice may be a
+// sub-slice of dst if dst was large enough to hold the entire encoded
+// block.
+func Encode(dst, src []byte) []byte {
+  n := MaxEncodedLen(len(src))
+  if n < 0 {
+    panic(ErrTooLarge)
+  } else if len(dst) < n {
+    dst = make([]byte, n)
+  }
+
+  // The block starts with the varint-encoded length of the decompressed
+  // bytes.
+  d := binary.PutUvarint(dst, uint64(len(src)))
+
+  for len(src) > 0 {
+    p := src
+    src = nil
+    if len(p) > maxBlockSize {
+      p, src = p[:maxBlockSize], p[maxBlockSize:]
+    }
+    if len(p) < minNonLiteralBlockSize {
+      d += emitLiteral(dst[d:], p)
+    } else {
+      d += encodeBlock(dst[d:], p)
+    }
+  }
+  return dst[:d]
+}
+
+func load32(b []byte, i int) uint32 {
+  return binary.LittleEndian.Uint32(b[i:])
+}
+
+func load64(b []byte, i int) uint64 {
+  return binary.LittleEndian.Uint64(b[i:])
+}
+
+func hash(u, shift uint32) uint32 {
+  return (u * 0x1e35a7bd) >> shift
+}
+
+// Write a literal chunk to dst and return the number of bytes written.
+// REQUIRES: len(dst) is long enough, 1 <= len(lit) <= 65536
+func emitLiteral(dst, lit []byte) int {
+  i, n := 0, uint(len(lit) - 1)
+  switch {
+  case n < 60:
+    dst[0] = uint8(n) << 2 | tagLiteral
+    i = 1
+  case n < 1 << 8:
+    dst[0] = 60 << 2 | tagLiteral
+    dst[1] = uint8(n)
+    i = 2
+  default:
+    dst[0] = 61 << 2 | tagLiteral
+    dst[1] = uint8(n)
+    dst[2] = uint8(n >> 8)
+    i = 3
+  }
+  return i + copy(dst[i:], lit)
+}
+
+// Write a copy chunk to dst and return the number of bytes written.
+// REQUIRES: len(dst) is long enough, 1 <= offset <= 65535, 4 <= length
+func emitCopy(dst []byte, offset, length int) int {
+  i := 0
+  // The maximum length for a single tagCopy1 or tagCopy2 op is 64 bytes.
+  // The threshold for this loop is a little higher (at 68 = 64 + 4), and
+  // the length emitted down below is a little lower (at 60 = 64 - 4),
+  // because it's shorter to encode a length 67 copy as a length 60
+  // tagCopy2 followed by a length 7 tagCopy1 (which encodes as 3+2
+  // bytes) than as a length 64 tagCopy2 followed by a length 3 tagCopy2
+  // (which encodes as 3+3 bytes). The magic 4 in the 64±4 is because the
+  // minimum length for a tagCopy1 op is 4 bytes, which is why a length 3
+  // copy has to be an edge case.
+  for length >= 68 {
+    // Emit a length 64 copy, encoded as 3 bytes.
+    dst[i + 0] = 63 << 2 | tagCopy2
+    dst[i + 1] = uint8(offset)
+    dst[i + 2] = uint8(offset >> 8)
+    i += 3
+    length -= 64
+  }
+  if length > 64 {
+    // Emit a length 60 copy, encoded as 3 bytes.
+    dst[i + 0] = 59 << 2 | tagCopy2
+    dst[i + 1] = uint8(offset)
+    dst[i + 2] = uint8(offset >> 8)
+    i += 3
+    length -= 60
+  }
+  if length >= 12 || offset >= 2048 {
+    // Emit the remaining copy, encoded as 3 bytes.
+    dst[i + 0] = uint8(length - 1) << 2 | tagCopy2
+    dst[i + 1] = uint8(offset)
+    dst[i + 2] = uint8(offset >> 8)
+    return i + 3
+  }
+  // Emit the remaining copy, encoded as 2 bytes.
+  dst[i + 0] = uint8(offset >> 8) << 5 | uint8(length - 4) << 2 | tagCopy1
+  dst[i + 1] = uint8(offset)
+  return i + 2
+}
+
+// Encode a non-empty src to a guaranteed-large-enough dst and return the
+// number of bytes written. It assumes that the varint-encoded length of the
+// decompressed bytes has already been written.
+// REQUIRES: minNonLiteralBlockSize <= len(src) <= maxBlockSize
+func encodeBlock(dst, src []byte) int {
+  d := 0
+
+  // Initialize the hash table. Its size ranges from 1<<8 to 1<<14
+  // inclusive. The table element type is uint16, as offsets within a
+  // block always fit.
+  shift := uint32(32 - 8)
+  for tableSize := 1 << 8; tableSize < maxTableSize && tableSize < len(src); tableSize *= 2 {
+    shift--
+  }
+  var table [maxTableSize]uint16
+
+  // sLimit is when to stop looking for offset/length copies. The
+  // inputMargin lets us use a fast path for emitLiteral in the main loop,
+  // while we are looking for copies.
+  sLimit := len(src) - inputMargin
+
+  // nextEmit is where in src the next emitLiteral should start from.
+  nextEmit := 0
+
+  // The encoded form must start with a literal, as there are no previous
+  // bytes to copy, so we start looking for hash matches at s == 1.
+  s := 1
+  nextHash := hash(load32(src, s), shift)
+
+main:
+  for {
+    // Heuristic match skipping: if 32 bytes are scanned with no matches
+    // found, start looking only at every other byte. If 32 more bytes are
+    // scanned (or skipped), look at every third byte, etc.. When a match
+    // is found, immediately go back to looking at every byte. This is a
+    // small loss (~5% performance, ~0.1% density) for compressible data
+    // due to more bookkeeping, but for non-compressible data (such as
+    // JPEG) it's a huge win since the compressor quickly "realizes" the
+    // data is incompressible and doesn't bother looking for matches
+    // everywhere.
+    skip := 32
+
+    nextS := s
+    candidate := 0
+    for {
+      s = nextS
+      bytesBetweenHashLookups := skip >> 5
+      nextS = s + bytesBetweenHashLookups
+      skip += bytesBetweenHashLookups
+      if nextS > sLimit {
+        break main
+      }
+      candidate = int(table[nextHash & tableMask])
+      table[nextHash & tableMask] = uint16(s)
+      nextHash = hash(load32(src, nextS), shift)
+      if load32(src, s) == load32(src, candidate) {
+        break
+      }
+    }
+
+    // A 4-byte match has been found. We'll later see if more than 4 bytes
+    // match. But, prior to the match, src[nextEmit:s] are unmatched. Emit
+    // them as literal bytes.
+    d += emitLiteral(dst[d:], src[nextEmit:s])
+
+    // Call emitCopy, and then see if another emitCopy could be our next
+    // move. Repeat until we find no match for the input immediately after
+    // what was consumed by the last emitCopy call.
+    for {
+      // Invariant: we have a 4-byte match at s, and no need to emit any
+      // literal bytes prior to s.
+      base := s
+
+      // Extend the 4-byte match as long as possible.
+      s += 4
+      for i := candidate + 4; s < len(src) && src[i] == src[s]; i, s = i + 1, s + 1 {
+      }
+
+      d += emitCopy(dst[d:], base - candidate, s - base)
+      nextEmit = s
+      if s >= sLimit {
+        break main
+      }
+
+      // We could immediately start working at s now, but to improve
+      // compression we first update the hash table at s-1 and at s. If
+      // another emitCopy is not our next move, also calculate nextHash
+      // at s+1.
+      x := load64(src, s - 1)
+      prevHash := hash(uint32(x >> 0), shift)
+      table[prevHash & tableMask] = uint16(s - 1)
+      currHash := hash(uint32(x >> 8), shift)
+      candidate = int(table[currHash & tableMask])
+      table[currHash & tableMask] = uint16(s)
+      if uint32(x >> 8) != load32(src, candidate) {
+        nextHash = hash(uint32(x >> 16), shift)
+        s++
+        break
+      }
+    }
+  }
+
+  if nextEmit < len(src) {
+    d += emitLiteral(dst[d:], src[nextEmit:])
+  }
+  return d
+}
@@ -0,0 +1,31 @@
+// Package snappy implements the Snappy compression format used for the
+// blocks of LevelDB tables. The encoding is described in
+// https://github.com/google/snappy/blob/main/format_description.txt.
+//
+// A compressed stream starts with the uncompressed length as a uvarint,
+// followed by a sequence of elements. Each element starts with a tag byte
+// whose low two bits select the element type: a literal run of bytes, or a
+// copy of earlier output at an offset encoded in 1, 2 or 4 bytes.
+package snappy
+
+import (
+  "errors"
+)
+
+var (
+  // ErrCorrupt reports that the input is invalid.
+  ErrCorrupt = errors.New("snappy: corrupt input")
+  // ErrTooLarge reports that the uncompressed length is too large.
+  ErrTooLarge = errors.New("snappy: decoded block is too large")
+)
+
+const (
+  tagLiteral = 0x00
+  tagCopy1 = 0x01
+  tagCopy2 = 0x02
+  tagCopy4 = 0x03
+)
+
+// The input is compressed in independent blocks of at most maxBlockSize
+// bytes, so that copy offsets always fit in 2 bytes.
+const maxBlockSize = 65536
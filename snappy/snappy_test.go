@@ -0,0 +1,141 @@
+package snappy
+
+import (
+  "bytes"
+  "fmt"
+  "math/rand"
+  "strings"
+  "testing"
+)
+
+func roundtrip(t *testing.T, src []byte) []byte {
+  encoded := Encode(nil, src)
+  if len(encoded) > MaxEncodedLen(len(src)) {
+    t.Fatal("Encoded length exceeds the bound: ", len(encoded), " > ", MaxEncodedLen(len(src)))
+  }
+  if n, err := DecodedLen(encoded); err != nil || n != len(src) {
+    t.Fatal("Unexpected decoded length: ", n, " ", err)
+  }
+  decoded, err := Decode(nil, encoded)
+  if err != nil {
+    t.Fatal("Decode failed: ", err)
+  }
+  if !bytes.Equal(decoded, src) {
+    t.Fatal("Roundtrip mismatch for input of length ", len(src))
+  }
+  return encoded
+}
+
+func TestEmpty(t *testing.T) {
+  encoded := roundtrip(t, nil)
+  if !bytes.Equal(encoded, []byte{0}) {
+    t.Error("Unexpected encoding of empty input: ", encoded)
+  }
+}
+
+func TestSmallLiterals(t *testing.T) {
+  for n := 1; n < 100; n++ {
+    roundtrip(t, []byte(strings.Repeat("x", n)))
+  }
+  encoded := roundtrip(t, []byte("abc"))
+  if !bytes.Equal(encoded, []byte{0x03, 0x08, 'a', 'b', 'c'}) {
+    t.Error("Unexpected encoding: ", encoded)
+  }
+}
+
+func TestDecodeCopies(t *testing.T) {
+  // "abc" as a literal followed by a 1-byte offset copy of length 5 at
+  // offset 3, which overlaps the bytes it produces.
+  decoded, err := Decode(nil, []byte{0x08, 0x08, 'a', 'b', 'c', 0x05, 0x03})
+  if err != nil || string(decoded) != "abcabcab" {
+    t.Error("Unexpected decoding: ", string(decoded), " ", err)
+  }
+
+  // The same copy with a 2-byte and a 4-byte offset.
+  decoded, err = Decode(nil, []byte{0x08, 0x08, 'a', 'b', 'c', 0x12, 0x03, 0x00})
+  if err != nil || string(decoded) != "abcabcab" {
+    t.Error("Unexpected decoding: ", string(decoded), " ", err)
+  }
+  decoded, err = Decode(nil, []byte{0x08, 0x08, 'a', 'b', 'c', 0x13, 0x03, 0x00, 0x00, 0x00})
+  if err != nil || string(decoded) != "abcabcab" {
+    t.Error("Unexpected decoding: ", string(decoded), " ", err)
+  }
+}
+
+func TestCompressible(t *testing.T) {
+  src := []byte(strings.Repeat("LevelDB is a fast key-value storage library. ", 1000))
+  encoded := roundtrip(t, src)
+  if len(encoded) > len(src) / 10 {
+    t.Error("Repetitive input should compress well: ", len(encoded), " of ", len(src))
+  }
+}
+
+func TestRandom(t *testing.T) {
+  rnd := rand.New(rand.NewSource(301))
+  for i := 0; i < 200; i++ {
+    n := rnd.Intn(1 << uint(rnd.Intn(18)))
+    src := make([]byte, n)
+    // Mix random bytes with runs copied from earlier in the input so that
+    // every kind of element is produced.
+    for j := 0; j < n; {
+      if j > 0 && rnd.Intn(2) == 0 {
+        offset := 1 + rnd.Intn(j)
+        length := rnd.Intn(100)
+        for k := 0; k < length && j < n; k++ {
+          src[j] = src[j - offset]
+          j++
+        }
+      } else {
+        src[j] = byte(rnd.Intn(256))
+        j++
+      }
+    }
+    roundtrip(t, src)
+  }
+}
+
+func TestLargeInput(t *testing.T) {
+  // Inputs longer than a block are compressed as several blocks.
+  var buf bytes.Buffer
+  for i := 0; buf.Len() < 3 * maxBlockSize; i++ {
+    fmt.Fprintf(&buf, "key%08d:value%08d;", i, i * 7)
+  }
+  roundtrip(t, buf.Bytes())
+}
+
+func TestCorrupt(t *testing.T) {
+  src := []byte(strings.Repeat("corruption ", 100))
+  encoded := Encode(nil, src)
+
+  for _, data := range([][]byte{
+      {},
+      {0xff, 0xff, 0xff, 0xff, 0xff, 0xff},  // Bad length
+      {0x05, 0x08, 'a', 'b', 'c'},  // Too short
+      {0x03, 0x08, 'a', 'b', 'c', 'd'},  // Too long
+      {0x08, 0x08, 'a', 'b', 'c', 0x05, 0x04},  // Offset before the start
+      {0x08, 0x08, 'a', 'b', 'c', 0x05, 0x00},  // Zero offset
+      encoded[:len(encoded) - 1]}) {
+    if _, err := Decode(nil, data); err == nil {
+      t.Error("Corrupted input should not decode: ", data)
+    }
+  }
+}
+
+func BenchmarkEncode(b *testing.B) {
+  src := []byte(strings.Repeat("LevelDB is a fast key-value storage library. ", 1000))
+  dst := make([]byte, MaxEncodedLen(len(src)))
+  b.SetBytes(int64(len(src)))
+  for i := 0; i < b.N; i++ {
+    Encode(dst, src)
+  }
+}
+
+func BenchmarkDecode(b *testing.B) {
+  src := []byte(strings.Repeat("LevelDB is a fast key-value storage library. ", 1000))
+  encoded := Encode(nil, src)
+  dst := make([]byte, len(src))
+  b.SetBytes(int64(len(src)))
+  for i := 0; i < b.N; i++ {
+    Decode(dst, encoded)
+  }
+}
@@ -0,0 +1,83 @@
+package leveldb
+
+import (
+)
+
+// Abstract handle to particular state of a DB. A Snapshot is an immutable
+// object and can therefore be safely accessed from multiple goroutines
+// without any external synchronization.
+type Snapshot struct {
+  sequenceNumber SequenceNumber
+
+  // Snapshots are kept in a doubly-linked list in the DB. Each Snapshot
+  // points to the list it belongs to, which is used to sanity check
+  // releases.
+  prev *Snapshot
+  next *Snapshot
+  list *snapshotList
+}
+
+// Return the sequence number of the newest entry visible to the snapshot.
+func (s *Snapshot) SequenceNumber() SequenceNumber {
+  return s.sequenceNumber
+}
+
+// Doubly-linked list of the live snapshots of a DB, ordered by sequence
+// number. Not safe for concurrent use.
+type snapshotList struct {
+  // Dummy head of doubly-linked list of snapshots
+  head Snapshot
+}
+
+func newSnapshotList() *snapshotList {
+  list := &snapshotList{}
+  list.head.prev = &list.head
+  list.head.next = &list.head
+  return list
+}
+
+func (list *snapshotList) Empty() bool {
+  return list.head.next == &list.head
+}
+
+func (list *snapshotList) Oldest() *Snapshot {
+  if list.Empty() {
+    panic("Oldest snapshot of an empty list.")
+  }
+  return list.head.next
+}
+
+func (list *snapshotList) Newest() *Snapshot {
+  if list.Empty() {
+    panic("Newest snapshot of an empty list.")
+  }
+  return list.head.prev
+}
+
+// Creates a Snapshot and appends it to the end of the list.
+func (list *snapshotList) New(sequenceNumber SequenceNumber) *Snapshot {
+  if !list.Empty() && list.Newest().sequenceNumber > sequenceNumber {
+    panic("Snapshot sequence numbers go backwards.")
+  }
+
+  snapshot := &Snapshot{sequenceNumber:sequenceNumber, list:list}
+  snapshot.next = &list.head
+  snapshot.prev = list.head.prev
+  snapshot.prev.next = snapshot
+  snapshot.next.prev = snapshot
+  return snapshot
+}
+
+// Removes a Snapshot from this list.
+//
+// The snapshot must have been created by calling New() on this list.
+func (list *snapshotList) Delete(snapshot *Snapshot) {
+  if snapshot.list != list {
+    panic("Snapshot does not belong to this list.")
+  }
+  snapshot.prev.next = snapshot.next
+  snapshot.next.prev = snapshot.prev
+  snapshot.prev = nil
+  snapshot.next = nil
+  snapshot.list = nil
+}
@@ -2,9 +2,10 @@ package leveldb
 
 import (
   "encoding/binary"
-  "errors"
-  "fmt"
-  "hash/crc32"
+  "io"
+  "math"
+
+  "github.com/chenlanbo/leveldb/crc32c"
 )
 
 const (
@@ -27,8 +28,15 @@ func (handle *BlockHandle) SetSize(size uint64) {
   handle.size = size
 }
 
+// Return whether the block at handle, with its trailer, lies within the
+// first fileSize bytes of a file.
+func (handle *BlockHandle) within(fileSize uint64) bool {
+  return handle.offset <= fileSize && handle.size <= fileSize - handle.offset &&
+      BlockTrailerSize <= fileSize - handle.offset - handle.size
+}
+
 func (handle *BlockHandle) EncodeTo() []byte {
-  iBuf := make([]byte, 8)
+  iBuf := make([]byte, binary.MaxVarintLen64)
   out := make([]byte, 0)
   n := binary.PutUvarint(iBuf, handle.offset)
   for i := 0; i < n; i++ {
@@ -45,11 +53,11 @@ func (handle *BlockHandle) DecodeFrom(data []byte) error {
   var n int
   handle.offset, n = binary.Uvarint(data)
   if n <= 0 {
-    return errors.New("Corrupt block handle.")
+    return CorruptionError("bad block handle")
   }
   handle.size, n = binary.Uvarint(data[n:])
   if n <= 0 {
-    return errors.New("Corrupt block handle.")
+    return CorruptionError("bad block handle")
   }
   return nil
 }
@@ -91,7 +99,7 @@ func (f *Footer) DecodeFrom(data []byte) error {
   t := int(2 * MaxBlockHandleEncodedLength)
   magic := uint64(binary.LittleEndian.Uint32(data[t + 4:])) << 32 | uint64(binary.LittleEndian.Uint32(data[t:t + 4]))
   if magic != TableMagicNumber {
-    return errors.New("Corrupted sstable file: bad magic number.")
+    return CorruptionError("not an sstable (bad magic number)")
   }
 
   err := f.metaIndexHandle.DecodeFrom(data)
@@ -105,37 +113,56 @@ func (f *Footer) DecodeFrom(data []byte) error {
   return err
 }
 
-// Read the sstable block specified by the block handle
+// Read the sstable block specified by the block handle. If file is a
+// SliceableFile, the result may be a slice of the file rather than a copy,
+// and is then only valid until the file is closed.
+// The size of the file is not known, so only handles reaching past 2GB are
+// rejected; Table reads check handles against the size of the file.
 func ReadBlock(file RandomAccessFile, options *ReadOptions, handle *BlockHandle) ([]byte, error) {
-  buf := make([]byte, int(handle.size) + BlockTrailerSize)
+  out, _, err := readBlockContents(file, math.MaxInt32, options, handle)
+  return out, err
+}
 
-  n, err := file.ReadAt(buf, int64(handle.offset))
-  if err != nil {
-    return nil, err
+// Like ReadBlock for a file of fileSize bytes, and also report whether the
+// result can outlive file, e.g. in the block cache.
+func readBlockContents(file RandomAccessFile, fileSize uint64, options *ReadOptions, handle *BlockHandle) ([]byte, bool, error) {
+  // The handle was decoded from the file: do not trust it with an
+  // allocation.
+  if !handle.within(fileSize) {
+    return nil, false, corruptionAt(handle.offset, "bad block handle")
   }
-  if n != len(buf) {
-    return nil, errors.New("Corrupted sstable file: truncated block read.")
+  var buf []byte
+  var n int
+  var err error
+  sliceable, direct := file.(SliceableFile)
+  if direct {
+    buf, err = sliceable.Slice(int64(handle.offset), int(handle.size) + BlockTrailerSize)
+    n = len(buf)
+  } else {
+    buf = make([]byte, int(handle.size) + BlockTrailerSize)
+    n, err = file.ReadAt(buf, int64(handle.offset))
+  }
+  if err != nil && err != io.EOF {
+    return nil, false, err
+  }
+  if n != int(handle.size) + BlockTrailerSize {
+    return nil, false, corruptionAt(handle.offset, "truncated block read")
   }
 
   if options.VerifyChecksums {
-    // TODO: add verify checksums
-    checksum := crc32.Checksum(buf[:int(handle.size) + 1], crc32.IEEETable)
-    expected := binary.LittleEndian.Uint32(buf[int(handle.size) + 1:])
+    // The checksum covers the block contents and the compression type.
+    checksum := crc32c.Value(buf[:int(handle.size) + 1])
+    expected := crc32c.Unmask(binary.LittleEndian.Uint32(buf[int(handle.size) + 1:]))
     if checksum != expected {
-      panic(fmt.Sprint("Checksum mismatch: ", checksum, " ", expected))
+      return nil, false, corruptionAt(handle.offset, "block checksum mismatch")
     }
   }
 
-  switch CompressionType(buf[handle.size]) {
-  case NoCompression:
-    // Skip
-  case SnappyCompression:
-    return nil, errors.New("Unsupported snappy block.")
-  default:
-    fmt.Println(len(buf), " ", handle.size)
-    fmt.Println("BLOCK BYTES: ", buf)
-    return nil, errors.New(fmt.Sprint("Corrupted sstable file: bad block type ", buf[handle.size]))
+  t := CompressionType(buf[handle.size])
+  out, err := uncompressBlock(t, buf[:int(handle.size)])
+  if s, ok := err.(*Status); ok {
+    s.Offset = int64(handle.offset)
   }
-
-  return buf[:int(handle.size)], nil
+  // Uncompressed contents of a sliced block still point into the file.
+  return out, !direct || t != NoCompression, err
 }
@@ -1,14 +1,15 @@
 package leveldb
 
 import (
-  "errors"
-  "fmt"
+  "encoding/binary"
+  "io"
 )
 
 type Table struct {
   options Options
   status error
   file RandomAccessFile
+  size uint64
   cacheId uint64
   filterBlockReader *FilterBlockReader
   metaIndexHandle BlockHandle
@@ -17,16 +18,16 @@ type Table struct {
 
 func NewTable(options *Options, file RandomAccessFile, size uint64) (*Table, error) {
   if size < FooterLength {
-    return nil, errors.New("Corrupted sstable file: too short.")
+    return nil, CorruptionError("file is too short to be an sstable")
   }
 
   footerSpace := make([]byte, FooterLength)
   n, err := file.ReadAt(footerSpace, int64(size - FooterLength))
-  if err != nil {
+  if err != nil && err != io.EOF {
     return nil, err
   }
   if n != FooterLength {
-    return nil, errors.New("Corrupted sstable file: truncated footer read.")
+    return nil, corruptionAt(size - FooterLength, "truncated footer read")
   }
 
   var footer Footer
@@ -37,19 +38,22 @@ func NewTable(options *Options, file RandomAccessFile, size uint64) (*Table, err
 
   var readOptions ReadOptions
   readOptions.VerifyChecksums = true
-  out, err := ReadBlock(file, &readOptions, &(footer.indexHandle))
+  out, _, err := readBlockContents(file, size, &readOptions, &(footer.indexHandle))
   if err != nil {
-    fmt.Println("HERE*: ", err, " ", footer.indexHandle)
-    fmt.Println("Footer: ", footerSpace)
     return nil, err
   }
 
   table := &Table{}
   table.options = *options
   table.file = file
+  table.size = size
+  if options.BlockCache != nil {
+    table.cacheId = options.BlockCache.NewId()
+  }
   table.filterBlockReader = nil
   table.metaIndexHandle = footer.metaIndexHandle
   table.indexBlock = NewBlock(out)
+  table.readMeta(&footer)
 
   return table, nil
 }
@@ -59,28 +63,113 @@ func (table *Table) NewIterator(readOptions *ReadOptions) Iterator {
   return newTableIterator(indexIter, table.blockReader, readOptions)
 }
 
+// Blocks stored in block caches, shared by all tables.
+var cachedBlocks = newCacheValueMap()
+
+func deleteCachedBlock(key []byte, value uintptr) {
+  cachedBlocks.Remove(value)
+}
+
+// Convert an index iterator value (i.e., an encoded BlockHandle) into an
+// iterator over the contents of the corresponding block. Blocks are looked
+// up in and added to the block cache, if any; the iterator releases the
+// cache handle once it is released.
 func (table *Table) blockReader(readOptions *ReadOptions, indexValue []byte) Iterator {
+  iter, err := table.readBlockIterator(readOptions, indexValue)
+  if err != nil {
+    return newEmptyIterator(err)
+  }
+  return iter
+}
+
+// Same as blockReader, but reports the failure to read the block as an error.
+func (table *Table) readBlockIterator(readOptions *ReadOptions, indexValue []byte) (Iterator, error) {
   var block *Block = nil
+  blockCache := table.options.BlockCache
+  cacheHandle := NullCacheHandle
   handle := BlockHandle{}
 
   err := handle.DecodeFrom(indexValue)
+  // We intentionally allow extra stuff in indexValue so that we
+  // can add more features in the future.
 
   if err == nil {
-    if readOptions.FillCache {
-      // TODO: add cache
+    if blockCache != nil {
+      var cacheKey [16]byte
+      binary.LittleEndian.PutUint64(cacheKey[0:], table.cacheId)
+      binary.LittleEndian.PutUint64(cacheKey[8:], handle.offset)
+      cacheHandle = blockCache.Lookup(cacheKey[:])
+      if cacheHandle != NullCacheHandle {
+        block = cachedBlocks.Get(blockCache.Value(cacheHandle)).(*Block)
+      } else {
+        var out []byte
+        var cachable bool
+        out, cachable, err = table.readBlock(readOptions, &handle)
+        if err == nil {
+          block = NewBlock(out)
+          if readOptions.FillCache && cachable {
+            cacheHandle = blockCache.Insert(cacheKey[:], cachedBlocks.Add(block), len(out), deleteCachedBlock)
+          }
+        }
+      }
     } else {
-      out, err := ReadBlock(table.file, readOptions, &handle)
+      var out []byte
+      out, _, err = table.readBlock(readOptions, &handle)
       if err == nil {
         block = NewBlock(out)
       }
     }
   }
 
-  if block != nil {
-    return block.NewIterator(table.options.Comparator)
-  } else {
-    return newEmptyIterator(err)
+  if block == nil {
+    return nil, err
+  }
+  iter := block.NewIterator(table.options.Comparator)
+  if cacheHandle != NullCacheHandle {
+    iter = newCleanupIterator(iter, func() {
+      blockCache.Release(cacheHandle)
+    })
+  }
+  return iter, nil
+}
+
+// Read the block at handle, throttled by the rate limiter of the table.
+// Also report whether the block can be cached, see readBlockContents.
+func (table *Table) readBlock(readOptions *ReadOptions, handle *BlockHandle) ([]byte, bool, error) {
+  if limiter := table.options.RateLimiter; limiter != nil {
+    limiter.Request(int(handle.size) + BlockTrailerSize, readOptions.IOPriority)
+  }
+  return readBlockContents(table.file, table.size, readOptions, handle)
+}
+
+// Seek to key in the table and call handleResult with the entry found, if
+// any. The filter is consulted before the data block is read, so a lookup
+// for a key that the filter rules out does not read any data block.
+func (table *Table) InternalGet(readOptions *ReadOptions, key []byte, handleResult func(k, v []byte)) error {
+  indexIter := table.indexBlock.NewIterator(table.options.Comparator)
+  indexIter.Seek(key)
+  if !indexIter.Valid() {
+    return indexIter.Error()
+  }
+
+  handleValue := indexIter.Value()
+  var handle BlockHandle
+  if table.filterBlockReader != nil && handle.DecodeFrom(handleValue) == nil &&
+      !table.filterBlockReader.MayContain(handle.offset, key) {
+    // Not found
+    return nil
+  }
+
+  blockIter, err := table.readBlockIterator(readOptions, handleValue)
+  if err != nil {
+    return err
+  }
+  defer blockIter.Close()
+  blockIter.Seek(key)
+  if blockIter.Valid() {
+    handleResult(blockIter.Key(), blockIter.Value())
   }
+  return blockIter.Error()
 }
 
 // Parse metadata index block
@@ -91,9 +180,9 @@ func (table *Table) readMeta(footer *Footer) {
 
   var readOptions ReadOptions
   readOptions.VerifyChecksums = true
-  out, err := ReadBlock(table.file, &readOptions, &(footer.metaIndexHandle))
+  out, _, err := readBlockContents(table.file, table.size, &readOptions, &(footer.metaIndexHandle))
   if err != nil {
-    fmt.Println("HERE: ", err)
+    // Do not propagate errors since meta info is not needed for operation
     return
   }
   metaBlock := NewBlock(out)
@@ -116,7 +205,7 @@ func (table *Table) readFilter(rawFilterHandle []byte) {
 
   var readOptions ReadOptions
   readOptions.VerifyChecksums = true
-  out, err := ReadBlock(table.file, &readOptions, &filterHandle)
+  out, _, err := readBlockContents(table.file, table.size, &readOptions, &filterHandle)
   if err != nil {
     return
   }
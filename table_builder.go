@@ -2,7 +2,9 @@ package leveldb
 
 import (
   "encoding/binary"
-  "hash/crc32"
+  "fmt"
+
+  "github.com/chenlanbo/leveldb/crc32c"
 )
 
 type TableBuilder struct {
@@ -19,6 +21,7 @@ type TableBuilder struct {
   pendingIndexEntry bool
   pendingHandle BlockHandle
   filterBlock *FilterBlockBuilder
+  compressedOutput []byte
 }
 
 func NewTableBuilder(opt *Options, file WritableFile) *TableBuilder {
@@ -30,7 +33,7 @@ func NewTableBuilder(opt *Options, file WritableFile) *TableBuilder {
   builder.offset = 0
   builder.status = nil
   builder.dataBlock = NewBlockBuilder(&builder.options)
-  builder.indexBlock = NewBlockBuilder(&builder.options)
+  builder.indexBlock = NewBlockBuilder(&builder.indexOptions)
   builder.lastKey = make([]byte, 0, 4)
   builder.numEntries = 0
   builder.closed = false
@@ -60,8 +63,8 @@ func (builder *TableBuilder) Add(key, value []byte) {
     if !builder.dataBlock.Empty() {
       panic("")
     }
-    // TODO: add the line below.
-    // builder.options.Comparator.FindShortestSeparator(builder.lastKey, key)
+    // Index the block by a short key that separates it from the next one.
+    builder.lastKey = builder.options.Comparator.FindShortestSeparator(builder.lastKey, key)
     out := builder.pendingHandle.EncodeTo()
     builder.indexBlock.Add(builder.lastKey, out)
     builder.pendingIndexEntry = false
@@ -110,7 +113,7 @@ func (builder *TableBuilder) Flush() {
 }
 
 func (builder *TableBuilder) Status() error {
-  return nil
+  return builder.status
 }
 
 func (builder *TableBuilder) Finish() error {
@@ -140,6 +143,7 @@ func (builder *TableBuilder) Finish() error {
   // Write index block.
   if builder.status == nil {
     if builder.pendingIndexEntry {
+      builder.lastKey = builder.options.Comparator.FindShortestSuccessor(builder.lastKey)
       out := builder.pendingHandle.EncodeTo()
       builder.indexBlock.Add(builder.lastKey, out)
       builder.pendingIndexEntry = false
@@ -154,11 +158,7 @@ func (builder *TableBuilder) Finish() error {
     footer.SetIndexHandle(&indexBlockHandle)
     out := footer.EncodeTo()
 
-    n, err := builder.file.Write(out)
-    builder.status = err
-    if n != len(out) {
-      panic("")
-    }
+    builder.status = builder.write(out)
     if builder.status == nil {
       builder.offset += uint64(len(out))
     }
@@ -184,13 +184,32 @@ func (builder *TableBuilder) FileSize() int {
 
 func (builder *TableBuilder) writeBlock(b *BlockBuilder, h *BlockHandle) {
   if builder.status != nil {
-    panic(builder.status)
+    return
   }
 
+  // File format contains a sequence of blocks where each block has:
+  //    block_data: uint8[n]
+  //    type: uint8
+  //    crc: uint32
   raw := b.Finish()
-  // TODO: add compression
 
-  builder.writeRawBlock(raw, NoCompression, h)
+  blockContents := raw
+  blockType := builder.options.CompressionType
+  if blockType != NoCompression {
+    var err error
+    if c := GetCompressor(blockType); c == nil {
+      blockType = NoCompression
+    } else if builder.compressedOutput, err = c.Compress(builder.compressedOutput, raw); err == nil &&
+        len(builder.compressedOutput) < len(raw) - len(raw) / 8 {
+      blockContents = builder.compressedOutput
+    } else {
+      // Compressed less than 12.5% (or failed), so just store uncompressed
+      // form
+      blockType = NoCompression
+    }
+  }
+
+  builder.writeRawBlock(blockContents, blockType, h)
   b.Reset()
 }
 
@@ -198,25 +217,27 @@ func (builder *TableBuilder) writeRawBlock(raw []byte, c CompressionType, handle
   handle.SetOffset(builder.offset)
   handle.SetSize(uint64(len(raw)))
 
-  n, err := builder.file.Write(raw)
-  builder.status = err
-  if n != len(raw) {
-    panic("")
-  }
+  builder.status = builder.write(raw)
 
   if builder.status == nil {
     // Write block trailer.
     trailer := make([]byte, BlockTrailerSize)
     trailer[0] = byte(c)
-    checksum := crc32.Checksum(raw, crc32.IEEETable)
-    checksum = crc32.Update(checksum, crc32.IEEETable, trailer[:1])
-    binary.LittleEndian.PutUint32(trailer[1:], checksum)
-    n, builder.status = builder.file.Write(trailer)
-    if n != len(trailer) {
-      panic("")
-    }
+    checksum := crc32c.Value(raw)
+    checksum = crc32c.Extend(checksum, trailer[:1])  // Extend crc to cover block type
+    binary.LittleEndian.PutUint32(trailer[1:], crc32c.Mask(checksum))
+    builder.status = builder.write(trailer)
     if builder.status == nil {
       builder.offset += uint64(len(raw)) + BlockTrailerSize
     }
   }
 }
+
+// Append data to the file, reporting a short write as an error.
+func (builder *TableBuilder) write(data []byte) error {
+  n, err := builder.file.Write(data)
+  if err == nil && n != len(data) {
+    err = IOError(fmt.Sprint("short write: ", n, " of ", len(data), " bytes"))
+  }
+  return err
+}
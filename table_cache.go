@@ -0,0 +1,136 @@
+package leveldb
+
+import (
+  "encoding/binary"
+)
+
+// An open table together with the file it reads from.
+type tableAndFile struct {
+  name string
+  file RandomAccessFile
+  table *Table
+}
+
+// TableCache keeps the tables of a DB open, keyed by file number, so that
+// the footer and index block of a table are only read when it is opened.
+// The number of open tables is bounded by the capacity of the cache; a table
+// and its file are closed once it is evicted and no longer in use.
+type TableCache struct {
+  env Env
+  dbname string
+  options *Options
+  cache Cache
+
+  // Cache values are ids of the open tables in tables.
+  tables *cacheValueMap
+}
+
+// Create a cache that keeps at most entries tables open.
+func NewTableCache(dbname string, options *Options, entries int) *TableCache {
+  tc := &TableCache{}
+  tc.env = options.Env
+  tc.dbname = dbname
+  tc.options = options
+  tc.cache = NewLRUCache(entries)
+  tc.tables = newCacheValueMap()
+  return tc
+}
+
+func tableCacheKey(fileNumber uint64) []byte {
+  key := make([]byte, 8)
+  binary.LittleEndian.PutUint64(key, fileNumber)
+  return key
+}
+
+func (tc *TableCache) deleteEntry(key []byte, value uintptr) {
+  tf := tc.tables.Remove(value).(*tableAndFile)
+  tf.file.Close()
+}
+
+func (tc *TableCache) value(handle CacheHandle) *tableAndFile {
+  return tc.tables.Get(tc.cache.Value(handle)).(*tableAndFile)
+}
+
+// Return a handle to the open table for fileNumber, opening it if needed.
+// The caller must release the handle when done.
+func (tc *TableCache) findTable(fileNumber, fileSize uint64) (CacheHandle, error) {
+  key := tableCacheKey(fileNumber)
+  handle := tc.cache.Lookup(key)
+  if handle != NullCacheHandle {
+    return handle, nil
+  }
+
+  name := TableFileName(tc.dbname, fileNumber)
+  file, err := tc.env.NewRandomAccessFile(name)
+  if err != nil {
+    oldName := SSTTableFileName(tc.dbname, fileNumber)
+    oldFile, oldErr := tc.env.NewRandomAccessFile(oldName)
+    if oldErr != nil {
+      return NullCacheHandle, err
+    }
+    name, file = oldName, oldFile
+  }
+  table, err := NewTable(tc.options, file, fileSize)
+  if err != nil {
+    // We do not cache error results so that if the error is transient,
+    // or somebody repairs the file, we recover automatically.
+    file.Close()
+    return NullCacheHandle, withFile(err, name)
+  }
+
+  id := tc.tables.Add(&tableAndFile{name:name, file:file, table:table})
+  return tc.cache.Insert(key, id, 1, tc.deleteEntry), nil
+}
+
+// Return an iterator for the specified file number (the corresponding file
+// length must be exactly fileSize bytes). The table stays open until the
+// iterator is released.
+func (tc *TableCache) NewIterator(options *ReadOptions, fileNumber, fileSize uint64) Iterator {
+  handle, err := tc.findTable(fileNumber, fileSize)
+  if err != nil {
+    return newEmptyIterator(err)
+  }
+  tf := tc.value(handle)
+  iter := &tableFileIterator{Iterator:tf.table.NewIterator(options), name:tf.name}
+  return newCleanupIterator(iter, func() {
+    tc.cache.Release(handle)
+  })
+}
+
+// An iterator over a table whose errors name the table file.
+type tableFileIterator struct {
+  Iterator
+  name string
+}
+
+func (iter *tableFileIterator) Error() error {
+  return withFile(iter.Iterator.Error(), iter.name)
+}
+
+func (iter *tableFileIterator) Close() error {
+  return withFile(iter.Iterator.Close(), iter.name)
+}
+
+// If a seek to internal key k in the specified file finds an entry, call
+// handleResult with the found key and value.
+func (tc *TableCache) Get(options *ReadOptions, fileNumber, fileSize uint64, k []byte,
+    handleResult func(k, v []byte)) error {
+  handle, err := tc.findTable(fileNumber, fileSize)
+  if err != nil {
+    return err
+  }
+  defer tc.cache.Release(handle)
+
+  tf := tc.value(handle)
+  return withFile(tf.table.InternalGet(options, k, handleResult), tf.name)
+}
+
+// Close all the tables that are not in use.
+func (tc *TableCache) Prune() {
+  tc.cache.Prune()
+}
+
+// Evict any entry for the specified file number.
+func (tc *TableCache) Evict(fileNumber uint64) {
+  tc.cache.Erase(tableCacheKey(fileNumber))
+}
@@ -0,0 +1,192 @@
+package leveldb
+
+import (
+  "fmt"
+  "os"
+  "sync"
+  "testing"
+)
+
+// Env that counts the random access files that are currently open.
+type countingEnv struct {
+  Env
+  mu sync.Mutex
+  open int
+}
+
+type countingRandomAccessFile struct {
+  RandomAccessFile
+  env *countingEnv
+}
+
+func (f *countingRandomAccessFile) Close() error {
+  f.env.mu.Lock()
+  f.env.open--
+  f.env.mu.Unlock()
+  return f.RandomAccessFile.Close()
+}
+
+func (env *countingEnv) NewRandomAccessFile(filename string) (RandomAccessFile, error) {
+  file, err := env.Env.NewRandomAccessFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  env.mu.Lock()
+  env.open++
+  env.mu.Unlock()
+  return &countingRandomAccessFile{RandomAccessFile:file, env:env}, nil
+}
+
+func (env *countingEnv) numOpen() int {
+  env.mu.Lock()
+  defer env.mu.Unlock()
+  return env.open
+}
+
+type tableCacheTest struct {
+  dbname string
+  env *countingEnv
+  options *Options
+  sizes []uint64
+}
+
+// Write n tables; table i holds the internal keys "i-0" .. "i-9".
+func newTableCacheTest(t *testing.T, n int) *tableCacheTest {
+  test := &tableCacheTest{}
+  test.dbname = newTestDBName()
+  test.env = &countingEnv{Env:DefaultEnv()}
+  test.options = defaultOptions()
+  icmp := NewInternalKeyComparator(DefaultComparator)
+  test.options.Comparator = &icmp
+  test.options.FilterPolicy = nil
+  test.options.Env = test.env
+  if err := test.env.CreateDir(test.dbname); err != nil {
+    t.Fatal("Cannot create directory: ", err)
+  }
+
+  for i := 0; i < n; i++ {
+    file, err := test.env.NewWritableFile(TableFileName(test.dbname, uint64(i)))
+    if err != nil {
+      t.Fatal("Cannot create table file: ", err)
+    }
+    builder := NewTableBuilder(test.options, file)
+    for j := 0; j < 10; j++ {
+      builder.Add(AppendInternalKey(nil, []byte(fmt.Sprint(i, "-", j)), 1, TypeValue), []byte(fmt.Sprint("v", j)))
+    }
+    if err := builder.Finish(); err != nil {
+      t.Fatal("Cannot build table: ", err)
+    }
+    file.Close()
+    test.sizes = append(test.sizes, uint64(builder.FileSize()))
+  }
+  return test
+}
+
+func (test *tableCacheTest) get(tc *TableCache, number int, key string) (string, error) {
+  result := ""
+  lkey := NewLookupKey([]byte(key), MaxSequenceNumber)
+  err := tc.Get(&ReadOptions{}, uint64(number), test.sizes[number], lkey.InternalKey(), func(k, v []byte) {
+    if string(ExtractUserKey(k)) == key {
+      result = string(v)
+    }
+  })
+  return result, err
+}
+
+func TestTableCacheGet(t *testing.T) {
+  test := newTableCacheTest(t, 2)
+  defer os.RemoveAll(test.dbname)
+  tc := NewTableCache(test.dbname, test.options, 16)
+
+  if v, err := test.get(tc, 1, "1-3"); err != nil || v != "v3" {
+    t.Error("Unexpected value: ", v, " ", err)
+  }
+  if v, err := test.get(tc, 1, "1-31"); err != nil || v != "" {
+    t.Error("Missing key should not be found: ", v, " ", err)
+  }
+  if err := tc.Get(&ReadOptions{}, 7, 100, []byte("12345678"), func(k, v []byte) {}); err == nil {
+    t.Error("Missing table should return an error.")
+  }
+
+  iter := tc.NewIterator(&ReadOptions{}, 0, test.sizes[0])
+  count := 0
+  for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+    count++
+  }
+  iter.Close()
+  if count != 10 {
+    t.Error("Unexpected number of entries: ", count)
+  }
+
+  tc.Prune()
+  if test.env.numOpen() != 0 {
+    t.Error("Pruned tables should be closed: ", test.env.numOpen())
+  }
+}
+
+func TestTableCacheBoundsOpenFiles(t *testing.T) {
+  test := newTableCacheTest(t, 100)
+  defer os.RemoveAll(test.dbname)
+  entries := 16
+  tc := NewTableCache(test.dbname, test.options, entries)
+
+  for round := 0; round < 2; round++ {
+    for i := 0; i < 100; i++ {
+      if v, err := test.get(tc, i, fmt.Sprint(i, "-5")); err != nil || v != "v5" {
+        t.Fatal("Unexpected value: ", v, " ", err)
+      }
+      if test.env.numOpen() > entries {
+        t.Fatal("Too many open files: ", test.env.numOpen())
+      }
+    }
+  }
+}
+
+func TestTableCacheIteratorPinsTable(t *testing.T) {
+  test := newTableCacheTest(t, 1)
+  defer os.RemoveAll(test.dbname)
+  tc := NewTableCache(test.dbname, test.options, 16)
+
+  iter := tc.NewIterator(&ReadOptions{}, 0, test.sizes[0])
+  tc.Evict(0)
+  if test.env.numOpen() != 1 {
+    t.Fatal("Table in use should stay open: ", test.env.numOpen())
+  }
+  iter.SeekToFirst()
+  if !iter.Valid() || string(ExtractUserKey(iter.Key())) != "0-0" {
+    t.Error("Evicted table should still be readable.")
+  }
+  iter.Close()
+  if test.env.numOpen() != 0 {
+    t.Error("Evicted table should be closed once released: ", test.env.numOpen())
+  }
+}
+
+func TestTableCacheMergeIteratorClose(t *testing.T) {
+  test := newTableCacheTest(t, 3)
+  defer os.RemoveAll(test.dbname)
+  tc := NewTableCache(test.dbname, test.options, 16)
+
+  children := []Iterator{}
+  for i := 0; i < 3; i++ {
+    children = append(children, tc.NewIterator(&ReadOptions{}, uint64(i), test.sizes[i]))
+    tc.Evict(uint64(i))
+  }
+  iter := NewMergeIterator(test.options.Comparator, children)
+  count := 0
+  for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+    count++
+  }
+  if count != 30 || iter.Error() != nil {
+    t.Error("Unexpected result: ", count, " ", iter.Error())
+  }
+  if test.env.numOpen() != 3 {
+    t.Fatal("Tables in use should stay open: ", test.env.numOpen())
+  }
+  if err := iter.Close(); err != nil {
+    t.Error("Unexpected error: ", err)
+  }
+  if test.env.numOpen() != 0 {
+    t.Error("Closing the merging iterator should close the tables: ", test.env.numOpen())
+  }
+}
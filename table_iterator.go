@@ -11,6 +11,7 @@ type tableIterator struct {
   dataIter Iterator
   reader blockReader
   options ReadOptions
+  status error  // Error of a data iterator that has been replaced
 }
 
 func newTableIterator(indexIter Iterator, reader blockReader, options *ReadOptions) Iterator {
@@ -87,7 +88,7 @@ func (iter *tableIterator) Value() []byte {
 func (iter *tableIterator) skipEmptyDataBlocksForward() {
   for iter.dataIter == nil || !iter.dataIter.Valid() {
     if !iter.indexIter.Valid() {
-      iter.dataIter = nil
+      iter.setDataIterator(nil)
       return
     }
     iter.indexIter.Next()
@@ -101,7 +102,7 @@ func (iter *tableIterator) skipEmptyDataBlocksForward() {
 func (iter *tableIterator) skipEmptyDataBlocksBackward() {
   for iter.dataIter == nil || !iter.dataIter.Valid() {
     if !iter.indexIter.Valid() {
-      iter.dataIter = nil
+      iter.setDataIterator(nil)
       return
     }
     iter.indexIter.Prev()
@@ -112,15 +113,53 @@ func (iter *tableIterator) skipEmptyDataBlocksBackward() {
   }
 }
 
+func (iter *tableIterator) Close() error {
+  if err := iter.Error(); err != nil && iter.status == nil {
+    iter.status = err
+  }
+  iter.setDataIterator(nil)
+  if err := iter.indexIter.Close(); err != nil && iter.status == nil {
+    iter.status = err
+  }
+  return iter.status
+}
+
+// Return the first error of the index iterator or of a data iterator.
+func (iter *tableIterator) Error() error {
+  if err := iter.indexIter.Error(); err != nil {
+    return err
+  }
+  if iter.dataIter != nil {
+    if err := iter.dataIter.Error(); err != nil {
+      return err
+    }
+  }
+  return iter.status
+}
+
+func (iter *tableIterator) setDataIterator(dataIter Iterator) {
+  if iter.dataIter != nil {
+    // Keep the error of the old data iterator even if its Close() drops it.
+    if err := iter.dataIter.Error(); err != nil && iter.status == nil {
+      iter.status = err
+    }
+    if err := iter.dataIter.Close(); err != nil && iter.status == nil {
+      iter.status = err
+    }
+  }
+  iter.dataIter = dataIter
+}
+
 func (iter *tableIterator) initDataBlock() {
   if !iter.indexIter.Valid() {
-    iter.dataIter = nil
+    iter.setDataIterator(nil)
   } else {
-    iter.dataIter = iter.reader(&(iter.options), iter.indexIter.Value())
+    iter.setDataIterator(iter.reader(&(iter.options), iter.indexIter.Value()))
   }
 }
 
-// Error iterator created on error.
+// Iterator over nothing, created on error. It stays invalid, so moving it or
+// reading its entry does nothing.
 type emptyIterator struct {
   status error
 }
@@ -146,19 +185,23 @@ func (iter *emptyIterator) Seek(key []byte) {
 }
 
 func (iter *emptyIterator) Next() {
-  panic("")
 }
 
 func (iter *emptyIterator) Prev() {
-  panic("")
 }
 
 func (iter *emptyIterator) Key() []byte {
-  panic("")
   return nil
 }
 
 func (iter *emptyIterator) Value() []byte {
-  panic("")
   return nil
 }
+
+func (iter *emptyIterator) Error() error {
+  return iter.status
+}
+
+func (iter *emptyIterator) Close() error {
+  return iter.status
+}
@@ -116,6 +116,28 @@ func (iter *mergeIterator) Value() []byte {
   return iter.current.Value()
 }
 
+// Return the first error of any of the children.
+func (iter *mergeIterator) Error() error {
+  for _, child := range(iter.children) {
+    if err := child.Error(); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// Close all the children and return the first error of any of them.
+func (iter *mergeIterator) Close() error {
+  var status error
+  for i := 0; i < len(iter.children); i++ {
+    if err := iter.children[i].Close(); err != nil && status == nil {
+      status = err
+    }
+  }
+  iter.current = nil
+  return status
+}
+
 func (iter *mergeIterator) findSmallest() {
   var smallest Iterator = nil
   for i := 0; i < len(iter.children); i++ {
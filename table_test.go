@@ -1,15 +1,19 @@
 package leveldb
 
 import (
+  "bytes"
+  "errors"
   "fmt"
   "math/rand"
+  "os"
+  "strings"
   "testing"
   "time"
 )
 
 func TestTableBuilder(t *testing.T) {
   fileName := fmt.Sprint(BaseFileName, "-", time.Now().UnixNano())
-  env := DefaultEnv()
+  env := NewMemEnv()
   file, err := env.NewWritableFile(fileName)
   if err != nil {
     panic("Cannot create new sstable file.")
@@ -39,7 +43,7 @@ func TestTableBuilder(t *testing.T) {
 
 func TestTable(t *testing.T) {
   fileName := "/tmp/table_builder_test_sstable"
-  env := DefaultEnv()
+  env := NewMemEnv()
   writeFile, err := env.NewWritableFile(fileName)
   if err != nil {
     panic("Cannot create new sstable file.")
@@ -88,7 +92,7 @@ func TestTable(t *testing.T) {
 
 func TestTableIterator(t *testing.T) {
   fileName := fmt.Sprint(BaseFileName, "-", time.Now().UnixNano())
-  env := DefaultEnv()
+  env := NewMemEnv()
   writeFile, err := env.NewWritableFile(fileName)
   if err != nil {
     panic("Cannot create new sstable file.")
@@ -165,10 +169,434 @@ func TestTableIterator(t *testing.T) {
   }
 }
 
+// RandomAccessFile that counts the reads issued against it.
+type readCountingFile struct {
+  RandomAccessFile
+  reads int
+}
+
+func (f *readCountingFile) ReadAt(b []byte, off int64) (int, error) {
+  f.reads++
+  return f.RandomAccessFile.ReadAt(b, off)
+}
+
+func TestTableBlockCache(t *testing.T) {
+  fileName := fmt.Sprint(BaseFileName, "-", time.Now().UnixNano())
+  env := NewMemEnv()
+  writeFile, err := env.NewWritableFile(fileName)
+  if err != nil {
+    panic("Cannot create new sstable file.")
+  }
+  defer env.DeleteFile(fileName)
+
+  s := NewSkipList(DefaultComparator, NewArena())
+  for i := 0; i < N; i++ {
+    s.Insert([]byte(fmt.Sprint(i)))
+  }
+  sIter := s.NewIterator()
+  builder := NewTableBuilder(defaultOptions(), writeFile)
+  for sIter.SeekToFirst(); sIter.Valid(); sIter.Next() {
+    builder.Add(sIter.Key(), sIter.Key())
+  }
+  if err := builder.Finish(); err != nil {
+    t.Fatal("SSTable build failed: ", err)
+  }
+  writeFile.Close()
+
+  fileSize, err := env.GetFileSize(fileName)
+  readFile, err := env.NewRandomAccessFile(fileName)
+  if err != nil {
+    panic("Cannot open sstable file.")
+  }
+  defer readFile.Close()
+  file := &readCountingFile{RandomAccessFile:readFile}
+
+  options := defaultOptions()
+  options.BlockCache = NewLRUCache(1 << 20)
+  table, err := NewTable(options, file, fileSize)
+  if err != nil {
+    t.Fatal("Cannot open sstable file: ", err)
+  }
+
+  seek := func(readOptions *ReadOptions, key string) int {
+    reads := file.reads
+    iter := table.NewIterator(readOptions)
+    iter.Seek([]byte(key))
+    if !iter.Valid() || string(iter.Key()) != key {
+      t.Error("Key not found: ", key)
+    }
+    iter.Close()
+    return file.reads - reads
+  }
+
+  // Without FillCache the block is read on every lookup.
+  if seek(&ReadOptions{}, "1000") != 1 || seek(&ReadOptions{}, "1000") != 1 {
+    t.Error("Expected a block read per lookup.")
+  }
+  if options.BlockCache.TotalCharge() != 0 {
+    t.Error("Blocks should not be cached: ", options.BlockCache.TotalCharge())
+  }
+
+  // With FillCache only the first lookup reads the block.
+  if seek(&ReadOptions{FillCache:true}, "1000") != 1 {
+    t.Error("Expected the block to be read.")
+  }
+  if seek(&ReadOptions{FillCache:true}, "1000") != 0 || seek(&ReadOptions{}, "1000") != 0 {
+    t.Error("Expected the block to be served from the cache.")
+  }
+  if options.BlockCache.TotalCharge() == 0 {
+    t.Error("Block should be cached.")
+  }
+
+  // An open iterator pins its current block until it is closed.
+  iter := table.NewIterator(&ReadOptions{FillCache:true})
+  iter.Seek([]byte("1000"))
+  options.BlockCache.Prune()
+  if options.BlockCache.TotalCharge() == 0 {
+    t.Error("Block in use should not be pruned.")
+  }
+  if err := iter.Close(); err != nil {
+    t.Error("Unexpected error: ", err)
+  }
+
+  // All handles have been released, so every block can be dropped.
+  options.BlockCache.Prune()
+  if options.BlockCache.TotalCharge() != 0 {
+    t.Error("Released blocks should be pruned: ", options.BlockCache.TotalCharge())
+  }
+}
+
+func TestTableInternalGet(t *testing.T) {
+  fileName := fmt.Sprint(BaseFileName, "-", time.Now().UnixNano())
+  env := NewMemEnv()
+  writeFile, err := env.NewWritableFile(fileName)
+  if err != nil {
+    panic("Cannot create new sstable file.")
+  }
+  defer env.DeleteFile(fileName)
+
+  options := defaultOptions()
+  options.FilterPolicy = NewBloomFilter(10)
+  s := NewSkipList(DefaultComparator, NewArena())
+  for i := 0; i < N; i++ {
+    s.Insert([]byte(fmt.Sprint(i)))
+  }
+  sIter := s.NewIterator()
+  builder := NewTableBuilder(options, writeFile)
+  for sIter.SeekToFirst(); sIter.Valid(); sIter.Next() {
+    builder.Add(sIter.Key(), sIter.Key())
+  }
+  if err := builder.Finish(); err != nil {
+    t.Fatal("SSTable build failed: ", err)
+  }
+  writeFile.Close()
+
+  fileSize, err := env.GetFileSize(fileName)
+  readFile, err := env.NewRandomAccessFile(fileName)
+  if err != nil {
+    panic("Cannot open sstable file.")
+  }
+  defer readFile.Close()
+  file := &readCountingFile{RandomAccessFile:readFile}
+
+  table, err := NewTable(options, file, fileSize)
+  if err != nil {
+    t.Fatal("Cannot open sstable file: ", err)
+  }
+  if table.filterBlockReader == nil {
+    t.Fatal("Filter block should be read when the table is opened.")
+  }
+
+  get := func(key string) (string, bool) {
+    value := ""
+    found := false
+    err := table.InternalGet(&ReadOptions{}, []byte(key), func(k, v []byte) {
+      if string(k) == key {
+        value = string(v)
+        found = true
+      }
+    })
+    if err != nil {
+      t.Fatal("InternalGet failed: ", err)
+    }
+    return value, found
+  }
+
+  for i := 0; i < N; i++ {
+    key := fmt.Sprint(i)
+    if value, found := get(key); !found || value != key {
+      t.Fatal("Key not found: ", key)
+    }
+  }
+
+  // Absent keys are almost always ruled out by the filter.
+  reads := file.reads
+  for i := 0; i < N; i++ {
+    if _, found := get(fmt.Sprint(i, ".5")); found {
+      t.Fatal("Absent key found: ", i)
+    }
+  }
+  if file.reads - reads > N / 20 {
+    t.Error("Too many block reads for absent keys: ", file.reads - reads)
+  }
+}
+
+// Build a table of compressible entries with options, check that it reads
+// back intact and return its size.
+func buildCompressibleTable(t *testing.T, options *Options) uint64 {
+  env := NewMemEnv()
+  fileName := fmt.Sprint(BaseFileName, "-", time.Now().UnixNano())
+  defer env.DeleteFile(fileName)
+  writeFile, err := env.NewWritableFile(fileName)
+  if err != nil {
+    panic("Cannot create new sstable file.")
+  }
+
+  builder := NewTableBuilder(options, writeFile)
+  for i := 0; i < N; i++ {
+    key := fmt.Sprintf("%06d", i)
+    builder.Add([]byte(key), []byte(strings.Repeat(key, 10)))
+  }
+  if err := builder.Finish(); err != nil {
+    t.Fatal("SSTable build failed: ", err)
+  }
+  writeFile.Close()
+
+  fileSize, err := env.GetFileSize(fileName)
+  readFile, err := env.NewRandomAccessFile(fileName)
+  if err != nil {
+    panic("Cannot open sstable file.")
+  }
+  defer readFile.Close()
+  table, err := NewTable(options, readFile, fileSize)
+  if err != nil {
+    t.Fatal("Cannot open sstable file: ", err)
+  }
+  iter := table.NewIterator(&ReadOptions{VerifyChecksums:true})
+  i := 0
+  for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+    key := fmt.Sprintf("%06d", i)
+    if string(iter.Key()) != key || string(iter.Value()) != strings.Repeat(key, 10) {
+      t.Fatal("Unexpected entry: ", string(iter.Key()))
+    }
+    i++
+  }
+  iter.Close()
+  if i != N {
+    t.Error("Iter didn't iterate all keys: ", i)
+  }
+  return fileSize
+}
+
+func TestTableSnappyCompression(t *testing.T) {
+  options := defaultOptions()
+  options.CompressionType = NoCompression
+  uncompressed := buildCompressibleTable(t, options)
+  options.CompressionType = SnappyCompression
+  compressed := buildCompressibleTable(t, options)
+  if compressed > uncompressed / 2 {
+    t.Error("Snappy should shrink the table: ", compressed, " vs ", uncompressed)
+  }
+}
+
+// The fixtures in testdata follow the on-disk format of C++ LevelDB: 300
+// entries "key%05d" -> "value%05d" repeated 1 + i % 4 times, written with the
+// bytewise comparator, a restart interval of 16 and no compression.
+// table.ldb uses 4KB blocks and no filter; table_bloom.ldb uses 1KB blocks
+// and a 10 bits per key bloom filter.
+func goldenEntry(i int) (string, string) {
+  key := fmt.Sprintf("key%05d", i)
+  return key, strings.Repeat(fmt.Sprintf("value%05d", i), 1 + i % 4)
+}
+
+func goldenOptions(blockSize int, filterPolicy FilterPolicy) *Options {
+  options := defaultOptions()
+  options.BlockSize = blockSize
+  options.FilterPolicy = filterPolicy
+  return options
+}
+
+func TestTableGoldenRead(t *testing.T) {
+  for _, golden := range([]struct{
+    name string
+    options *Options
+  }{
+    {"testdata/table.ldb", goldenOptions(4096, nil)},
+    {"testdata/table_bloom.ldb", goldenOptions(1024, NewBloomFilter(10))},
+  }) {
+    env := DefaultEnv()
+    fileSize, err := env.GetFileSize(golden.name)
+    if err != nil {
+      t.Fatal("Missing fixture: ", err)
+    }
+    file, err := env.NewRandomAccessFile(golden.name)
+    if err != nil {
+      t.Fatal("Cannot open fixture: ", err)
+    }
+    defer file.Close()
+    table, err := NewTable(golden.options, file, fileSize)
+    if err != nil {
+      t.Fatal("Cannot open ", golden.name, ": ", err)
+    }
+
+    iter := table.NewIterator(&ReadOptions{VerifyChecksums:true})
+    i := 0
+    for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+      key, value := goldenEntry(i)
+      if string(iter.Key()) != key || string(iter.Value()) != value {
+        t.Fatal(golden.name, ": unexpected entry ", string(iter.Key()), " ", string(iter.Value()))
+      }
+      i++
+    }
+    iter.Close()
+    if i != 300 {
+      t.Error(golden.name, ": unexpected number of entries: ", i)
+    }
+
+    for _, i := range([]int{0, 123, 299}) {
+      key, value := goldenEntry(i)
+      found := ""
+      err := table.InternalGet(&ReadOptions{VerifyChecksums:true}, []byte(key), func(k, v []byte) {
+        found = string(v)
+      })
+      if err != nil || found != value {
+        t.Error(golden.name, ": Get(", key, ") = ", found, " ", err)
+      }
+    }
+    if golden.options.FilterPolicy != nil {
+      if table.filterBlockReader == nil {
+        t.Fatal(golden.name, ": filter block not found.")
+      }
+      if table.filterBlockReader.MayContain(0, []byte("key00000")) == false {
+        t.Error(golden.name, ": filter should match keys of the first block.")
+      }
+    }
+  }
+}
+
+func TestTableGoldenWrite(t *testing.T) {
+  for _, golden := range([]struct{
+    name string
+    options *Options
+  }{
+    {"testdata/table.ldb", goldenOptions(4096, nil)},
+    {"testdata/table_bloom.ldb", goldenOptions(1024, NewBloomFilter(10))},
+  }) {
+    env := NewMemEnv()
+    fileName := fmt.Sprint(BaseFileName, "-", time.Now().UnixNano())
+    file, err := env.NewWritableFile(fileName)
+    if err != nil {
+      panic("Cannot create new sstable file.")
+    }
+    builder := NewTableBuilder(golden.options, file)
+    for i := 0; i < 300; i++ {
+      key, value := goldenEntry(i)
+      builder.Add([]byte(key), []byte(value))
+    }
+    if err := builder.Finish(); err != nil {
+      t.Fatal("SSTable build failed: ", err)
+    }
+    file.Close()
+
+    expected, err := os.ReadFile(golden.name)
+    if err != nil {
+      t.Fatal("Missing fixture: ", err)
+    }
+    actual, err := readFileToString(env, fileName)
+    env.DeleteFile(fileName)
+    if err != nil {
+      t.Fatal("Cannot read table: ", err)
+    }
+    if !bytes.Equal(actual, expected) {
+      t.Error(golden.name, ": table differs from fixture: ", len(actual), " vs ", len(expected), " bytes")
+    }
+  }
+}
+
+func TestTableCorruption(t *testing.T) {
+  data, err := os.ReadFile("testdata/table.ldb")
+  if err != nil {
+    t.Fatal("Missing fixture: ", err)
+  }
+  // Flip a bit in the first data block.
+  data[10] ^= 0x1
+  fileName := fmt.Sprint(BaseFileName, "-", time.Now().UnixNano())
+  env := NewMemEnv()
+  if err := writeStringToFile(env, data, fileName, false); err != nil {
+    t.Fatal("Cannot write table: ", err)
+  }
+  defer env.DeleteFile(fileName)
+  file, err := env.NewRandomAccessFile(fileName)
+  if err != nil {
+    t.Fatal("Cannot open table: ", err)
+  }
+  defer file.Close()
+  table, err := NewTable(goldenOptions(4096, nil), file, uint64(len(data)))
+  if err != nil {
+    t.Fatal("Cannot open table: ", err)
+  }
+
+  readOptions := &ReadOptions{VerifyChecksums:true}
+  err = table.InternalGet(readOptions, []byte("key00000"), func(k, v []byte) {
+    t.Error("Corrupted block should not yield entries.")
+  })
+  var s *Status
+  if !errors.As(err, &s) || s.Code() != StatusCorruption || s.Offset != 0 {
+    t.Error("Checksum mismatch should be reported: ", err)
+  }
+
+  // The iterator skips the corrupted block and reports the error.
+  iter := table.NewIterator(readOptions)
+  iter.SeekToFirst()
+  if !iter.Valid() || string(iter.Key()) == "key00000" {
+    t.Error("Iterator should skip the corrupted block.")
+  }
+  if !errors.Is(iter.Error(), ErrCorruption) {
+    t.Error("Iterator should report the corruption: ", iter.Error())
+  }
+  iter.Close()
+
+  if _, err := NewTable(goldenOptions(4096, nil), file, 10); !errors.Is(err, ErrCorruption) {
+    t.Error("Short file should be reported as corrupted: ", err)
+  }
+}
+
+func TestTableBadBlockHandle(t *testing.T) {
+  env := NewMemEnv()
+  data := make([]byte, 100)
+  for _, handle := range([]BlockHandle{
+    {offset:0, size:1 << 40},
+    {offset:0, size:^uint64(0)},  // Negative as an int
+    {offset:200, size:10},
+    {offset:90, size:10},  // No room for the trailer
+    {offset:^uint64(0), size:10},
+  }) {
+    var footer Footer
+    footer.SetMetaIndexHandle(&BlockHandle{})
+    footer.SetIndexHandle(&handle)
+    fileName := fmt.Sprint(BaseFileName, "-", time.Now().UnixNano())
+    contents := append(append([]byte(nil), data...), footer.EncodeTo()...)
+    if err := writeStringToFile(env, contents, fileName, false); err != nil {
+      t.Fatal("Cannot write table: ", err)
+    }
+    file, err := env.NewRandomAccessFile(fileName)
+    if err != nil {
+      t.Fatal("Cannot open table: ", err)
+    }
+    if _, err := NewTable(goldenOptions(4096, nil), file, uint64(len(contents))); !errors.Is(err, ErrCorruption) {
+      t.Error("Bad block handle should be reported as corrupted: ", handle, " ", err)
+    }
+    if _, err := ReadBlock(file, &ReadOptions{}, &handle); handle.size > 1 << 31 && !errors.Is(err, ErrCorruption) {
+      t.Error("Huge block handle should be reported as corrupted: ", handle, " ", err)
+    }
+    file.Close()
+  }
+}
+
 func TestMergeIterator(t *testing.T) {
   fileName1 := fmt.Sprint(BaseFileName, "-", time.Now().UnixNano())
   fileName2 := fmt.Sprint(BaseFileName, "-", time.Now().UnixNano() + 100)
-  env := DefaultEnv()
+  env := NewMemEnv()
   writeFile1, err := env.NewWritableFile(fileName1)
   if err != nil {
     panic("")
@@ -0,0 +1,353 @@
+package leveldb
+
+import (
+  "encoding/binary"
+  "fmt"
+  "sort"
+)
+
+const NumLevels = 7
+
+// Metadata of an sstable file owned by the DB.
+type FileMetaData struct {
+  Number uint64
+  FileSize uint64
+  Smallest []byte  // Smallest internal key served by the table
+  Largest []byte  // Largest internal key served by the table
+}
+
+// Tag numbers for serialized VersionEdit. These numbers are written to disk
+// and should not be changed.
+const (
+  tagComparator = 1
+  tagLogNumber = 2
+  tagNextFileNumber = 3
+  tagLastSequence = 4
+  tagCompactPointer = 5
+  tagDeletedFile = 6
+  tagNewFile = 7
+  // 8 was used for large value refs
+  tagPrevLogNumber = 9
+)
+
+type levelFile struct {
+  level int
+  number uint64
+}
+
+type levelKey struct {
+  level int
+  key []byte
+}
+
+type newFile struct {
+  level int
+  meta FileMetaData
+}
+
+// A change to the set of files of a version, persisted in the MANIFEST.
+type VersionEdit struct {
+  comparator string
+  logNumber uint64
+  prevLogNumber uint64
+  nextFileNumber uint64
+  lastSequence SequenceNumber
+  hasComparator bool
+  hasLogNumber bool
+  hasPrevLogNumber bool
+  hasNextFileNumber bool
+  hasLastSequence bool
+
+  compactPointers []levelKey
+  deletedFiles map[levelFile]bool
+  newFiles []newFile
+}
+
+func NewVersionEdit() *VersionEdit {
+  edit := &VersionEdit{}
+  edit.Clear()
+  return edit
+}
+
+func (edit *VersionEdit) Clear() {
+  edit.comparator = ""
+  edit.logNumber = 0
+  edit.prevLogNumber = 0
+  edit.nextFileNumber = 0
+  edit.lastSequence = 0
+  edit.hasComparator = false
+  edit.hasLogNumber = false
+  edit.hasPrevLogNumber = false
+  edit.hasNextFileNumber = false
+  edit.hasLastSequence = false
+  edit.compactPointers = nil
+  edit.deletedFiles = make(map[levelFile]bool)
+  edit.newFiles = nil
+}
+
+func (edit *VersionEdit) SetComparatorName(name string) {
+  edit.hasComparator = true
+  edit.comparator = name
+}
+
+func (edit *VersionEdit) SetLogNumber(number uint64) {
+  edit.hasLogNumber = true
+  edit.logNumber = number
+}
+
+func (edit *VersionEdit) SetPrevLogNumber(number uint64) {
+  edit.hasPrevLogNumber = true
+  edit.prevLogNumber = number
+}
+
+func (edit *VersionEdit) SetNextFile(number uint64) {
+  edit.hasNextFileNumber = true
+  edit.nextFileNumber = number
+}
+
+func (edit *VersionEdit) SetLastSequence(seq SequenceNumber) {
+  edit.hasLastSequence = true
+  edit.lastSequence = seq
+}
+
+func (edit *VersionEdit) SetCompactPointer(level int, key []byte) {
+  edit.compactPointers = append(edit.compactPointers, levelKey{level, append([]byte(nil), key...)})
+}
+
+// Add the specified file at the specified level.
+// REQUIRES: This version has not been saved (see VersionSet.SaveTo)
+// REQUIRES: "smallest" and "largest" are smallest and largest keys in file
+func (edit *VersionEdit) AddFile(level int, number uint64, fileSize uint64, smallest, largest []byte) {
+  f := FileMetaData{}
+  f.Number = number
+  f.FileSize = fileSize
+  f.Smallest = append([]byte(nil), smallest...)
+  f.Largest = append([]byte(nil), largest...)
+  edit.newFiles = append(edit.newFiles, newFile{level, f})
+}
+
+// Delete the specified "file" from the specified "level".
+func (edit *VersionEdit) DeleteFile(level int, number uint64) {
+  if edit.deletedFiles == nil {
+    edit.deletedFiles = make(map[levelFile]bool)
+  }
+  edit.deletedFiles[levelFile{level, number}] = true
+}
+
+func (edit *VersionEdit) EncodeTo() []byte {
+  dst := make([]byte, 0, 64)
+  if edit.hasComparator {
+    dst = appendUvarint(dst, tagComparator)
+    dst = appendLengthPrefixedSlice(dst, []byte(edit.comparator))
+  }
+  if edit.hasLogNumber {
+    dst = appendUvarint(dst, tagLogNumber)
+    dst = appendUvarint(dst, edit.logNumber)
+  }
+  if edit.hasPrevLogNumber {
+    dst = appendUvarint(dst, tagPrevLogNumber)
+    dst = appendUvarint(dst, edit.prevLogNumber)
+  }
+  if edit.hasNextFileNumber {
+    dst = appendUvarint(dst, tagNextFileNumber)
+    dst = appendUvarint(dst, edit.nextFileNumber)
+  }
+  if edit.hasLastSequence {
+    dst = appendUvarint(dst, tagLastSequence)
+    dst = appendUvarint(dst, uint64(edit.lastSequence))
+  }
+
+  for _, p := range(edit.compactPointers) {
+    dst = appendUvarint(dst, tagCompactPointer)
+    dst = appendUvarint(dst, uint64(p.level))
+    dst = appendLengthPrefixedSlice(dst, p.key)
+  }
+
+  for _, d := range(edit.sortedDeletedFiles()) {
+    dst = appendUvarint(dst, tagDeletedFile)
+    dst = appendUvarint(dst, uint64(d.level))
+    dst = appendUvarint(dst, d.number)
+  }
+
+  for _, n := range(edit.newFiles) {
+    dst = appendUvarint(dst, tagNewFile)
+    dst = appendUvarint(dst, uint64(n.level))
+    dst = appendUvarint(dst, n.meta.Number)
+    dst = appendUvarint(dst, n.meta.FileSize)
+    dst = appendLengthPrefixedSlice(dst, n.meta.Smallest)
+    dst = appendLengthPrefixedSlice(dst, n.meta.Largest)
+  }
+  return dst
+}
+
+func (edit *VersionEdit) DecodeFrom(src []byte) error {
+  edit.Clear()
+  input := src
+  var msg string
+
+  for msg == "" && len(input) > 0 {
+    tag, ok := consumeUvarint(&input)
+    if !ok {
+      break
+    }
+    switch tag {
+    case tagComparator:
+      if s, ok := consumeSlice(&input); ok {
+        edit.SetComparatorName(string(s))
+      } else {
+        msg = "comparator name"
+      }
+
+    case tagLogNumber:
+      if n, ok := consumeUvarint(&input); ok {
+        edit.SetLogNumber(n)
+      } else {
+        msg = "log number"
+      }
+
+    case tagPrevLogNumber:
+      if n, ok := consumeUvarint(&input); ok {
+        edit.SetPrevLogNumber(n)
+      } else {
+        msg = "previous log number"
+      }
+
+    case tagNextFileNumber:
+      if n, ok := consumeUvarint(&input); ok {
+        edit.SetNextFile(n)
+      } else {
+        msg = "next file number"
+      }
+
+    case tagLastSequence:
+      if n, ok := consumeUvarint(&input); ok {
+        edit.SetLastSequence(SequenceNumber(n))
+      } else {
+        msg = "last sequence number"
+      }
+
+    case tagCompactPointer:
+      level, ok1 := consumeLevel(&input)
+      key, ok2 := consumeInternalKey(&input)
+      if ok1 && ok2 {
+        edit.SetCompactPointer(level, key)
+      } else {
+        msg = "compaction pointer"
+      }
+
+    case tagDeletedFile:
+      level, ok1 := consumeLevel(&input)
+      number, ok2 := consumeUvarint(&input)
+      if ok1 && ok2 {
+        edit.DeleteFile(level, number)
+      } else {
+        msg = "deleted file"
+      }
+
+    case tagNewFile:
+      level, ok1 := consumeLevel(&input)
+      number, ok2 := consumeUvarint(&input)
+      fileSize, ok3 := consumeUvarint(&input)
+      smallest, ok4 := consumeInternalKey(&input)
+      largest, ok5 := consumeInternalKey(&input)
+      if ok1 && ok2 && ok3 && ok4 && ok5 {
+        edit.AddFile(level, number, fileSize, smallest, largest)
+      } else {
+        msg = "new-file entry"
+      }
+
+    default:
+      msg = "unknown tag"
+    }
+  }
+
+  if msg == "" && len(input) != 0 {
+    msg = "invalid tag"
+  }
+  if msg != "" {
+    return CorruptionError(fmt.Sprint("VersionEdit: ", msg))
+  }
+  return nil
+}
+
+func (edit *VersionEdit) String() string {
+  r := "VersionEdit {"
+  if edit.hasComparator {
+    r += "\n  Comparator: " + edit.comparator
+  }
+  if edit.hasLogNumber {
+    r += fmt.Sprint("\n  LogNumber: ", edit.logNumber)
+  }
+  if edit.hasPrevLogNumber {
+    r += fmt.Sprint("\n  PrevLogNumber: ", edit.prevLogNumber)
+  }
+  if edit.hasNextFileNumber {
+    r += fmt.Sprint("\n  NextFile: ", edit.nextFileNumber)
+  }
+  if edit.hasLastSequence {
+    r += fmt.Sprint("\n  LastSeq: ", edit.lastSequence)
+  }
+  for _, p := range(edit.compactPointers) {
+    r += fmt.Sprintf("\n  CompactPointer: %d %q", p.level, p.key)
+  }
+  for _, d := range(edit.sortedDeletedFiles()) {
+    r += fmt.Sprint("\n  RemoveFile: ", d.level, " ", d.number)
+  }
+  for _, n := range(edit.newFiles) {
+    r += fmt.Sprintf("\n  AddFile: %d %d %d %q .. %q", n.level, n.meta.Number, n.meta.FileSize, n.meta.Smallest, n.meta.Largest)
+  }
+  r += "\n}\n"
+  return r
+}
+
+func (edit *VersionEdit) sortedDeletedFiles() []levelFile {
+  files := make([]levelFile, 0, len(edit.deletedFiles))
+  for f := range(edit.deletedFiles) {
+    files = append(files, f)
+  }
+  sort.Slice(files, func(i, j int) bool {
+    if files[i].level != files[j].level {
+      return files[i].level < files[j].level
+    }
+    return files[i].number < files[j].number
+  })
+  return files
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+  var buf [binary.MaxVarintLen64]byte
+  n := binary.PutUvarint(buf[:], v)
+  return append(dst, buf[:n]...)
+}
+
+func consumeUvarint(input *[]byte) (uint64, bool) {
+  v, n := binary.Uvarint(*input)
+  if n <= 0 {
+    return 0, false
+  }
+  *input = (*input)[n:]
+  return v, true
+}
+
+func consumeSlice(input *[]byte) ([]byte, bool) {
+  s, rest, ok := consumeLengthPrefixedSlice(*input)
+  if ok {
+    *input = rest
+  }
+  return s, ok
+}
+
+// Consume a slice that must hold an internal key: one too short for its
+// tag would make ExtractUserKey panic.
+func consumeInternalKey(input *[]byte) ([]byte, bool) {
+  s, ok := consumeSlice(input)
+  return s, ok && len(s) >= 8
+}
+
+func consumeLevel(input *[]byte) (int, bool) {
+  v, ok := consumeUvarint(input)
+  if ok && v < NumLevels {
+    return int(v), true
+  }
+  return 0, false
+}
@@ -0,0 +1,65 @@
+package leveldb
+
+import (
+  "testing"
+)
+
+func testEncodeDecode(t *testing.T, edit *VersionEdit) {
+  encoded := edit.EncodeTo()
+  parsed := NewVersionEdit()
+  if err := parsed.DecodeFrom(encoded); err != nil {
+    t.Fatal("Cannot decode version edit: ", err)
+  }
+  encoded2 := parsed.EncodeTo()
+  if string(encoded) != string(encoded2) {
+    t.Error("Version edit does not round trip: ", edit, parsed)
+  }
+}
+
+func TestVersionEditEncodeDecode(t *testing.T) {
+  big := uint64(1) << 50
+
+  edit := NewVersionEdit()
+  for i := 0; i < 4; i++ {
+    testEncodeDecode(t, edit)
+    edit.AddFile(3, big + 300 + uint64(i), big + 400 + uint64(i),
+        AppendInternalKey(nil, []byte("foo"), SequenceNumber(big + 500 + uint64(i)), TypeValue),
+        AppendInternalKey(nil, []byte("zoo"), SequenceNumber(big + 600 + uint64(i)), TypeDeletion))
+    edit.DeleteFile(4, big + 700 + uint64(i))
+    edit.SetCompactPointer(i, AppendInternalKey(nil, []byte("x"), SequenceNumber(big + 900 + uint64(i)), TypeValue))
+  }
+
+  edit.SetComparatorName("foo")
+  edit.SetLogNumber(big + 100)
+  edit.SetNextFile(big + 200)
+  edit.SetLastSequence(SequenceNumber(big + 1000))
+  testEncodeDecode(t, edit)
+}
+
+func TestVersionEditDecodeCorruption(t *testing.T) {
+  edit := NewVersionEdit()
+  edit.SetComparatorName("foo")
+  edit.AddFile(1, 10, 100, AppendInternalKey(nil, []byte("a"), 1, TypeValue),
+      AppendInternalKey(nil, []byte("b"), 2, TypeValue))
+  encoded := edit.EncodeTo()
+
+  parsed := NewVersionEdit()
+  if err := parsed.DecodeFrom(encoded[:len(encoded) - 1]); err == nil {
+    t.Error("Truncated version edit should not decode.")
+  }
+  if err := parsed.DecodeFrom(append(encoded, 100)); err == nil {
+    t.Error("Version edit with unknown tag should not decode.")
+  }
+
+  // Keys too short to be internal keys.
+  edit = NewVersionEdit()
+  edit.AddFile(1, 10, 100, []byte("a"), AppendInternalKey(nil, []byte("b"), 2, TypeValue))
+  if err := parsed.DecodeFrom(edit.EncodeTo()); err == nil {
+    t.Error("New file with a short key should not decode.")
+  }
+  edit = NewVersionEdit()
+  edit.SetCompactPointer(1, []byte("x"))
+  if err := parsed.DecodeFrom(edit.EncodeTo()); err == nil {
+    t.Error("Compaction pointer with a short key should not decode.")
+  }
+}
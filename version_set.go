@@ -0,0 +1,1088 @@
+package leveldb
+
+import (
+  "encoding/binary"
+  "fmt"
+  "sort"
+  "strings"
+  "sync"
+
+  "github.com/chenlanbo/leveldb/log"
+)
+
+// Return the smallest index i such that files[i].Largest >= key.
+// Return len(files) if there is no such file.
+// REQUIRES: "files" contains a sorted list of non-overlapping files.
+func findFile(icmp *InternalKeyComparator, files []*FileMetaData, key []byte) int {
+  return sort.Search(len(files), func(i int) bool {
+    return icmp.Compare(files[i].Largest, key) >= 0
+  })
+}
+
+// Is userKey after the largest key in f? A nil userKey occurs before all
+// keys and is therefore never after f.
+func afterFile(ucmp Comparator, userKey []byte, f *FileMetaData) bool {
+  return userKey != nil && ucmp.Compare(userKey, ExtractUserKey(f.Largest)) > 0
+}
+
+// Is userKey before the smallest key in f? A nil userKey occurs after all
+// keys and is therefore never before f.
+func beforeFile(ucmp Comparator, userKey []byte, f *FileMetaData) bool {
+  return userKey != nil && ucmp.Compare(userKey, ExtractUserKey(f.Smallest)) < 0
+}
+
+// Returns true iff some file in files overlaps the user key range
+// [smallestUserKey, largestUserKey].
+// smallestUserKey == nil represents a key smaller than all keys in the DB.
+// largestUserKey == nil represents a key larger than all keys in the DB.
+// REQUIRES: If disjointSortedFiles, files contains a sorted list of
+// non-overlapping files.
+func someFileOverlapsRange(icmp *InternalKeyComparator, disjointSortedFiles bool,
+    files []*FileMetaData, smallestUserKey, largestUserKey []byte) bool {
+  ucmp := icmp.UserComparator()
+  if !disjointSortedFiles {
+    // Need to check against all files
+    for _, f := range(files) {
+      if afterFile(ucmp, smallestUserKey, f) || beforeFile(ucmp, largestUserKey, f) {
+        // No overlap
+      } else {
+        return true  // Overlap
+      }
+    }
+    return false
+  }
+
+  // Binary search over file list
+  index := 0
+  if smallestUserKey != nil {
+    // Find the earliest possible internal key for smallestUserKey
+    smallKey := AppendInternalKey(nil, smallestUserKey, MaxSequenceNumber, ValueTypeForSeek)
+    index = findFile(icmp, files, smallKey)
+  }
+
+  if index >= len(files) {
+    // beginning of range is after all files, so no overlap.
+    return false
+  }
+
+  return !beforeFile(ucmp, largestUserKey, files[index])
+}
+
+// An internal iterator. For a given version/level pair, yields information
+// about the files in the level. For a given entry, Key() is the largest key
+// that occurs in the file, and Value() is a 16-byte value containing the file
+// number and file size, both encoded using fixed64.
+type levelFileNumIterator struct {
+  icmp *InternalKeyComparator
+  files []*FileMetaData
+  index int  // len(files) when invalid
+  value [16]byte  // Backing store for Value(). Holds the file number and size.
+}
+
+func newLevelFileNumIterator(icmp *InternalKeyComparator, files []*FileMetaData) *levelFileNumIterator {
+  iter := &levelFileNumIterator{}
+  iter.icmp = icmp
+  iter.files = files
+  iter.index = len(files)  // Marks as invalid
+  return iter
+}
+
+func (iter *levelFileNumIterator) Valid() bool {
+  return iter.index < len(iter.files)
+}
+
+func (iter *levelFileNumIterator) SeekToFirst() {
+  iter.index = 0
+}
+
+func (iter *levelFileNumIterator) SeekToLast() {
+  if len(iter.files) == 0 {
+    iter.index = 0
+  } else {
+    iter.index = len(iter.files) - 1
+  }
+}
+
+func (iter *levelFileNumIterator) Seek(target []byte) {
+  iter.index = findFile(iter.icmp, iter.files, target)
+}
+
+func (iter *levelFileNumIterator) Next() {
+  if !iter.Valid() {
+    panic("")
+  }
+  iter.index++
+}
+
+func (iter *levelFileNumIterator) Prev() {
+  if !iter.Valid() {
+    panic("")
+  }
+  if iter.index == 0 {
+    iter.index = len(iter.files)  // Marks as invalid
+  } else {
+    iter.index--
+  }
+}
+
+func (iter *levelFileNumIterator) Key() []byte {
+  if !iter.Valid() {
+    panic("")
+  }
+  return iter.files[iter.index].Largest
+}
+
+func (iter *levelFileNumIterator) Value() []byte {
+  if !iter.Valid() {
+    panic("")
+  }
+  binary.LittleEndian.PutUint64(iter.value[0:], iter.files[iter.index].Number)
+  binary.LittleEndian.PutUint64(iter.value[8:], iter.files[iter.index].FileSize)
+  return iter.value[:]
+}
+
+func (iter *levelFileNumIterator) Error() error {
+  return nil
+}
+
+func (iter *levelFileNumIterator) Close() error {
+  return nil
+}
+
+// A Version is the set of table files that make up the DB at some point in
+// time. Versions are reference counted so that files that are still in use
+// by readers are not deleted.
+type Version struct {
+  vset *VersionSet
+  next *Version  // Next version in linked list
+  prev *Version  // Previous version in linked list
+  refs int  // Number of live refs to this version
+
+  // List of files per level
+  files [NumLevels][]*FileMetaData
+
+  // Level that should be compacted next and its compaction score.
+  // Score < 1 means compaction is not strictly needed. These fields are
+  // initialized by finalize().
+  compactionScore float64
+  compactionLevel int
+}
+
+func newVersion(vset *VersionSet) *Version {
+  v := &Version{}
+  v.vset = vset
+  v.next = v
+  v.prev = v
+  v.refs = 0
+  v.compactionScore = -1
+  v.compactionLevel = -1
+  return v
+}
+
+// Reference count management (so Versions do not disappear out from under
+// live iterators).
+// REQUIRES: DB mutex held.
+func (v *Version) Ref() {
+  v.refs++
+}
+
+func (v *Version) Unref() {
+  if v == &v.vset.dummyVersions {
+    panic("Unref the dummy version.")
+  }
+  if v.refs < 1 {
+    panic("Unref an unreferenced version.")
+  }
+  v.refs--
+  if v.refs == 0 {
+    // Remove from linked list
+    v.prev.next = v.next
+    v.next.prev = v.prev
+  }
+}
+
+func (v *Version) NumFiles(level int) int {
+  return len(v.files[level])
+}
+
+// Lookup the value for key. Returns a NotFoundError if the key does not
+// exist or has been deleted.
+// REQUIRES: DB mutex not held.
+func (v *Version) Get(options *ReadOptions, key *LookupKey) ([]byte, error) {
+  icmp := &v.vset.icmp
+  ucmp := icmp.UserComparator()
+  ikey := key.InternalKey()
+  userKey := key.UserKey()
+
+  // We can search level-by-level since entries never hop across levels.
+  // Therefore we are guaranteed that if we find data in a smaller level,
+  // later levels are irrelevant.
+  for level := 0; level < NumLevels; level++ {
+    files := v.files[level]
+    if len(files) == 0 {
+      continue
+    }
+
+    var candidates []*FileMetaData
+    if level == 0 {
+      // Level-0 files may overlap each other. Find all files that overlap
+      // userKey and process them in order from newest to oldest.
+      for _, f := range(files) {
+        if ucmp.Compare(userKey, ExtractUserKey(f.Smallest)) >= 0 &&
+            ucmp.Compare(userKey, ExtractUserKey(f.Largest)) <= 0 {
+          candidates = append(candidates, f)
+        }
+      }
+      sort.Slice(candidates, func(i, j int) bool {
+        return candidates[i].Number > candidates[j].Number
+      })
+    } else {
+      // Binary search to find earliest index whose largest key >= ikey.
+      index := findFile(icmp, files, ikey)
+      if index < len(files) && ucmp.Compare(userKey, ExtractUserKey(files[index].Smallest)) >= 0 {
+        candidates = files[index:index + 1]
+      }
+    }
+
+    for _, f := range(candidates) {
+      value, found, err := v.vset.getFromTable(options, f, key)
+      if found {
+        return value, err
+      }
+    }
+  }
+
+  return nil, NotFoundError("")
+}
+
+// Returns true iff some file in the specified level overlaps some part of
+// [smallestUserKey, largestUserKey].
+// smallestUserKey == nil represents a key smaller than all the DB's keys.
+// largestUserKey == nil represents a key larger than all the DB's keys.
+func (v *Version) OverlapInLevel(level int, smallestUserKey, largestUserKey []byte) bool {
+  return someFileOverlapsRange(&v.vset.icmp, level > 0, v.files[level], smallestUserKey, largestUserKey)
+}
+
+// Append to iters a sequence of iterators that will yield the contents of
+// this Version when merged together.
+// REQUIRES: This version has been saved (see VersionSet.SaveTo)
+func (v *Version) AddIterators(options *ReadOptions, iters []Iterator) []Iterator {
+  // Merge all level zero files together since they may overlap
+  for _, f := range(v.files[0]) {
+    iters = append(iters, v.vset.tableCache.NewIterator(options, f.Number, f.FileSize))
+  }
+
+  // For levels > 0, we can use a concatenating iterator that sequentially
+  // walks through the non-overlapping files in the level, opening them
+  // lazily.
+  for level := 1; level < NumLevels; level++ {
+    if len(v.files[level]) > 0 {
+      iters = append(iters, v.vset.newConcatenatingIterator(options, v.files[level]))
+    }
+  }
+  return iters
+}
+
+// Return all files in level that overlap [begin, end]. begin and end are
+// internal keys; nil means before all keys / after all keys respectively.
+func (v *Version) GetOverlappingInputs(level int, begin, end []byte) []*FileMetaData {
+  if level < 0 || level >= NumLevels {
+    panic(fmt.Sprint("Invalid level ", level))
+  }
+  var inputs []*FileMetaData
+  var userBegin, userEnd []byte
+  if begin != nil {
+    userBegin = ExtractUserKey(begin)
+  }
+  if end != nil {
+    userEnd = ExtractUserKey(end)
+  }
+  ucmp := v.vset.icmp.UserComparator()
+  for i := 0; i < len(v.files[level]); {
+    f := v.files[level][i]
+    i++
+    fileStart := ExtractUserKey(f.Smallest)
+    fileLimit := ExtractUserKey(f.Largest)
+    if begin != nil && ucmp.Compare(fileLimit, userBegin) < 0 {
+      // f is completely before specified range; skip it
+    } else if end != nil && ucmp.Compare(fileStart, userEnd) > 0 {
+      // f is completely after specified range; skip it
+    } else {
+      inputs = append(inputs, f)
+      if level == 0 {
+        // Level-0 files may overlap each other. So check if the newly
+        // added file has expanded the range. If so, restart search.
+        if begin != nil && ucmp.Compare(fileStart, userBegin) < 0 {
+          userBegin = fileStart
+          inputs = nil
+          i = 0
+        } else if end != nil && ucmp.Compare(fileLimit, userEnd) > 0 {
+          userEnd = fileLimit
+          inputs = nil
+          i = 0
+        }
+      }
+    }
+  }
+  return inputs
+}
+
+// Return the level at which we should place a new memtable compaction
+// result that covers the range [smallestUserKey, largestUserKey].
+func (v *Version) PickLevelForMemTableOutput(smallestUserKey, largestUserKey []byte) int {
+  level := 0
+  if !v.OverlapInLevel(0, smallestUserKey, largestUserKey) {
+    // Push to next level if there is no overlap in next level, and the
+    // #bytes overlapping in the level after that are limited.
+    start := AppendInternalKey(nil, smallestUserKey, MaxSequenceNumber, ValueTypeForSeek)
+    limit := AppendInternalKey(nil, largestUserKey, 0, TypeDeletion)
+    for level < maxMemCompactLevel {
+      if v.OverlapInLevel(level + 1, smallestUserKey, largestUserKey) {
+        break
+      }
+      if level + 2 < NumLevels {
+        // Check that file does not overlap too many grandparent bytes.
+        overlaps := v.GetOverlappingInputs(level + 2, start, limit)
+        if totalFileSize(overlaps) > maxGrandParentOverlapBytes(v.vset.options) {
+          break
+        }
+      }
+      level++
+    }
+  }
+  return level
+}
+
+func (v *Version) String() string {
+  r := ""
+  for level := 0; level < NumLevels; level++ {
+    r += fmt.Sprintf("--- level %d ---\n", level)
+    for _, f := range(v.files[level]) {
+      r += fmt.Sprintf(" %d:%d[%q .. %q]\n", f.Number, f.FileSize, f.Smallest, f.Largest)
+    }
+  }
+  return r
+}
+
+// The set of versions of the DB and the MANIFEST that records them.
+type VersionSet struct {
+  dbname string
+  env Env
+  options *Options  // Options used to open tables
+  tableCache *TableCache
+  icmp InternalKeyComparator
+  nextFileNumber uint64
+  manifestFileNumber uint64
+  lastSequence SequenceNumber
+  logNumber uint64
+  prevLogNumber uint64  // 0 or backing store for memtable being compacted
+
+  // Opened lazily
+  descriptorFile WritableFile
+  descriptorLog *log.LogWriter
+  dummyVersions Version  // Head of circular doubly-linked list of versions.
+  current *Version  // == dummyVersions.prev
+
+  // Per-level key at which the next compaction at that level should start.
+  // Either an empty slice, or a valid InternalKey.
+  compactPointer [NumLevels][]byte
+}
+
+func NewVersionSet(dbname string, options *Options, tableCache *TableCache, icmp InternalKeyComparator) *VersionSet {
+  vset := &VersionSet{}
+  vset.dbname = dbname
+  vset.env = options.Env
+  vset.options = options
+  vset.tableCache = tableCache
+  vset.icmp = icmp
+  vset.nextFileNumber = 2
+  vset.manifestFileNumber = 0  // Filled by Recover()
+  vset.lastSequence = 0
+  vset.logNumber = 0
+  vset.prevLogNumber = 0
+  vset.dummyVersions.vset = vset
+  vset.dummyVersions.next = &vset.dummyVersions
+  vset.dummyVersions.prev = &vset.dummyVersions
+  vset.appendVersion(newVersion(vset))
+  return vset
+}
+
+// Close the descriptor file.
+func (vset *VersionSet) Close() error {
+  if vset.descriptorFile == nil {
+    return nil
+  }
+  err := vset.descriptorFile.Close()
+  vset.descriptorFile = nil
+  vset.descriptorLog = nil
+  return err
+}
+
+// Return the current version.
+func (vset *VersionSet) Current() *Version {
+  return vset.current
+}
+
+// Return the current manifest file number.
+func (vset *VersionSet) ManifestFileNumber() uint64 {
+  return vset.manifestFileNumber
+}
+
+// Allocate and return a new file number.
+func (vset *VersionSet) NewFileNumber() uint64 {
+  n := vset.nextFileNumber
+  vset.nextFileNumber++
+  return n
+}
+
+// Arrange to reuse "number" unless a newer file number has already been
+// allocated.
+func (vset *VersionSet) ReuseFileNumber(number uint64) {
+  if vset.nextFileNumber == number + 1 {
+    vset.nextFileNumber = number
+  }
+}
+
+// Mark the specified file number as used.
+func (vset *VersionSet) MarkFileNumberUsed(number uint64) {
+  if vset.nextFileNumber <= number {
+    vset.nextFileNumber = number + 1
+  }
+}
+
+// Return the number of table files at the specified level.
+func (vset *VersionSet) NumLevelFiles(level int) int {
+  return len(vset.current.files[level])
+}
+
+// Return the combined file size of all files at the specified level.
+func (vset *VersionSet) NumLevelBytes(level int) uint64 {
+  return totalFileSize(vset.current.files[level])
+}
+
+// Return the last sequence number.
+func (vset *VersionSet) LastSequence() SequenceNumber {
+  return vset.lastSequence
+}
+
+// Set the last sequence number to s.
+func (vset *VersionSet) SetLastSequence(s SequenceNumber) {
+  if s < vset.lastSequence {
+    panic("Last sequence number goes backwards.")
+  }
+  vset.lastSequence = s
+}
+
+// Return the current log file number.
+func (vset *VersionSet) LogNumber() uint64 {
+  return vset.logNumber
+}
+
+// Return the log file number for the log file that is currently being
+// compacted, or zero if there is no such log file.
+func (vset *VersionSet) PrevLogNumber() uint64 {
+  return vset.prevLogNumber
+}
+
+// Add all files listed in any live version to live.
+func (vset *VersionSet) AddLiveFiles(live map[uint64]bool) {
+  for v := vset.dummyVersions.next; v != &vset.dummyVersions; v = v.next {
+    for level := 0; level < NumLevels; level++ {
+      for _, f := range(v.files[level]) {
+        live[f.Number] = true
+      }
+    }
+  }
+}
+
+func (vset *VersionSet) appendVersion(v *Version) {
+  // Make "v" current
+  if v.refs != 0 {
+    panic("Append a referenced version.")
+  }
+  if v == vset.current {
+    panic("Append the current version.")
+  }
+  if vset.current != nil {
+    vset.current.Unref()
+  }
+  vset.current = v
+  v.Ref()
+
+  // Append to linked list
+  v.prev = vset.dummyVersions.prev
+  v.next = &vset.dummyVersions
+  v.prev.next = v
+  v.next.prev = v
+}
+
+// Apply edit to the current version to form a new descriptor that is both
+// saved to persistent state and installed as the new current version. Will
+// release mu while actually writing to the file.
+// REQUIRES: mu is held on entry.
+// REQUIRES: no other goroutine concurrently calls LogAndApply()
+func (vset *VersionSet) LogAndApply(edit *VersionEdit, mu *sync.Mutex) error {
+  if edit.hasLogNumber {
+    if edit.logNumber < vset.logNumber || edit.logNumber >= vset.nextFileNumber {
+      panic("Invalid log number in version edit.")
+    }
+  } else {
+    edit.SetLogNumber(vset.logNumber)
+  }
+
+  if !edit.hasPrevLogNumber {
+    edit.SetPrevLogNumber(vset.prevLogNumber)
+  }
+
+  edit.SetNextFile(vset.nextFileNumber)
+  edit.SetLastSequence(vset.lastSequence)
+
+  v := newVersion(vset)
+  builder := newVersionBuilder(vset, vset.current)
+  builder.Apply(edit)
+  if err := builder.SaveTo(v); err != nil {
+    return err
+  }
+  vset.finalize(v)
+
+  // Initialize new descriptor log file if necessary by creating a temporary
+  // file that contains a snapshot of the current version.
+  var err error
+  newManifestFile := ""
+  if vset.descriptorLog == nil {
+    newManifestFile = DescriptorFileName(vset.dbname, vset.manifestFileNumber)
+    vset.descriptorFile, err = vset.env.NewWritableFile(newManifestFile)
+    if err == nil {
+      vset.descriptorLog = log.NewLogWriter(vset.descriptorFile, 0)
+      err = vset.writeSnapshot(vset.descriptorLog)
+    }
+  }
+
+  // Unlock during expensive MANIFEST log write.
+  mu.Unlock()
+
+  // Write new record to MANIFEST log.
+  if err == nil {
+    err = vset.descriptorLog.AddRecord(edit.EncodeTo())
+    if err == nil {
+      // Make the directory entries of the files the edit refers to durable
+      // before the edit itself.
+      err = vset.env.SyncDir(vset.dbname)
+    }
+    if err == nil {
+      err = vset.descriptorFile.Sync()
+    }
+  }
+
+  // If we just created a new descriptor file, install it by writing a new
+  // CURRENT file that points to it.
+  if err == nil && newManifestFile != "" {
+    err = setCurrentFile(vset.env, vset.dbname, vset.manifestFileNumber)
+  }
+
+  mu.Lock()
+
+  // Install the new version.
+  if err == nil {
+    vset.appendVersion(v)
+    vset.logNumber = edit.logNumber
+    vset.prevLogNumber = edit.prevLogNumber
+  } else if newManifestFile != "" {
+    if vset.descriptorFile != nil {
+      vset.descriptorFile.Close()
+    }
+    vset.descriptorFile = nil
+    vset.descriptorLog = nil
+    vset.env.DeleteFile(newManifestFile)
+  }
+
+  return err
+}
+
+// Collects the corruptions found while reading the descriptor.
+type manifestReporter struct {
+  filename string
+  status error
+}
+
+func (r *manifestReporter) Corruption(bytes int, reason error) {
+  if r.status == nil {
+    if _, ok := reason.(*Status); !ok {
+      reason = CorruptionError(reason.Error())
+    }
+    r.status = withFile(reason, r.filename)
+  }
+}
+
+// Recover the last saved descriptor from persistent storage.
+func (vset *VersionSet) Recover() error {
+  // Read "CURRENT" file, which contains a pointer to the current manifest file.
+  current, err := readFileToString(vset.env, CurrentFileName(vset.dbname))
+  if err != nil {
+    return err
+  }
+  if len(current) == 0 || current[len(current) - 1] != '\n' {
+    return withFile(CorruptionError("CURRENT file does not end with newline"), CurrentFileName(vset.dbname))
+  }
+  dscname := vset.dbname + "/" + strings.TrimSuffix(string(current), "\n")
+  file, err := vset.env.NewSequentialFile(dscname)
+  if err != nil {
+    s := newStatus(StatusCorruption, "CURRENT points to a non-existent file")
+    s.err = err
+    return s
+  }
+  defer file.Close()
+
+  haveLogNumber := false
+  havePrevLogNumber := false
+  haveNextFile := false
+  haveLastSequence := false
+  var nextFile, logNumber, prevLogNumber uint64
+  var lastSequence SequenceNumber
+  builder := newVersionBuilder(vset, vset.current)
+
+  reporter := &manifestReporter{filename:dscname}
+  reader := log.NewLogReader(file, reporter, true, 0)
+  for reporter.status == nil {
+    record, ok := reader.ReadRecord()
+    if !ok {
+      break
+    }
+    edit := NewVersionEdit()
+    if err := edit.DecodeFrom(record); err != nil {
+      return withFile(err, dscname)
+    }
+    if edit.hasComparator && edit.comparator != vset.icmp.UserComparator().Name() {
+      return InvalidArgumentError(fmt.Sprint(edit.comparator, " does not match existing comparator ",
+          vset.icmp.UserComparator().Name()))
+    }
+
+    builder.Apply(edit)
+
+    if edit.hasLogNumber {
+      logNumber = edit.logNumber
+      haveLogNumber = true
+    }
+    if edit.hasPrevLogNumber {
+      prevLogNumber = edit.prevLogNumber
+      havePrevLogNumber = true
+    }
+    if edit.hasNextFileNumber {
+      nextFile = edit.nextFileNumber
+      haveNextFile = true
+    }
+    if edit.hasLastSequence {
+      lastSequence = edit.lastSequence
+      haveLastSequence = true
+    }
+  }
+  if reporter.status != nil {
+    return reporter.status
+  }
+
+  if !haveNextFile {
+    return withFile(CorruptionError("no meta-nextfile entry in descriptor"), dscname)
+  } else if !haveLogNumber {
+    return withFile(CorruptionError("no meta-lognumber entry in descriptor"), dscname)
+  } else if !haveLastSequence {
+    return withFile(CorruptionError("no last-sequence-number entry in descriptor"), dscname)
+  }
+  if !havePrevLogNumber {
+    prevLogNumber = 0
+  }
+
+  vset.MarkFileNumberUsed(prevLogNumber)
+  vset.MarkFileNumberUsed(logNumber)
+
+  v := newVersion(vset)
+  if err := builder.SaveTo(v); err != nil {
+    return withFile(err, dscname)
+  }
+  // Install recovered version
+  vset.finalize(v)
+  vset.appendVersion(v)
+  vset.manifestFileNumber = nextFile
+  vset.nextFileNumber = nextFile + 1
+  vset.lastSequence = lastSequence
+  vset.logNumber = logNumber
+  vset.prevLogNumber = prevLogNumber
+  return nil
+}
+
+// Precompute the best level for the next compaction.
+func (vset *VersionSet) finalize(v *Version) {
+  bestLevel := -1
+  bestScore := -1.0
+
+  for level := 0; level < NumLevels - 1; level++ {
+    var score float64
+    if level == 0 {
+      // We treat level-0 specially by bounding the number of files instead
+      // of number of bytes for two reasons:
+      //
+      // (1) With larger write-buffer sizes, it is nice not to do too many
+      // level-0 compactions.
+      //
+      // (2) The files in level-0 are merged on every read and therefore we
+      // wish to avoid too many files when the individual file size is small
+      // (perhaps because of a small write-buffer setting, or very high
+      // compression ratios, or lots of overwrites/deletions).
+      score = float64(len(v.files[level])) / float64(l0CompactionTrigger)
+    } else {
+      // Compute the ratio of current size to size limit.
+      score = float64(totalFileSize(v.files[level])) / maxBytesForLevel(level)
+    }
+
+    if score > bestScore {
+      bestLevel = level
+      bestScore = score
+    }
+  }
+
+  v.compactionLevel = bestLevel
+  v.compactionScore = bestScore
+}
+
+// Returns true iff some level needs a compaction.
+func (vset *VersionSet) NeedsCompaction() bool {
+  return vset.current.compactionScore >= 1
+}
+
+// Pick level and inputs for a new compaction. Returns nil if there is no
+// compaction to be done. Otherwise returns a compaction that describes the
+// compaction; the caller should call ReleaseInputs() on it when done.
+func (vset *VersionSet) PickCompaction() *Compaction {
+  // We prefer compactions triggered by too much data in a level.
+  if !vset.NeedsCompaction() {
+    return nil
+  }
+  current := vset.current
+  level := current.compactionLevel
+  if level < 0 || level + 1 >= NumLevels {
+    panic(fmt.Sprint("Invalid compaction level ", level))
+  }
+  c := newCompaction(vset.options, level)
+
+  // Pick the first file that comes after compactPointer[level]
+  for _, f := range(current.files[level]) {
+    if len(vset.compactPointer[level]) == 0 ||
+        vset.icmp.Compare(f.Largest, vset.compactPointer[level]) > 0 {
+      c.inputs[0] = append(c.inputs[0], f)
+      break
+    }
+  }
+  if len(c.inputs[0]) == 0 {
+    // Wrap-around to the beginning of the key space
+    c.inputs[0] = append(c.inputs[0], current.files[level][0])
+  }
+
+  c.inputVersion = current
+  c.inputVersion.Ref()
+
+  // Files in level 0 may overlap each other, so pick up all overlapping ones
+  if level == 0 {
+    smallest, largest := vset.getRange(c.inputs[0])
+    // Note that the next call will discard the file we placed in
+    // c.inputs[0] earlier and replace it with an overlapping set
+    // which will include the picked file.
+    c.inputs[0] = current.GetOverlappingInputs(0, smallest, largest)
+  }
+
+  vset.setupOtherInputs(c)
+  return c
+}
+
+// Return a compaction object for compacting the range [begin, end] in the
+// specified level. Returns nil if there is nothing in that level that
+// overlaps the specified range. begin and end are internal keys; nil means
+// before all keys / after all keys respectively.
+func (vset *VersionSet) CompactRange(level int, begin, end []byte) *Compaction {
+  inputs := vset.current.GetOverlappingInputs(level, begin, end)
+  if len(inputs) == 0 {
+    return nil
+  }
+
+  // Avoid compacting too much in one shot in case the range is large.
+  // But we cannot do this for level-0 since level-0 files can overlap
+  // and we must not pick one file and drop another older file if the
+  // two files overlap.
+  if level > 0 {
+    limit := maxFileSizeForLevel(vset.options, level)
+    var total uint64 = 0
+    for i, f := range(inputs) {
+      total += f.FileSize
+      if total >= limit {
+        inputs = inputs[:i + 1]
+        break
+      }
+    }
+  }
+
+  c := newCompaction(vset.options, level)
+  c.inputVersion = vset.current
+  c.inputVersion.Ref()
+  c.inputs[0] = inputs
+  vset.setupOtherInputs(c)
+  return c
+}
+
+// Create an iterator that reads over the compaction inputs for c.
+func (vset *VersionSet) MakeInputIterator(c *Compaction) Iterator {
+  options := &ReadOptions{}
+  options.FillCache = false
+  options.IOPriority = IOPriorityBackground
+
+  // Level-0 files have to be merged together. For other levels, we will make
+  // a concatenating iterator per level.
+  var list []Iterator
+  for which := 0; which < 2; which++ {
+    if len(c.inputs[which]) == 0 {
+      continue
+    }
+    if c.level + which == 0 {
+      for _, f := range(c.inputs[which]) {
+        list = append(list, vset.tableCache.NewIterator(options, f.Number, f.FileSize))
+      }
+    } else {
+      // Create concatenating iterator for the files from this level
+      list = append(list, vset.newConcatenatingIterator(options, c.inputs[which]))
+    }
+  }
+  return NewMergeIterator(&vset.icmp, list)
+}
+
+// Stores the minimal range that covers all entries in inputs.
+// REQUIRES: inputs is not empty
+func (vset *VersionSet) getRange(inputs []*FileMetaData) ([]byte, []byte) {
+  if len(inputs) == 0 {
+    panic("Get the range of no input files.")
+  }
+  smallest := inputs[0].Smallest
+  largest := inputs[0].Largest
+  for _, f := range(inputs[1:]) {
+    if vset.icmp.Compare(f.Smallest, smallest) < 0 {
+      smallest = f.Smallest
+    }
+    if vset.icmp.Compare(f.Largest, largest) > 0 {
+      largest = f.Largest
+    }
+  }
+  return smallest, largest
+}
+
+// Stores the minimal range that covers all entries in inputs1 and inputs2.
+// REQUIRES: inputs is not empty
+func (vset *VersionSet) getRange2(inputs1, inputs2 []*FileMetaData) ([]byte, []byte) {
+  all := make([]*FileMetaData, 0, len(inputs1) + len(inputs2))
+  all = append(all, inputs1...)
+  all = append(all, inputs2...)
+  return vset.getRange(all)
+}
+
+func (vset *VersionSet) setupOtherInputs(c *Compaction) {
+  current := vset.current
+  level := c.level
+
+  c.inputs[0] = addBoundaryInputs(&vset.icmp, current.files[level], c.inputs[0])
+  smallest, largest := vset.getRange(c.inputs[0])
+
+  c.inputs[1] = current.GetOverlappingInputs(level + 1, smallest, largest)
+  c.inputs[1] = addBoundaryInputs(&vset.icmp, current.files[level + 1], c.inputs[1])
+
+  // Get entire range covered by compaction
+  allStart, allLimit := vset.getRange2(c.inputs[0], c.inputs[1])
+
+  // See if we can grow the number of inputs in "level" without
+  // changing the number of "level+1" files we pick up.
+  if len(c.inputs[1]) > 0 {
+    expanded0 := current.GetOverlappingInputs(level, allStart, allLimit)
+    expanded0 = addBoundaryInputs(&vset.icmp, current.files[level], expanded0)
+    inputs1Size := totalFileSize(c.inputs[1])
+    expanded0Size := totalFileSize(expanded0)
+    if len(expanded0) > len(c.inputs[0]) &&
+        inputs1Size + expanded0Size < expandedCompactionByteSizeLimit(vset.options) {
+      newStart, newLimit := vset.getRange(expanded0)
+      expanded1 := current.GetOverlappingInputs(level + 1, newStart, newLimit)
+      expanded1 = addBoundaryInputs(&vset.icmp, current.files[level + 1], expanded1)
+      if len(expanded1) == len(c.inputs[1]) {
+        smallest = newStart
+        largest = newLimit
+        c.inputs[0] = expanded0
+        c.inputs[1] = expanded1
+        allStart, allLimit = vset.getRange2(c.inputs[0], c.inputs[1])
+      }
+    }
+  }
+
+  // Compute the set of grandparent files that overlap this compaction
+  // (parent == level+1; grandparent == level+2)
+  if level + 2 < NumLevels {
+    c.grandparents = current.GetOverlappingInputs(level + 2, allStart, allLimit)
+  }
+
+  // Update the place where we will do the next compaction for this level.
+  // We update this immediately instead of waiting for the VersionEdit
+  // to be applied so that if the compaction fails, we will try a different
+  // key range next time.
+  vset.compactPointer[level] = largest
+  c.edit.SetCompactPointer(level, largest)
+}
+
+// Save current contents to log.
+func (vset *VersionSet) writeSnapshot(writer *log.LogWriter) error {
+  // Save metadata
+  edit := NewVersionEdit()
+  edit.SetComparatorName(vset.icmp.UserComparator().Name())
+
+  // Save compaction pointers
+  for level := 0; level < NumLevels; level++ {
+    if len(vset.compactPointer[level]) > 0 {
+      edit.SetCompactPointer(level, vset.compactPointer[level])
+    }
+  }
+
+  // Save files
+  for level := 0; level < NumLevels; level++ {
+    for _, f := range(vset.current.files[level]) {
+      edit.AddFile(level, f.Number, f.FileSize, f.Smallest, f.Largest)
+    }
+  }
+
+  return writer.AddRecord(edit.EncodeTo())
+}
+
+// Look up key in the table described by f. found is false if the table has
+// no entry for the user key.
+func (vset *VersionSet) getFromTable(options *ReadOptions, f *FileMetaData, key *LookupKey) ([]byte, bool, error) {
+  ucmp := vset.icmp.UserComparator()
+  var value []byte
+  found := false
+  var status error
+  saver := func(k, v []byte) {
+    parsed, ok := ParseInternalKey(k)
+    if !ok {
+      found = true
+      status = CorruptionError("corrupted internal key in table")
+    } else if ucmp.Compare(parsed.UserKey, key.UserKey()) == 0 {
+      found = true
+      if parsed.Type == TypeDeletion {
+        status = NotFoundError("")
+      } else {
+        value = append([]byte(nil), v...)
+      }
+    }
+  }
+  if err := vset.tableCache.Get(options, f.Number, f.FileSize, key.InternalKey(), saver); err != nil {
+    return nil, true, err
+  }
+  return value, found, status
+}
+
+// Return an iterator over the sorted, non-overlapping files of a level that
+// opens each table lazily.
+func (vset *VersionSet) newConcatenatingIterator(options *ReadOptions, files []*FileMetaData) Iterator {
+  return newTableIterator(newLevelFileNumIterator(&vset.icmp, files), vset.fileIteratorReader, options)
+}
+
+// Reader for the values produced by a levelFileNumIterator.
+func (vset *VersionSet) fileIteratorReader(options *ReadOptions, fileValue []byte) Iterator {
+  if len(fileValue) != 16 {
+    return newEmptyIterator(CorruptionError("FileReader invoked with unexpected value"))
+  }
+  return vset.tableCache.NewIterator(options, binary.LittleEndian.Uint64(fileValue),
+      binary.LittleEndian.Uint64(fileValue[8:]))
+}
+
+func totalFileSize(files []*FileMetaData) uint64 {
+  var sum uint64 = 0
+  for _, f := range(files) {
+    sum += f.FileSize
+  }
+  return sum
+}
+
+// A helper class so we can efficiently apply a whole sequence of edits to a
+// particular state without creating intermediate Versions that contain full
+// copies of the intermediate state.
+type versionBuilder struct {
+  vset *VersionSet
+  base *Version
+  deletedFiles [NumLevels]map[uint64]bool
+  addedFiles [NumLevels]map[uint64]*FileMetaData
+}
+
+// Initialize a builder with the files from base and other info from vset.
+func newVersionBuilder(vset *VersionSet, base *Version) *versionBuilder {
+  builder := &versionBuilder{}
+  builder.vset = vset
+  builder.base = base
+  for level := 0; level < NumLevels; level++ {
+    builder.deletedFiles[level] = make(map[uint64]bool)
+    builder.addedFiles[level] = make(map[uint64]*FileMetaData)
+  }
+  return builder
+}
+
+// Apply all of the edits in edit to the current state.
+func (builder *versionBuilder) Apply(edit *VersionEdit) {
+  // Update compaction pointers
+  for _, p := range(edit.compactPointers) {
+    builder.vset.compactPointer[p.level] = p.key
+  }
+
+  // Delete files
+  for d := range(edit.deletedFiles) {
+    builder.deletedFiles[d.level][d.number] = true
+  }
+
+  // Add new files
+  for i := range(edit.newFiles) {
+    level := edit.newFiles[i].level
+    f := edit.newFiles[i].meta
+    delete(builder.deletedFiles[level], f.Number)
+    builder.addedFiles[level][f.Number] = &f
+  }
+}
+
+// Save the current state in v. Fails if files of a level above 0 overlap,
+// which only a corrupted descriptor can lead to.
+func (builder *versionBuilder) SaveTo(v *Version) error {
+  icmp := &builder.vset.icmp
+  for level := 0; level < NumLevels; level++ {
+    // Merge the set of added files with the set of pre-existing files.
+    // Drop any deleted files.
+    files := make([]*FileMetaData, 0, len(builder.base.files[level]) + len(builder.addedFiles[level]))
+    for _, f := range(builder.base.files[level]) {
+      if _, added := builder.addedFiles[level][f.Number]; !added {
+        files = append(files, f)
+      }
+    }
+    for _, f := range(builder.addedFiles[level]) {
+      files = append(files, f)
+    }
+    sort.Slice(files, func(i, j int) bool {
+      r := icmp.Compare(files[i].Smallest, files[j].Smallest)
+      if r != 0 {
+        return r < 0
+      }
+      // Break ties by file number
+      return files[i].Number < files[j].Number
+    })
+
+    for _, f := range(files) {
+      if builder.deletedFiles[level][f.Number] {
+        // File is deleted: do nothing
+        continue
+      }
+      // Must not overlap
+      if level > 0 && len(v.files[level]) > 0 {
+        prev := v.files[level][len(v.files[level]) - 1]
+        if icmp.Compare(prev.Largest, f.Smallest) >= 0 {
+          return CorruptionError(fmt.Sprint("overlapping ranges in same level ", level))
+        }
+      }
+      v.files[level] = append(v.files[level], f)
+    }
+  }
+  return nil
+}
@@ -0,0 +1,236 @@
+package leveldb
+
+import (
+  "testing"
+)
+
+type findFileTest struct {
+  icmp InternalKeyComparator
+  files []*FileMetaData
+  disjointSortedFiles bool
+}
+
+func newFindFileTest() *findFileTest {
+  return &findFileTest{icmp:NewInternalKeyComparator(DefaultComparator), disjointSortedFiles:true}
+}
+
+func (ft *findFileTest) add(smallest, largest string) {
+  f := &FileMetaData{}
+  f.Number = uint64(len(ft.files) + 1)
+  f.Smallest = AppendInternalKey(nil, []byte(smallest), 100, TypeValue)
+  f.Largest = AppendInternalKey(nil, []byte(largest), 100, TypeValue)
+  ft.files = append(ft.files, f)
+}
+
+func (ft *findFileTest) find(key string) int {
+  target := AppendInternalKey(nil, []byte(key), 100, TypeValue)
+  return findFile(&ft.icmp, ft.files, target)
+}
+
+// An empty string stands for an unbounded end of the range.
+func (ft *findFileTest) overlaps(smallest, largest string) bool {
+  var s, l []byte
+  if smallest != "" {
+    s = []byte(smallest)
+  }
+  if largest != "" {
+    l = []byte(largest)
+  }
+  return someFileOverlapsRange(&ft.icmp, ft.disjointSortedFiles, ft.files, s, l)
+}
+
+type overlapTest struct {
+  smallest, largest string
+  overlaps bool
+}
+
+func checkOverlaps(t *testing.T, ft *findFileTest, tests []overlapTest) {
+  for _, test := range(tests) {
+    if ft.overlaps(test.smallest, test.largest) != test.overlaps {
+      t.Error("Unexpected overlap for [", test.smallest, ", ", test.largest, "]: ", !test.overlaps)
+    }
+  }
+}
+
+func TestFindFileEmpty(t *testing.T) {
+  ft := newFindFileTest()
+  if ft.find("foo") != 0 {
+    t.Error("Empty file list should return 0.")
+  }
+}
+
+func TestFindFileSingle(t *testing.T) {
+  ft := newFindFileTest()
+  ft.add("p", "q")
+  expected := map[string]int{"a":0, "p":0, "p1":0, "q":0, "q1":1, "z":1}
+  for key, index := range(expected) {
+    if ft.find(key) != index {
+      t.Error("Unexpected index for ", key, ": ", ft.find(key))
+    }
+  }
+}
+
+func TestFindFileMultiple(t *testing.T) {
+  ft := newFindFileTest()
+  ft.add("150", "200")
+  ft.add("200", "250")
+  ft.add("300", "350")
+  ft.add("400", "450")
+  expected := map[string]int{"100":0, "150":0, "151":0, "199":0, "200":0, "201":1,
+      "249":1, "250":1, "251":2, "299":2, "300":2, "349":2, "350":2, "351":3,
+      "400":3, "450":3, "451":4}
+  for key, index := range(expected) {
+    if ft.find(key) != index {
+      t.Error("Unexpected index for ", key, ": ", ft.find(key))
+    }
+  }
+}
+
+func TestOverlapsEmpty(t *testing.T) {
+  ft := newFindFileTest()
+  checkOverlaps(t, ft, []overlapTest{{"a", "z", false}, {"", "z", false}, {"a", "", false}, {"", "", false}})
+}
+
+func TestOverlapsSingle(t *testing.T) {
+  ft := newFindFileTest()
+  ft.add("p", "q")
+  checkOverlaps(t, ft, []overlapTest{{"a", "b", false}, {"z1", "z2", false}, {"a", "p", true},
+      {"a", "q", true}, {"a", "z", true}, {"p", "p1", true}, {"p", "q", true}, {"p", "z", true},
+      {"p1", "p2", true}, {"p1", "z", true}, {"q", "q", true}, {"q", "q1", true},
+      {"", "j", false}, {"r", "", false}, {"", "p", true}, {"", "p1", true}, {"q", "", true},
+      {"", "", true}})
+}
+
+func TestOverlapsMultiple(t *testing.T) {
+  ft := newFindFileTest()
+  ft.add("150", "200")
+  ft.add("200", "250")
+  ft.add("300", "350")
+  ft.add("400", "450")
+  checkOverlaps(t, ft, []overlapTest{{"100", "149", false}, {"251", "299", false},
+      {"451", "500", false}, {"351", "399", false}, {"100", "150", true}, {"100", "200", true},
+      {"100", "300", true}, {"100", "400", true}, {"100", "500", true}, {"375", "400", true},
+      {"450", "450", true}, {"450", "500", true}})
+}
+
+func TestOverlapsNonDisjoint(t *testing.T) {
+  ft := newFindFileTest()
+  ft.add("150", "600")
+  ft.add("400", "500")
+  ft.disjointSortedFiles = false
+  checkOverlaps(t, ft, []overlapTest{{"100", "149", false}, {"601", "700", false},
+      {"100", "150", true}, {"100", "200", true}, {"100", "300", true}, {"100", "400", true},
+      {"100", "500", true}, {"375", "400", true}, {"450", "450", true}, {"450", "500", true},
+      {"450", "700", true}, {"600", "700", true}})
+}
+
+func newTestFile(number uint64, smallest, largest []byte) *FileMetaData {
+  return &FileMetaData{Number:number, FileSize:0, Smallest:smallest, Largest:largest}
+}
+
+func TestAddBoundaryInputsEmptyFileSets(t *testing.T) {
+  icmp := NewInternalKeyComparator(DefaultComparator)
+  if files := addBoundaryInputs(&icmp, nil, nil); len(files) != 0 {
+    t.Error("Unexpected boundary inputs: ", files)
+  }
+}
+
+func TestAddBoundaryInputsEmptyLevelFiles(t *testing.T) {
+  icmp := NewInternalKeyComparator(DefaultComparator)
+  f1 := newTestFile(1, AppendInternalKey(nil, []byte("100"), 2, TypeValue),
+      AppendInternalKey(nil, []byte("100"), 1, TypeValue))
+  files := addBoundaryInputs(&icmp, nil, []*FileMetaData{f1})
+  if len(files) != 1 || files[0] != f1 {
+    t.Error("Unexpected boundary inputs: ", files)
+  }
+}
+
+func TestAddBoundaryInputsEmptyCompactionFiles(t *testing.T) {
+  icmp := NewInternalKeyComparator(DefaultComparator)
+  f1 := newTestFile(1, AppendInternalKey(nil, []byte("100"), 2, TypeValue),
+      AppendInternalKey(nil, []byte("100"), 1, TypeValue))
+  if files := addBoundaryInputs(&icmp, []*FileMetaData{f1}, nil); len(files) != 0 {
+    t.Error("Unexpected boundary inputs: ", files)
+  }
+}
+
+func TestAddBoundaryInputsNoBoundaryFiles(t *testing.T) {
+  icmp := NewInternalKeyComparator(DefaultComparator)
+  f1 := newTestFile(1, AppendInternalKey(nil, []byte("100"), 2, TypeValue),
+      AppendInternalKey(nil, []byte("100"), 1, TypeValue))
+  f2 := newTestFile(2, AppendInternalKey(nil, []byte("200"), 2, TypeValue),
+      AppendInternalKey(nil, []byte("200"), 1, TypeValue))
+  f3 := newTestFile(3, AppendInternalKey(nil, []byte("300"), 2, TypeValue),
+      AppendInternalKey(nil, []byte("300"), 1, TypeValue))
+  files := addBoundaryInputs(&icmp, []*FileMetaData{f3, f2, f1}, []*FileMetaData{f2, f3})
+  if len(files) != 2 {
+    t.Error("Unexpected boundary inputs: ", files)
+  }
+}
+
+func TestAddBoundaryInputsOneBoundaryFile(t *testing.T) {
+  icmp := NewInternalKeyComparator(DefaultComparator)
+  f1 := newTestFile(1, AppendInternalKey(nil, []byte("100"), 3, TypeValue),
+      AppendInternalKey(nil, []byte("100"), 2, TypeValue))
+  f2 := newTestFile(2, AppendInternalKey(nil, []byte("100"), 1, TypeValue),
+      AppendInternalKey(nil, []byte("200"), 3, TypeValue))
+  f3 := newTestFile(3, AppendInternalKey(nil, []byte("300"), 2, TypeValue),
+      AppendInternalKey(nil, []byte("300"), 1, TypeValue))
+  files := addBoundaryInputs(&icmp, []*FileMetaData{f3, f2, f1}, []*FileMetaData{f1})
+  if len(files) != 2 || files[0] != f1 || files[1] != f2 {
+    t.Error("Unexpected boundary inputs: ", files)
+  }
+}
+
+func TestAddBoundaryInputsTwoBoundaryFiles(t *testing.T) {
+  icmp := NewInternalKeyComparator(DefaultComparator)
+  f1 := newTestFile(1, AppendInternalKey(nil, []byte("100"), 6, TypeValue),
+      AppendInternalKey(nil, []byte("100"), 5, TypeValue))
+  f2 := newTestFile(2, AppendInternalKey(nil, []byte("100"), 2, TypeValue),
+      AppendInternalKey(nil, []byte("300"), 1, TypeValue))
+  f3 := newTestFile(3, AppendInternalKey(nil, []byte("100"), 4, TypeValue),
+      AppendInternalKey(nil, []byte("100"), 3, TypeValue))
+  files := addBoundaryInputs(&icmp, []*FileMetaData{f2, f3, f1}, []*FileMetaData{f1})
+  if len(files) != 3 || files[0] != f1 || files[1] != f3 || files[2] != f2 {
+    t.Error("Unexpected boundary inputs: ", files)
+  }
+}
+
+func TestVersionBuilder(t *testing.T) {
+  options := &Options{Env:DefaultEnv()}
+  vset := NewVersionSet("/tmp/version_set_test", options,
+      NewTableCache("/tmp/version_set_test", options, 10), NewInternalKeyComparator(DefaultComparator))
+
+  edit := NewVersionEdit()
+  edit.AddFile(1, 10, 100, AppendInternalKey(nil, []byte("c"), 1, TypeValue),
+      AppendInternalKey(nil, []byte("d"), 1, TypeValue))
+  edit.AddFile(1, 11, 100, AppendInternalKey(nil, []byte("a"), 1, TypeValue),
+      AppendInternalKey(nil, []byte("b"), 1, TypeValue))
+  edit.AddFile(0, 12, 100, AppendInternalKey(nil, []byte("a"), 2, TypeValue),
+      AppendInternalKey(nil, []byte("z"), 2, TypeValue))
+
+  v := newVersion(vset)
+  builder := newVersionBuilder(vset, vset.Current())
+  builder.Apply(edit)
+  if err := builder.SaveTo(v); err != nil {
+    t.Fatal("Cannot save version: ", err)
+  }
+  if v.NumFiles(0) != 1 || v.NumFiles(1) != 2 {
+    t.Fatal("Unexpected number of files: ", v)
+  }
+  if v.files[1][0].Number != 11 || v.files[1][1].Number != 10 {
+    t.Error("Files should be sorted by smallest key: ", v)
+  }
+
+  edit = NewVersionEdit()
+  edit.DeleteFile(1, 11)
+  v2 := newVersion(vset)
+  builder = newVersionBuilder(vset, v)
+  builder.Apply(edit)
+  if err := builder.SaveTo(v2); err != nil {
+    t.Fatal("Cannot save version: ", err)
+  }
+  if v2.NumFiles(1) != 1 || v2.files[1][0].Number != 10 {
+    t.Error("Deleted file should be dropped: ", v2)
+  }
+}
@@ -0,0 +1,183 @@
+package leveldb
+
+import (
+  "encoding/binary"
+)
+
+// WriteBatch header has an 8-byte sequence number followed by a 4-byte count.
+const writeBatchHeaderSize = 12
+
+// WriteBatch holds a collection of updates to apply atomically to a DB.
+//
+// The rep is:
+//   sequence: fixed64
+//   count: fixed32
+//   data: record[count]
+// record :=
+//   TypeValue varstring varstring |
+//   TypeDeletion varstring
+// varstring :=
+//   len: varint32
+//   data: uint8[len]
+type WriteBatch struct {
+  rep []byte
+}
+
+// Handler for the records stored in a WriteBatch.
+type WriteBatchHandler interface {
+  Put(key, value []byte)
+  Delete(key []byte)
+}
+
+func NewWriteBatch() *WriteBatch {
+  batch := &WriteBatch{}
+  batch.rep = make([]byte, writeBatchHeaderSize)
+  return batch
+}
+
+// Store the mapping key->value in the database.
+func (batch *WriteBatch) Put(key, value []byte) {
+  batch.init()
+  batch.setCount(batch.count() + 1)
+  batch.rep = append(batch.rep, byte(TypeValue))
+  batch.rep = appendLengthPrefixedSlice(batch.rep, key)
+  batch.rep = appendLengthPrefixedSlice(batch.rep, value)
+}
+
+// Erase the mapping for key, if any, from the database.
+func (batch *WriteBatch) Delete(key []byte) {
+  batch.init()
+  batch.setCount(batch.count() + 1)
+  batch.rep = append(batch.rep, byte(TypeDeletion))
+  batch.rep = appendLengthPrefixedSlice(batch.rep, key)
+}
+
+// Clear all updates buffered in this batch.
+func (batch *WriteBatch) Clear() {
+  batch.rep = batch.rep[:0]
+  batch.init()
+}
+
+// Number of updates buffered in this batch.
+func (batch *WriteBatch) Count() int {
+  batch.init()
+  return batch.count()
+}
+
+// Copy the operations in source to this batch.
+func (batch *WriteBatch) Append(source *WriteBatch) {
+  batch.init()
+  source.init()
+  batch.setCount(batch.count() + source.count())
+  batch.rep = append(batch.rep, source.rep[writeBatchHeaderSize:]...)
+}
+
+// Size of the serialized batch.
+func (batch *WriteBatch) ApproximateSize() int {
+  batch.init()
+  return len(batch.rep)
+}
+
+// Call handler for every record of the batch, in the order they were added.
+func (batch *WriteBatch) Iterate(handler WriteBatchHandler) error {
+  if len(batch.rep) < writeBatchHeaderSize {
+    return CorruptionError("malformed WriteBatch (too small)")
+  }
+
+  found := 0
+  input := batch.rep[writeBatchHeaderSize:]
+  for len(input) > 0 {
+    found++
+    tag := ValueType(input[0])
+    input = input[1:]
+    var key, value []byte
+    var ok bool
+    switch tag {
+    case TypeValue:
+      if key, input, ok = consumeLengthPrefixedSlice(input); !ok {
+        return CorruptionError("bad WriteBatch Put")
+      }
+      if value, input, ok = consumeLengthPrefixedSlice(input); !ok {
+        return CorruptionError("bad WriteBatch Put")
+      }
+      handler.Put(key, value)
+    case TypeDeletion:
+      if key, input, ok = consumeLengthPrefixedSlice(input); !ok {
+        return CorruptionError("bad WriteBatch Delete")
+      }
+      handler.Delete(key)
+    default:
+      return CorruptionError("unknown WriteBatch tag")
+    }
+  }
+
+  if found != batch.count() {
+    return CorruptionError("WriteBatch has wrong count")
+  }
+  return nil
+}
+
+// Apply the records of the batch to mem with consecutive sequence numbers,
+// starting at the sequence number stored in the batch header.
+func (batch *WriteBatch) InsertInto(mem *MemTable) error {
+  inserter := &memTableInserter{sequence:batch.sequence(), mem:mem}
+  return batch.Iterate(inserter)
+}
+
+// Replace the contents of the batch with a serialized batch read from a log.
+func (batch *WriteBatch) setContents(contents []byte) {
+  batch.rep = append(batch.rep[:0], contents...)
+}
+
+func (batch *WriteBatch) init() {
+  if len(batch.rep) < writeBatchHeaderSize {
+    batch.rep = make([]byte, writeBatchHeaderSize)
+  }
+}
+
+func (batch *WriteBatch) count() int {
+  return int(binary.LittleEndian.Uint32(batch.rep[8:12]))
+}
+
+func (batch *WriteBatch) setCount(n int) {
+  binary.LittleEndian.PutUint32(batch.rep[8:12], uint32(n))
+}
+
+func (batch *WriteBatch) sequence() SequenceNumber {
+  return SequenceNumber(binary.LittleEndian.Uint64(batch.rep[:8]))
+}
+
+func (batch *WriteBatch) setSequence(seq SequenceNumber) {
+  binary.LittleEndian.PutUint64(batch.rep[:8], uint64(seq))
+}
+
+// Inserts the records of a batch into a memtable.
+type memTableInserter struct {
+  sequence SequenceNumber
+  mem *MemTable
+}
+
+func (inserter *memTableInserter) Put(key, value []byte) {
+  inserter.mem.Add(inserter.sequence, TypeValue, key, value)
+  inserter.sequence++
+}
+
+func (inserter *memTableInserter) Delete(key []byte) {
+  inserter.mem.Add(inserter.sequence, TypeDeletion, key, nil)
+  inserter.sequence++
+}
+
+func appendLengthPrefixedSlice(dst []byte, value []byte) []byte {
+  var buf [binary.MaxVarintLen32]byte
+  n := binary.PutUvarint(buf[:], uint64(len(value)))
+  dst = append(dst, buf[:n]...)
+  return append(dst, value...)
+}
+
+func consumeLengthPrefixedSlice(input []byte) ([]byte, []byte, bool) {
+  l, n := binary.Uvarint(input)
+  if n <= 0 || uint64(len(input) - n) < l {
+    return nil, input, false
+  }
+  return input[n:n + int(l)], input[n + int(l):], true
+}
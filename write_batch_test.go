@@ -0,0 +1,122 @@
+package leveldb
+
+import (
+  "fmt"
+  "testing"
+)
+
+// Render the contents of batch after applying it to a fresh memtable.
+func printWriteBatchContents(batch *WriteBatch) string {
+  mem := NewMemTable(NewInternalKeyComparator(DefaultComparator))
+  err := batch.InsertInto(mem)
+  state := ""
+  count := 0
+  iter := mem.NewIterator()
+  for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+    parsed, ok := ParseInternalKey(iter.Key())
+    if !ok {
+      return "ParseError()"
+    }
+    switch parsed.Type {
+    case TypeValue:
+      state += fmt.Sprintf("Put(%s, %s)", parsed.UserKey, iter.Value())
+    case TypeDeletion:
+      state += fmt.Sprintf("Delete(%s)", parsed.UserKey)
+    }
+    state += fmt.Sprintf("@%d", parsed.Sequence)
+    count++
+  }
+  if err != nil {
+    state += "ParseError()"
+  } else if count != batch.Count() {
+    state += "CountMismatch()"
+  }
+  return state
+}
+
+func TestWriteBatchEmpty(t *testing.T) {
+  batch := NewWriteBatch()
+  if printWriteBatchContents(batch) != "" {
+    t.Error("Empty batch should have no contents.")
+  }
+  if batch.Count() != 0 {
+    t.Error("Empty batch should have zero count.")
+  }
+}
+
+func TestWriteBatchMultiple(t *testing.T) {
+  batch := NewWriteBatch()
+  batch.Put([]byte("foo"), []byte("bar"))
+  batch.Delete([]byte("box"))
+  batch.Put([]byte("baz"), []byte("boo"))
+  batch.setSequence(100)
+  if batch.sequence() != 100 {
+    t.Error("Unexpected sequence: ", batch.sequence())
+  }
+  if batch.Count() != 3 {
+    t.Error("Unexpected count: ", batch.Count())
+  }
+  expected := "Put(baz, boo)@102Delete(box)@101Put(foo, bar)@100"
+  if s := printWriteBatchContents(batch); s != expected {
+    t.Error("Unexpected contents: ", s)
+  }
+}
+
+func TestWriteBatchCorruption(t *testing.T) {
+  batch := NewWriteBatch()
+  batch.Put([]byte("foo"), []byte("bar"))
+  batch.Delete([]byte("box"))
+  batch.setSequence(200)
+  batch.rep = batch.rep[:len(batch.rep) - 1]
+  expected := "Put(foo, bar)@200ParseError()"
+  if s := printWriteBatchContents(batch); s != expected {
+    t.Error("Unexpected contents: ", s)
+  }
+}
+
+func TestWriteBatchAppend(t *testing.T) {
+  b1 := NewWriteBatch()
+  b2 := NewWriteBatch()
+  b1.setSequence(200)
+  b2.setSequence(300)
+  b1.Append(b2)
+  if s := printWriteBatchContents(b1); s != "" {
+    t.Error("Unexpected contents: ", s)
+  }
+
+  b2.Put([]byte("a"), []byte("va"))
+  b1.Append(b2)
+  if s := printWriteBatchContents(b1); s != "Put(a, va)@200" {
+    t.Error("Unexpected contents: ", s)
+  }
+
+  b2.Clear()
+  b2.Put([]byte("b"), []byte("vb"))
+  b1.Append(b2)
+  if s := printWriteBatchContents(b1); s != "Put(a, va)@200Put(b, vb)@201" {
+    t.Error("Unexpected contents: ", s)
+  }
+
+  b2.Delete([]byte("foo"))
+  b1.Append(b2)
+  expected := "Put(a, va)@200Put(b, vb)@202Put(b, vb)@201Delete(foo)@203"
+  if s := printWriteBatchContents(b1); s != expected {
+    t.Error("Unexpected contents: ", s)
+  }
+}
+
+func TestWriteBatchApproximateSize(t *testing.T) {
+  batch := NewWriteBatch()
+  emptySize := batch.ApproximateSize()
+
+  batch.Put([]byte("foo"), []byte("bar"))
+  oneKeySize := batch.ApproximateSize()
+  if emptySize >= oneKeySize {
+    t.Error("Size should grow after Put.")
+  }
+
+  batch.Delete([]byte("box"))
+  if oneKeySize >= batch.ApproximateSize() {
+    t.Error("Size should grow after Delete.")
+  }
+}